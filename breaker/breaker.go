@@ -0,0 +1,181 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/types"
+	"sync"
+	"time"
+)
+
+// State 熔断器状态
+type State string
+
+const (
+	StateClosed   State = "closed"    // 正常发送
+	StateOpen     State = "open"      // 熔断中，请求快速失败
+	StateHalfOpen State = "half_open" // 试探中，只放行少量请求
+)
+
+const (
+	defaultFailureThreshold    = 5
+	defaultOpenTimeout         = 30 * time.Second
+	defaultHalfOpenMaxRequests = 1
+	defaultSuccessThreshold    = 1
+)
+
+// ErrOpen 熔断中拒绝发送，熔断结束后可以重试
+var ErrOpen = notify.NewError("circuit_open", true, errors.New("breaker: circuit open"))
+
+// Status 熔断器的当前状态
+type Status struct {
+	State     State     `json:"state"`
+	Failures  int       `json:"failures"`   // 连续失败次数
+	LastError string    `json:"last_error"` // 最近一次计为失败的错误
+	OpenedAt  time.Time `json:"opened_at"`  // 最近一次熔断的时间
+	Since     time.Time `json:"since"`      // 进入当前状态的时间
+}
+
+// Breaker 熔断器，连续失败达到阈值后熔断，OpenTimeout 后进入半开状态放行试探请求，
+// 试探成功后恢复，失败则重新熔断
+type Breaker struct {
+	conf types.BreakerConfig
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	successes int
+	probes    int
+	lastError string
+	openedAt  time.Time
+	since     time.Time
+}
+
+// New 创建熔断器，conf 为 nil 时返回 nil，nil 熔断器放行所有请求
+func New(conf *types.BreakerConfig) *Breaker {
+	if conf == nil {
+		return nil
+	}
+	c := *conf
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = defaultFailureThreshold
+	}
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = defaultOpenTimeout
+	}
+	if c.HalfOpenMaxRequests <= 0 {
+		c.HalfOpenMaxRequests = defaultHalfOpenMaxRequests
+	}
+	if c.SuccessThreshold <= 0 {
+		c.SuccessThreshold = defaultSuccessThreshold
+	}
+	return &Breaker{conf: c, state: StateClosed, since: time.Now()}
+}
+
+// Allow 判断是否允许发送，允许时调用方必须在发送结束后调用 Done
+func (b *Breaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh(time.Now())
+	switch b.state {
+	case StateOpen:
+		return ErrOpen
+	case StateHalfOpen:
+		if b.probes >= b.conf.HalfOpenMaxRequests {
+			return ErrOpen
+		}
+		b.probes++
+	}
+	return nil
+}
+
+// Available 当前是否会放行新的请求，与 Allow 的判断相同，但不占用半开状态的试探名额
+func (b *Breaker) Available() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh(time.Now())
+	switch b.state {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		return b.probes < b.conf.HalfOpenMaxRequests
+	}
+	return true
+}
+
+// Done 记录一次发送的结果
+// 可重试的临时错误（包括超时）计为失败；永久错误说明渠道可以正常响应，计为成功；ctx 取消不计入
+func (b *Breaker) Done(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.state == StateHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return
+	case err != nil && notify.IsRetryable(err):
+		b.failures++
+		b.successes = 0
+		b.lastError = err.Error()
+		if b.state == StateHalfOpen || b.failures >= b.conf.FailureThreshold {
+			b.setState(StateOpen, now)
+			b.openedAt = now
+		}
+	default:
+		b.failures = 0
+		if b.state == StateHalfOpen {
+			b.successes++
+			if b.successes >= b.conf.SuccessThreshold {
+				b.setState(StateClosed, now)
+			}
+		}
+	}
+}
+
+// Cancel 放弃已允许但没有发出的请求，不计入结果
+func (b *Breaker) Cancel() {
+	b.Done(context.Canceled)
+}
+
+// Status 返回熔断器的当前状态
+func (b *Breaker) Status() Status {
+	if b == nil {
+		return Status{State: StateClosed}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh(time.Now())
+	return Status{
+		State:     b.state,
+		Failures:  b.failures,
+		LastError: b.lastError,
+		OpenedAt:  b.openedAt,
+		Since:     b.since,
+	}
+}
+
+// refresh 熔断超过 OpenTimeout 后进入半开状态
+func (b *Breaker) refresh(now time.Time) {
+	if b.state == StateOpen && now.Sub(b.openedAt) >= b.conf.OpenTimeout {
+		b.setState(StateHalfOpen, now)
+	}
+}
+
+func (b *Breaker) setState(state State, now time.Time) {
+	b.state = state
+	b.since = now
+	b.successes = 0
+	b.probes = 0
+}
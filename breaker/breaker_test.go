@@ -0,0 +1,65 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/types"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := New(&types.BreakerConfig{FailureThreshold: 2, OpenTimeout: 20 * time.Millisecond})
+	down := notify.Retryable(errors.New("503"))
+
+	// 永久错误和 ctx 取消不计为失败
+	for _, err := range []error{notify.Permanent(errors.New("bad request")), context.Canceled, down} {
+		if allowErr := b.Allow(); allowErr != nil {
+			t.Fatal(allowErr)
+		}
+		b.Done(err)
+	}
+	if s := b.Status(); s.State != StateClosed || s.Failures != 1 {
+		t.Fatalf("status = %+v, want closed with 1 failure", s)
+	}
+
+	_ = b.Allow()
+	b.Done(down)
+	if s := b.Status(); s.State != StateOpen || s.LastError != "503" {
+		t.Fatalf("status = %+v, want open", s)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrOpen) || !notify.IsRetryable(err) {
+		t.Fatalf("Allow while open = %v, want retryable ErrOpen", err)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if !b.Available() {
+		t.Fatal("half-open breaker with free probes should be available")
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatalf("first probe rejected: %v", err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Errorf("second concurrent probe = %v, want ErrOpen", err)
+	}
+	if b.Available() {
+		t.Error("half-open breaker without free probes should not be available")
+	}
+	b.Done(down)
+	if s := b.Status(); s.State != StateOpen {
+		t.Fatalf("failed probe status = %+v, want open", s)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	_ = b.Allow()
+	b.Done(nil)
+	if s := b.Status(); s.State != StateClosed || s.Failures != 0 {
+		t.Errorf("successful probe status = %+v, want closed", s)
+	}
+
+	var nilBreaker *Breaker
+	if err := nilBreaker.Allow(); err != nil || nilBreaker.Status().State != StateClosed {
+		t.Error("nil breaker should allow everything")
+	}
+}
@@ -1,32 +1,36 @@
 package dingding
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/v-mars/notify"
+	md "github.com/v-mars/notify/markdown"
 	"github.com/v-mars/notify/result"
 	"github.com/v-mars/notify/types"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
 // getsign generate a sign when secure level is needsign
 func getsign(secret string, now string) string {
-	signstr := now + "\n" + secret
-	// HmacSHA256
-	h := hmac.New(sha256.New, []byte(secret))
-	_, _ = h.Write([]byte(signstr))
-	hm := h.Sum(nil)
-	// Base64 encode
-	b := base64.StdEncoding.EncodeToString(hm)
 	// urlEncode
-	sign := url.QueryEscape(b)
-	return sign
+	return url.QueryEscape(signature(secret, now))
+}
+
+// signature base64(HmacSHA256(secret, timestamp + "\n" + secret))，
+// 用于机器人发送消息的签名和校验回调请求的 sign 请求头
+func signature(secret string, timestamp string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	_, _ = h.Write([]byte(timestamp + "\n" + secret))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
 }
 
 // Secrue dingding secrue setting
@@ -47,37 +51,27 @@ type Ding struct {
 	types.DingDing
 	sl     Secrue
 	Result *result.SendResult
-	Data   any
 }
 
-// Result post resp
-type Result struct {
-	ErrCode int    `json:"errcode"`
-	ErrMsg  string `json:"errmsg"`
+// retryableErrCodes 可以重试的错误码：系统繁忙、发送速度太快被限流，
+// 其他错误码（如 310000 关键词/签名/IP 校验失败、300001 token 无效）为永久错误
+var retryableErrCodes = map[int]bool{
+	-1:     true,
+	130101: true,
+	410100: true,
 }
 
-type text struct {
-	Content  string `json:"content"`
-	Title    string `json:"title"`
-	Text     string `json:"text"`
-	PhotoURL string `json:"photoURL"`
-}
-type markdown struct {
-	Title string `json:"title"`
-	Text  string `json:"text"`
-}
+// apiClient 调用钉钉接口的 http 客户端
+var apiClient = &http.Client{Timeout: 30 * time.Second}
 
-type at struct {
-	AtMobiles []string `json:"atMobiles"`
-	IsAtAll   bool     `json:"isAtAll"`
-}
+// mobileRe 手机号：中国大陆 11 位手机号，或带 +国家码- 前缀的号码；
+// 其他接收人（包括纯数字的 userid）视为 userid，其他格式的手机号需要加 "mobile:" 前缀
+var mobileRe = regexp.MustCompile(`^(1[3-9]\d{9}|\+\d{1,4}-\d{6,14})$`)
 
-// SendMsg post json data
-type SendMsg struct {
-	MsgType  string   `json:"msgtype"`
-	Markdown markdown `json:"markdown"`
-	Text     any      `json:"text"`
-	At       at       `json:"at"`
+// Result post resp
+type Result struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
 }
 
 // NewDing init a Dingding send conf
@@ -104,9 +98,62 @@ func NewDing(webhookurl string, sl Secrue, secret string) *Ding {
 }
 
 // Send to notify tos is phone number
-func (d *Ding) Send(tos []string, title string, content string) (sendResult *result.SendResult, err error) {
+func (d *Ding) Send(tos []string, title string, content string) (*result.SendResult, error) {
+	return d.SendContext(context.Background(), tos, title, content)
+}
+
+// SendContext 发送消息，ctx 超时或取消时中断请求
+func (d *Ding) SendContext(ctx context.Context, tos []string, title string, content string) (*result.SendResult, error) {
+	return d.SendMessage(ctx, tos, notify.NewMessage(title, content))
+}
+
+// SendMessage 发送结构化消息，tos 与 msg.Mentions.Users 都会被 @。
+// 消息带有 Markdown 正文或链接时，text 类型自动升级为 markdown；
+// Metadata 中带有 MetadataMessage 时发送该消息
+func (d *Ding) SendMessage(ctx context.Context, tos []string, msg *notify.Message) (*result.SendResult, error) {
+	if m, err := notify.MetadataValue[SendMsg](msg, MetadataMessage); err != nil || m != nil {
+		if err != nil {
+			return nil, err
+		}
+		return d.SendPayload(ctx, tos, m)
+	}
+	msgType := d.MsgType
+	if msgType == MsgTypeText && (msg.Markdown != "" || len(msg.Links) > 0) {
+		msgType = MsgTypeMarkdown
+	}
+	var m *SendMsg
+	if msgType == MsgTypeMarkdown {
+		m = NewMarkdown(msg.Title, markdownBody(msg))
+	} else {
+		m = NewText(strings.TrimPrefix(msg.Title+"\n"+textBody(msg), "\n"))
+	}
+	mobiles, userIds := splitAts(msg.Mentions.Users)
+	m.AtMobiles(mobiles...).AtUserIds(userIds...)
+	if msg.Mentions.All {
+		m.AtAll()
+	}
+	return d.SendPayload(ctx, tos, m)
+}
+
+// SendPayload 校验并发送用 NewText、NewActionCard 等创建的消息，校验失败时不发送，返回永久错误。
+// tos 中的手机号加入 atMobiles，其他加入 atUserIds
+func (d *Ding) SendPayload(ctx context.Context, tos []string, m *SendMsg) (*result.SendResult, error) {
+	if err := m.Validate(); err != nil {
+		err = notify.Permanent(fmt.Errorf("钉钉消息校验失败: %w", err))
+		return &result.SendResult{ChannelType: NotifyTypeDingDing, SendTime: time.Now(), Error: result.PtrOf(err.Error())}, err
+	}
+	return d.sendRobot(ctx, m.withAt(splitAts(tos)))
+}
+
+// sendRobot 通过群机器人发送，机器人接口只返回整体结果，每个被 @ 的人记录为与整体相同的结果
+func (d *Ding) sendRobot(ctx context.Context, sendMsg *SendMsg) (sendResult *result.SendResult, err error) {
 	sendResult = d.Result
+	sendResult.Recipients = nil
 	defer func() {
+		if sendMsg.At != nil {
+			sendResult.AddRecipients(sendMsg.At.AtMobiles, "", err)
+			sendResult.AddRecipients(sendMsg.At.AtUserIds, "", err)
+		}
 		sendResult.CostMs = time.Now().Sub(sendResult.SendTime).Milliseconds()
 		sendResult.ChannelMsgID = result.PtrOf(fmt.Sprintf("%d", time.Now().UnixNano()))
 		sendResult.Success = err == nil
@@ -121,25 +168,8 @@ func (d *Ding) Send(tos []string, title string, content string) (sendResult *res
 		sign := getsign(d.Secret, now)
 		reqUrl += fmt.Sprintf("&timestamp=%s&sign=%s", now, sign)
 	}
-	sendMsg := SendMsg{
-		MsgType: d.MsgType,
-		Text: text{
-			Content: title + "\n" + content + "\n",
-		},
-		Markdown: markdown{
-			Title: title,
-			Text:  content,
-		},
-		At: at{
-			AtMobiles: tos,
-			IsAtAll:   false,
-		},
-	}
-	if d.Data != nil {
-		sendMsg.Text = d.Data
-	}
 
-	resp, err := notify.JSONPost(http.MethodPost, reqUrl, sendMsg, http.DefaultClient, nil)
+	resp, err := notify.JSONPostContext(ctx, http.MethodPost, reqUrl, sendMsg, apiClient, nil)
 	if err != nil {
 		return sendResult, err
 	}
@@ -149,13 +179,74 @@ func (d *Ding) Send(tos []string, title string, content string) (sendResult *res
 		return sendResult, err
 	}
 	if res.ErrCode != 0 {
-		return sendResult, fmt.Errorf("errmsg: %s errcode: %d", res.ErrMsg, res.ErrCode)
+		return sendResult, notify.NewError(strconv.Itoa(res.ErrCode), retryableErrCodes[res.ErrCode],
+			fmt.Errorf("errmsg: %s errcode: %d", res.ErrMsg, res.ErrCode))
 	}
 	return sendResult, nil
 }
 
+// splitAts 按格式把接收人分为手机号和 userid，"mobile:" 和 "userid:" 前缀可以显式指定
+func splitAts(tos []string) (mobiles, userIds []string) {
+	for _, to := range tos {
+		to = strings.TrimSpace(to)
+		if v, ok := strings.CutPrefix(to, "mobile:"); ok {
+			mobiles = append(mobiles, v)
+			continue
+		}
+		if v, ok := strings.CutPrefix(to, "userid:"); ok {
+			userIds = append(userIds, v)
+			continue
+		}
+		if mobileRe.MatchString(to) {
+			mobiles = append(mobiles, to)
+		} else if to != "" {
+			userIds = append(userIds, to)
+		}
+	}
+	return mobiles, userIds
+}
+
+// textBody 纯文本正文，附加链接
+func textBody(msg *notify.Message) string {
+	body := msg.TextBody()
+	for _, link := range msg.Links {
+		body += "\n" + link.Text + ": " + link.URL
+	}
+	return body
+}
+
+// markdownBody markdown 正文转换为钉钉支持的语法，附加链接，
+// 被 @ 的人在发送时追加到正文中
+func markdownBody(msg *notify.Message) string {
+	body := msg.TextBody()
+	if msg.Markdown != "" {
+		body = md.Convert(msg.Markdown, md.DingTalk)
+	}
+	for _, link := range msg.Links {
+		body += fmt.Sprintf("\n\n[%s](%s)", link.Text, link.URL)
+	}
+	return body
+}
+
 const NotifyTypeDingDing = "dingding"
 
+func init() {
+	notify.Register(NotifyTypeDingDing, func(section notify.Section) (notify.Sender, error) {
+		var conf types.DingDing
+		if err := section.Decode(&conf); err != nil {
+			return nil, err
+		}
+		// 默认使用签名安全模式
+		d := NewDing(conf.WebhookUrl, Sign, conf.Secret)
+		msgType := d.MsgType
+		d.DingDing = conf
+		if conf.MsgType == "" {
+			d.MsgType = msgType
+		}
+		return d, nil
+	})
+}
+
 func (d *Ding) ChannelType() string {
 	return NotifyTypeDingDing
 }
@@ -0,0 +1,191 @@
+package dingding
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/v-mars/notify"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuilders(t *testing.T) {
+	card := NewMultiActionCard("发布", "**api** v2", Button{Title: "查看", ActionURL: "https://x.io"}).Horizontal()
+	b, _ := json.Marshal(card)
+	want := `{"msgtype":"actionCard","actionCard":{"title":"发布","text":"**api** v2","btnOrientation":"1","btns":[{"title":"查看","actionURL":"https://x.io"}]}}`
+	if string(b) != want {
+		t.Errorf("actionCard = %s", b)
+	}
+	if err := NewActionCard("t", "x", "", "https://x.io").Validate(); err == nil || !strings.Contains(err.Error(), "singleTitle") {
+		t.Errorf("single actionCard err = %v", err)
+	}
+	if err := NewFeedCard().Validate(); err == nil {
+		t.Error("empty feedCard should be invalid")
+	}
+	if err := NewLink("t", "x", "https://x.io", "").Validate(); err != nil {
+		t.Error(err)
+	}
+
+	m := NewMarkdown("t", "body @138").AtMobiles("138")
+	got := m.withAt([]string{"13800000000"}, []string{"manager01"})
+	if got.Markdown.Text != "body @138\n\n@13800000000 @manager01" || len(got.At.AtMobiles) != 2 || got.At.AtUserIds[0] != "manager01" {
+		t.Errorf("withAt = %+v %+v", got.Markdown, got.At)
+	}
+	if m.Markdown.Text != "body @138" || len(m.At.AtMobiles) != 1 {
+		t.Error("withAt modified the original message")
+	}
+}
+
+func TestDingRobot(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = nil
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	}))
+	defer srv.Close()
+
+	d := NewDing(srv.URL+"/robot/send?access_token=x", Sign, "SEC1")
+	msg := &notify.Message{Title: "告警", Text: "cpu high", Mentions: notify.Mentions{Users: []string{"user01"}}}
+	r, err := d.SendMessage(t.Context(), []string{"+86-13800000000"}, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := got["at"].(map[string]any)
+	if at["atMobiles"].([]any)[0] != "+86-13800000000" || at["atUserIds"].([]any)[0] != "user01" {
+		t.Errorf("at = %v", at)
+	}
+	if text := got["text"].(map[string]any)["content"]; text != "告警\ncpu high\n\n@+86-13800000000 @user01" {
+		t.Errorf("text = %q", text)
+	}
+	if len(r.Recipients) != 2 || r.FailedRecipients() != nil {
+		t.Errorf("recipients = %+v", r.Recipients)
+	}
+
+	msg = &notify.Message{Title: "发布", Metadata: map[string]any{
+		MetadataMessage: NewFeedCard(FeedLink{Title: "a", MessageURL: "https://x.io/a", PicURL: "https://x.io/a.png"}),
+	}}
+	if _, err = d.SendMessage(t.Context(), nil, msg); err != nil {
+		t.Fatal(err)
+	}
+	if got["msgtype"] != "feedCard" || got["at"] != nil {
+		t.Errorf("feedCard payload = %v", got)
+	}
+
+	msg.Metadata[MetadataMessage] = NewActionCard("t", "x", "", "")
+	if _, err = d.SendMessage(t.Context(), nil, msg); err == nil || notify.IsRetryable(err) {
+		t.Errorf("invalid payload err = %v", err)
+	}
+}
+
+func TestSplitAts(t *testing.T) {
+	mobiles, userIds := splitAts([]string{"13800000000", "+86-13800000000", "mobile:0755123456", "123456789", "userid:13900000000", "manager01"})
+	if !reflect.DeepEqual(mobiles, []string{"13800000000", "+86-13800000000", "0755123456"}) {
+		t.Errorf("mobiles = %v", mobiles)
+	}
+	// 纯数字的 userid 不视为手机号
+	if !reflect.DeepEqual(userIds, []string{"123456789", "13900000000", "manager01"}) {
+		t.Errorf("userIds = %v", userIds)
+	}
+}
+
+func TestRobotHandler(t *testing.T) {
+	var replied map[string]any
+	session := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&replied)
+		_, _ = w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	}))
+	defer session.Close()
+
+	router := NewRouter()
+	router.Handle("/ack", func(ctx context.Context, cmd *Command) (*SendMsg, error) {
+		if len(cmd.Args) == 0 {
+			return nil, errors.New("缺少告警 ID")
+		}
+		if err := cmd.Message.Reply(ctx, NewText("处理中")); err != nil {
+			return nil, err
+		}
+		return NewText(cmd.Message.SenderNick + " 已认领 " + cmd.Args[0]).AtUserIds(cmd.Message.SenderStaffID), nil
+	})
+	if _, err := NewRobotHandler("", router); err == nil {
+		t.Error("NewRobotHandler without AppSecret should fail")
+	}
+	h, err := NewRobotHandler("secret", router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionWebhookOrigins = append(sessionWebhookOrigins, session.URL)
+	defer func() { sessionWebhookOrigins = sessionWebhookOrigins[:2] }()
+	webhook := session.URL
+
+	post := func(text string, ts int64, sign string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{
+			"msgtype":                   "text",
+			"text":                      map[string]string{"content": text},
+			"senderNick":                "张三",
+			"senderStaffId":             "u1",
+			"conversationType":          "2",
+			"sessionWebhook":            webhook,
+			"sessionWebhookExpiredTime": time.Now().Add(time.Hour).UnixMilli(),
+		})
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body)))
+		timestamp := strconv.FormatInt(ts, 10)
+		if sign == "" {
+			sign = signature("secret", timestamp)
+		}
+		req.Header.Set("timestamp", timestamp)
+		req.Header.Set("sign", sign)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	now := time.Now().UnixMilli()
+	w := post(" ACK 1024 ", now, "")
+	var got SendMsg
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.Text == nil || got.Text.Content != "张三 已认领 1024" {
+		t.Fatalf("reply = %d %s", w.Code, w.Body)
+	}
+	if got.At == nil || got.At.AtUserIds[0] != "u1" {
+		t.Errorf("at = %+v", got.At)
+	}
+	if replied["text"].(map[string]any)["content"] != "处理中" {
+		t.Errorf("session reply = %v", replied)
+	}
+
+	w = post("ack", now, "")
+	if !strings.Contains(w.Body.String(), "处理失败，请稍后重试") || strings.Contains(w.Body.String(), "缺少告警 ID") {
+		t.Errorf("error reply = %s", w.Body)
+	}
+	w = post("help", now, "")
+	if !strings.Contains(w.Body.String(), "可用的命令: ack") {
+		t.Errorf("unknown command reply = %s", w.Body)
+	}
+	if w = post("ack 1", now, "bad"); w.Code != http.StatusUnauthorized {
+		t.Errorf("bad sign code = %d", w.Code)
+	}
+	if w = post("ack 1", now-2*time.Hour.Milliseconds(), ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expired timestamp code = %d", w.Code)
+	}
+	// 不请求钉钉以外的 sessionWebhook
+	replied = nil
+	webhook = "http://169.254.169.254/latest"
+	if w = post("ack 2", now, ""); !strings.Contains(w.Body.String(), "处理失败，请稍后重试") || replied != nil {
+		t.Errorf("foreign sessionWebhook reply = %s", w.Body)
+	}
+	msg := &RobotMessage{SessionWebhook: "https://oapi.dingtalk.com@evil.io/robot"}
+	if err = msg.Reply(t.Context(), NewText("x")); err == nil || notify.IsRetryable(err) {
+		t.Errorf("userinfo sessionWebhook err = %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	(&RobotHandler{Handler: router}).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}")))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("handler without AppSecret code = %d", w.Code)
+	}
+}
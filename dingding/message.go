@@ -0,0 +1,273 @@
+package dingding
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// MetadataMessage notify.Message.Metadata 中的键，值为 *SendMsg，
+// 通过 Manager 发送 actionCard、feedCard、link 等消息
+const MetadataMessage = "dingtalk_message"
+
+// 消息类型
+const (
+	MsgTypeText       = "text"
+	MsgTypeMarkdown   = "markdown"
+	MsgTypeLink       = "link"
+	MsgTypeActionCard = "actionCard"
+	MsgTypeFeedCard   = "feedCard"
+)
+
+// actionCard 按钮的排列方向
+const (
+	BtnVertical   = "0"
+	BtnHorizontal = "1"
+)
+
+// Text 文本消息
+type Text struct {
+	Content string `json:"content"`
+}
+
+// Markdown markdown 消息，Title 显示在会话列表中
+type Markdown struct {
+	Title string `json:"title"`
+	Text  string `json:"text"`
+}
+
+// Link 链接消息
+type Link struct {
+	Title      string `json:"title"`
+	Text       string `json:"text"`
+	MessageURL string `json:"messageUrl"`
+	PicURL     string `json:"picUrl,omitempty"`
+}
+
+// ActionCard 卡片消息，SingleTitle 和 SingleURL 为整体跳转，Btns 为独立跳转的按钮，两者二选一
+type ActionCard struct {
+	Title          string   `json:"title"`
+	Text           string   `json:"text"`
+	SingleTitle    string   `json:"singleTitle,omitempty"`
+	SingleURL      string   `json:"singleURL,omitempty"`
+	BtnOrientation string   `json:"btnOrientation,omitempty"`
+	Btns           []Button `json:"btns,omitempty"`
+}
+
+// Button actionCard 的按钮
+type Button struct {
+	Title     string `json:"title"`
+	ActionURL string `json:"actionURL"`
+}
+
+// FeedCard 多条图文链接的消息
+type FeedCard struct {
+	Links []FeedLink `json:"links"`
+}
+
+// FeedLink feedCard 中的一条链接
+type FeedLink struct {
+	Title      string `json:"title"`
+	MessageURL string `json:"messageURL"`
+	PicURL     string `json:"picURL"`
+}
+
+// At 被 @ 的人，只对 text、markdown 和 actionCard 消息生效
+type At struct {
+	AtMobiles []string `json:"atMobiles,omitempty"`
+	AtUserIds []string `json:"atUserIds,omitempty"`
+	IsAtAll   bool     `json:"isAtAll,omitempty"`
+}
+
+// SendMsg 机器人消息，使用 NewText、NewMarkdown、NewLink、NewActionCard、NewMultiActionCard 和 NewFeedCard 创建
+type SendMsg struct {
+	MsgType    string      `json:"msgtype"`
+	Text       *Text       `json:"text,omitempty"`
+	Markdown   *Markdown   `json:"markdown,omitempty"`
+	Link       *Link       `json:"link,omitempty"`
+	ActionCard *ActionCard `json:"actionCard,omitempty"`
+	FeedCard   *FeedCard   `json:"feedCard,omitempty"`
+	At         *At         `json:"at,omitempty"`
+}
+
+// NewText 创建文本消息
+func NewText(content string) *SendMsg {
+	return &SendMsg{MsgType: MsgTypeText, Text: &Text{Content: content}}
+}
+
+// NewMarkdown 创建 markdown 消息
+func NewMarkdown(title, text string) *SendMsg {
+	return &SendMsg{MsgType: MsgTypeMarkdown, Markdown: &Markdown{Title: title, Text: text}}
+}
+
+// NewLink 创建链接消息，picURL 可以为空
+func NewLink(title, text, messageURL, picURL string) *SendMsg {
+	return &SendMsg{MsgType: MsgTypeLink, Link: &Link{Title: title, Text: text, MessageURL: messageURL, PicURL: picURL}}
+}
+
+// NewActionCard 创建整体跳转的卡片消息，text 为 markdown
+func NewActionCard(title, text, singleTitle, singleURL string) *SendMsg {
+	return &SendMsg{MsgType: MsgTypeActionCard, ActionCard: &ActionCard{
+		Title: title, Text: text, SingleTitle: singleTitle, SingleURL: singleURL,
+	}}
+}
+
+// NewMultiActionCard 创建多个按钮独立跳转的卡片消息，按钮默认竖直排列
+func NewMultiActionCard(title, text string, btns ...Button) *SendMsg {
+	return &SendMsg{MsgType: MsgTypeActionCard, ActionCard: &ActionCard{
+		Title: title, Text: text, BtnOrientation: BtnVertical, Btns: btns,
+	}}
+}
+
+// NewFeedCard 创建 feedCard 消息
+func NewFeedCard(links ...FeedLink) *SendMsg {
+	return &SendMsg{MsgType: MsgTypeFeedCard, FeedCard: &FeedCard{Links: links}}
+}
+
+// Horizontal actionCard 的按钮横向排列
+func (m *SendMsg) Horizontal() *SendMsg {
+	if m.ActionCard != nil {
+		m.ActionCard.BtnOrientation = BtnHorizontal
+	}
+	return m
+}
+
+// AtMobiles @ 手机号对应的群成员
+func (m *SendMsg) AtMobiles(mobiles ...string) *SendMsg {
+	m.at().AtMobiles = appendNew(m.at().AtMobiles, mobiles...)
+	return m
+}
+
+// AtUserIds @ userid 对应的群成员
+func (m *SendMsg) AtUserIds(userIds ...string) *SendMsg {
+	m.at().AtUserIds = appendNew(m.at().AtUserIds, userIds...)
+	return m
+}
+
+// AtAll @ 所有人
+func (m *SendMsg) AtAll() *SendMsg {
+	m.at().IsAtAll = true
+	return m
+}
+
+func (m *SendMsg) at() *At {
+	if m.At == nil {
+		m.At = &At{}
+	}
+	return m.At
+}
+
+// Validate 校验消息类型与内容是否匹配，以及必填字段
+func (m *SendMsg) Validate() error {
+	if m == nil {
+		return errors.New("消息不能为空")
+	}
+	var errs []error
+	required := func(name, v string) {
+		if strings.TrimSpace(v) == "" {
+			errs = append(errs, fmt.Errorf("%s 不能为空", name))
+		}
+	}
+	switch m.MsgType {
+	case MsgTypeText:
+		if m.Text == nil {
+			return errors.New("text 消息缺少 text")
+		}
+		required("text.content", m.Text.Content)
+	case MsgTypeMarkdown:
+		if m.Markdown == nil {
+			return errors.New("markdown 消息缺少 markdown")
+		}
+		required("markdown.title", m.Markdown.Title)
+		required("markdown.text", m.Markdown.Text)
+	case MsgTypeLink:
+		if m.Link == nil {
+			return errors.New("link 消息缺少 link")
+		}
+		required("link.title", m.Link.Title)
+		required("link.text", m.Link.Text)
+		required("link.messageUrl", m.Link.MessageURL)
+	case MsgTypeActionCard:
+		c := m.ActionCard
+		if c == nil {
+			return errors.New("actionCard 消息缺少 actionCard")
+		}
+		required("actionCard.title", c.Title)
+		required("actionCard.text", c.Text)
+		switch {
+		case len(c.Btns) > 0 && c.SingleURL != "":
+			errs = append(errs, errors.New("actionCard 的 singleURL 和 btns 只能设置一个"))
+		case len(c.Btns) == 0:
+			required("actionCard.singleTitle", c.SingleTitle)
+			required("actionCard.singleURL", c.SingleURL)
+		}
+		for i, b := range c.Btns {
+			required(fmt.Sprintf("actionCard.btns[%d].title", i), b.Title)
+			required(fmt.Sprintf("actionCard.btns[%d].actionURL", i), b.ActionURL)
+		}
+	case MsgTypeFeedCard:
+		if m.FeedCard == nil || len(m.FeedCard.Links) == 0 {
+			return errors.New("feedCard 消息至少需要一条链接")
+		}
+		for i, l := range m.FeedCard.Links {
+			required(fmt.Sprintf("feedCard.links[%d].title", i), l.Title)
+			required(fmt.Sprintf("feedCard.links[%d].messageURL", i), l.MessageURL)
+		}
+	default:
+		return fmt.Errorf("不支持的消息类型 %q", m.MsgType)
+	}
+	return errors.Join(errs...)
+}
+
+// withAt 返回 @ 了 mobiles 和 userIds 的副本，不修改 m；
+// text 和 markdown 消息在正文末尾追加 @ 信息，钉钉只高亮正文中出现的 @
+func (m *SendMsg) withAt(mobiles, userIds []string) *SendMsg {
+	c := *m
+	at := At{}
+	if m.At != nil {
+		at = *m.At
+	}
+	at.AtMobiles = appendNew(slices.Clone(at.AtMobiles), mobiles...)
+	at.AtUserIds = appendNew(slices.Clone(at.AtUserIds), userIds...)
+	if len(at.AtMobiles) == 0 && len(at.AtUserIds) == 0 && !at.IsAtAll {
+		c.At = nil
+		return &c
+	}
+	c.At = &at
+	switch {
+	case c.Text != nil && c.MsgType == MsgTypeText:
+		t := *c.Text
+		t.Content = appendAts(t.Content, &at)
+		c.Text = &t
+	case c.Markdown != nil && c.MsgType == MsgTypeMarkdown:
+		md := *c.Markdown
+		md.Text = appendAts(md.Text, &at)
+		c.Markdown = &md
+	}
+	return &c
+}
+
+// appendAts 在正文末尾追加正文中还没有的 @手机号 和 @userid
+func appendAts(body string, at *At) string {
+	var ats []string
+	for _, id := range append(slices.Clone(at.AtMobiles), at.AtUserIds...) {
+		if !strings.Contains(body, "@"+id) {
+			ats = append(ats, "@"+id)
+		}
+	}
+	if len(ats) == 0 {
+		return body
+	}
+	return strings.TrimRight(body, "\n") + "\n\n" + strings.Join(ats, " ")
+}
+
+// appendNew 追加不重复且非空的元素
+func appendNew(list []string, items ...string) []string {
+	for _, item := range items {
+		if item != "" && !slices.Contains(list, item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
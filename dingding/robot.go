@@ -0,0 +1,324 @@
+package dingding
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/v-mars/notify"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxCallbackBody 回调请求体的最大长度
+	maxCallbackBody = 1 << 20
+	// defaultMaxSkew 回调请求的 timestamp 与当前时间的最大差值，钉钉要求为 1 小时
+	defaultMaxSkew = time.Hour
+)
+
+// sessionWebhookOrigins sessionWebhook 允许的地址，回调消息中的其他地址不会被请求
+var sessionWebhookOrigins = []string{"https://oapi.dingtalk.com", "https://api.dingtalk.com"}
+
+// 会话类型
+const (
+	ConversationSingle = "1"
+	ConversationGroup  = "2"
+)
+
+// RobotMessage 用户 @ 机器人或单聊机器人时回调的消息
+type RobotMessage struct {
+	MsgID             string `json:"msgId"`
+	MsgType           string `json:"msgtype"` // text、richText、picture、audio、video、file
+	ConversationID    string `json:"conversationId"`
+	ConversationType  string `json:"conversationType"` // 1 单聊，2 群聊
+	ConversationTitle string `json:"conversationTitle"`
+	SenderID          string `json:"senderId"`
+	SenderNick        string `json:"senderNick"`
+	SenderStaffID     string `json:"senderStaffId"` // 发送者的 userid，企业内部机器人才有
+	SenderCorpID      string `json:"senderCorpId"`
+	IsAdmin           bool   `json:"isAdmin"`
+	IsInAtList        bool   `json:"isInAtList"`
+	AtUsers           []struct {
+		DingtalkID string `json:"dingtalkId"`
+		StaffID    string `json:"staffId"`
+	} `json:"atUsers"`
+	ChatbotUserID string `json:"chatbotUserId"`
+	RobotCode     string `json:"robotCode"`
+	CreateAt      int64  `json:"createAt"` // 毫秒时间戳
+	// SessionWebhook 回复当前会话的地址，SessionWebhookExpiredTime（毫秒时间戳）后失效
+	SessionWebhook            string `json:"sessionWebhook"`
+	SessionWebhookExpiredTime int64  `json:"sessionWebhookExpiredTime"`
+	Text                      *Text  `json:"text,omitempty"`
+	// Content richText、picture、audio、video、file 消息的内容，使用 RichText 和 Media 解析
+	Content json.RawMessage `json:"content,omitempty"`
+}
+
+// RichTextItem 富文本消息中的一段文字或一张图片
+type RichTextItem struct {
+	Text                string `json:"text"`
+	DownloadCode        string `json:"downloadCode"`
+	PictureDownloadCode string `json:"pictureDownloadCode"`
+}
+
+// MediaContent 图片、语音、视频和文件消息的内容，DownloadCode 用于下载文件
+type MediaContent struct {
+	DownloadCode        string `json:"downloadCode"`
+	PictureDownloadCode string `json:"pictureDownloadCode"`
+	Recognition         string `json:"recognition"` // 语音识别的文字
+	Duration            int    `json:"duration"`
+	VideoType           string `json:"videoType"`
+	FileName            string `json:"fileName"`
+	FileID              string `json:"fileId"`
+}
+
+// IsGroup 是否群聊中的消息
+func (m *RobotMessage) IsGroup() bool {
+	return m.ConversationType == ConversationGroup
+}
+
+// RichText 解析富文本消息
+func (m *RobotMessage) RichText() ([]RichTextItem, error) {
+	if m.MsgType != "richText" {
+		return nil, fmt.Errorf("消息类型为 %s，不是 richText", m.MsgType)
+	}
+	var c struct {
+		RichText []RichTextItem `json:"richText"`
+	}
+	if err := json.Unmarshal(m.Content, &c); err != nil {
+		return nil, err
+	}
+	return c.RichText, nil
+}
+
+// Media 解析图片、语音、视频和文件消息
+func (m *RobotMessage) Media() (*MediaContent, error) {
+	var c MediaContent
+	if err := json.Unmarshal(m.Content, &c); err != nil {
+		return nil, fmt.Errorf("解析 %s 消息失败: %w", m.MsgType, err)
+	}
+	return &c, nil
+}
+
+// PlainText 消息中的文字：文本消息的内容、富文本中的文字或语音识别的文字，去掉首尾空白
+func (m *RobotMessage) PlainText() string {
+	switch m.MsgType {
+	case "text":
+		if m.Text != nil {
+			return strings.TrimSpace(m.Text.Content)
+		}
+	case "richText":
+		items, _ := m.RichText()
+		var parts []string
+		for _, item := range items {
+			if item.Text != "" {
+				parts = append(parts, item.Text)
+			}
+		}
+		return strings.TrimSpace(strings.Join(parts, ""))
+	case "audio":
+		if c, err := m.Media(); err == nil {
+			return strings.TrimSpace(c.Recognition)
+		}
+	}
+	return ""
+}
+
+// Reply 通过 sessionWebhook 回复当前会话，可以在处理函数返回后异步回复或回复多条消息
+func (m *RobotMessage) Reply(ctx context.Context, reply *SendMsg) error {
+	if m.SessionWebhook == "" {
+		return notify.Permanent(errors.New("回调消息没有 sessionWebhook"))
+	}
+	if m.SessionWebhookExpiredTime > 0 && time.Now().UnixMilli() > m.SessionWebhookExpiredTime {
+		return notify.Permanent(errors.New("sessionWebhook 已过期"))
+	}
+	if u, err := url.Parse(m.SessionWebhook); err != nil || u.User != nil || !slices.Contains(sessionWebhookOrigins, u.Scheme+"://"+u.Host) {
+		return notify.Permanent(errors.New("sessionWebhook 不是钉钉的地址"))
+	}
+	if err := reply.Validate(); err != nil {
+		return notify.Permanent(fmt.Errorf("钉钉消息校验失败: %w", err))
+	}
+	resp, err := notify.JSONPostContext(ctx, http.MethodPost, m.SessionWebhook, reply, apiClient, nil)
+	if err != nil {
+		return err
+	}
+	var res Result
+	if err = json.Unmarshal(resp, &res); err != nil {
+		return err
+	}
+	if res.ErrCode != 0 {
+		return notify.NewError(strconv.Itoa(res.ErrCode), retryableErrCodes[res.ErrCode],
+			fmt.Errorf("errmsg: %s errcode: %d", res.ErrMsg, res.ErrCode))
+	}
+	return nil
+}
+
+// MessageHandler 处理回调消息，返回的消息不为 nil 时作为回复发送到当前会话
+type MessageHandler interface {
+	HandleMessage(ctx context.Context, msg *RobotMessage) (*SendMsg, error)
+}
+
+// MessageHandlerFunc 函数形式的 MessageHandler
+type MessageHandlerFunc func(ctx context.Context, msg *RobotMessage) (*SendMsg, error)
+
+func (f MessageHandlerFunc) HandleMessage(ctx context.Context, msg *RobotMessage) (*SendMsg, error) {
+	return f(ctx, msg)
+}
+
+// RobotHandler 机器人回调的 http.Handler：校验 timestamp 和 sign 请求头，解析消息后交给 Handler 处理，
+// 处理函数返回的消息直接作为响应回复到当前会话，返回 error 时只回复通用的失败提示，错误详情记录在日志中
+type RobotHandler struct {
+	// AppSecret 机器人所属应用的 AppSecret，用于校验签名，为空时拒绝所有请求
+	AppSecret string
+	// MaxSkew timestamp 与当前时间的最大差值，默认 1 小时
+	MaxSkew time.Duration
+	Handler MessageHandler
+}
+
+// NewRobotHandler 创建机器人回调处理器，appSecret 不能为空，handler 可以是 *Router
+func NewRobotHandler(appSecret string, handler MessageHandler) (*RobotHandler, error) {
+	if appSecret == "" {
+		return nil, errors.New("钉钉机器人回调的 AppSecret 不能为空")
+	}
+	return &RobotHandler{AppSecret: appSecret, Handler: handler}, nil
+}
+
+func (h *RobotHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.verify(r.Header.Get("timestamp"), r.Header.Get("sign")) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCallbackBody))
+	if err != nil {
+		http.Error(w, "read body failed", http.StatusBadRequest)
+		return
+	}
+	var msg RobotMessage
+	if err = json.Unmarshal(body, &msg); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	if h.Handler == nil {
+		writeJSON(w, struct{}{})
+		return
+	}
+	reply, err := h.Handler.HandleMessage(r.Context(), &msg)
+	if err != nil {
+		log.Printf("钉钉机器人消息 %s 处理失败: %v", msg.MsgID, err)
+		reply = NewText("处理失败，请稍后重试")
+	}
+	if reply == nil {
+		writeJSON(w, struct{}{})
+		return
+	}
+	if err = reply.Validate(); err != nil {
+		log.Printf("钉钉机器人回复的消息无效: %v", err)
+		writeJSON(w, struct{}{})
+		return
+	}
+	writeJSON(w, reply)
+}
+
+// verify 校验 timestamp 在允许的范围内，并且 sign 为 base64(HmacSHA256(AppSecret, timestamp + "\n" + AppSecret))
+func (h *RobotHandler) verify(timestamp, sign string) bool {
+	if h.AppSecret == "" {
+		return false
+	}
+	ms, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	maxSkew := h.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = defaultMaxSkew
+	}
+	if skew := time.Since(time.UnixMilli(ms)); skew > maxSkew || skew < -maxSkew {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(signature(h.AppSecret, timestamp)), []byte(sign)) == 1
+}
+
+// Command 解析后的文本命令，如 "ack 1024" 的 Name 为 ack，Args 为 [1024]
+type Command struct {
+	Name    string
+	Args    []string
+	Message *RobotMessage
+}
+
+// CommandFunc 命令的处理函数
+type CommandFunc func(ctx context.Context, cmd *Command) (*SendMsg, error)
+
+// Router 按消息文字的第一个词把消息分发到注册的命令处理函数，命令不区分大小写，可以带 "/" 前缀
+type Router struct {
+	mu       sync.RWMutex
+	handlers map[string]CommandFunc
+}
+
+// NewRouter 创建命令路由
+func NewRouter() *Router {
+	return &Router{handlers: make(map[string]CommandFunc)}
+}
+
+// Handle 注册命令的处理函数，name 为空时处理没有匹配的命令
+func (r *Router) Handle(name string, fn CommandFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[normalizeCommand(name)] = fn
+}
+
+// HandleMessage 解析命令并分发，没有匹配的处理函数时回复可用的命令
+func (r *Router) HandleMessage(ctx context.Context, msg *RobotMessage) (*SendMsg, error) {
+	fields := strings.Fields(msg.PlainText())
+	cmd := &Command{Message: msg}
+	if len(fields) > 0 {
+		cmd.Name = normalizeCommand(fields[0])
+		cmd.Args = fields[1:]
+	}
+	r.mu.RLock()
+	fn, ok := r.handlers[cmd.Name]
+	if !ok {
+		fn = r.handlers[""]
+	}
+	r.mu.RUnlock()
+	if fn == nil {
+		return NewText("未知命令，可用的命令: " + strings.Join(r.Commands(), ", ")), nil
+	}
+	return fn(ctx, cmd)
+}
+
+// Commands 已注册的命令
+func (r *Router) Commands() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var names []string
+	for name := range r.handlers {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func normalizeCommand(name string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "/"))
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(v)
+}
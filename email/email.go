@@ -1,6 +1,7 @@
 package email
 
 import (
+	"context"
 	"crypto/tls"
 	"github.com/v-mars/notify"
 	"github.com/v-mars/notify/result"
@@ -8,6 +9,7 @@ import (
 
 	"encoding/base64"
 	"fmt"
+	"net"
 	"net/smtp"
 	"strings"
 )
@@ -39,7 +41,18 @@ func NewSMTP(smtphost string, port int, username, password, from string, tls, an
 }
 
 // Send send email to user
-func (s *SMTP) Send(tos []string, title, content string) (sendResult *result.SendResult, err error) {
+func (s *SMTP) Send(tos []string, title, content string) (*result.SendResult, error) {
+	return s.SendContext(context.Background(), tos, title, content)
+}
+
+// SendContext send email to user, the smtp connection is bound to ctx
+func (s *SMTP) SendContext(ctx context.Context, tos []string, title, content string) (*result.SendResult, error) {
+	return s.SendMessage(ctx, tos, notify.NewMessage(title, content))
+}
+
+// SendMessage send a structured message, the html body is used when present,
+// otherwise the plain text body is sent as text/plain
+func (s *SMTP) SendMessage(ctx context.Context, tos []string, msg *notify.Message) (sendResult *result.SendResult, err error) {
 	sendResult = &result.SendResult{
 		ChannelType:  NotifyTypeEmail,
 		ChannelMsgID: nil,
@@ -54,21 +67,26 @@ func (s *SMTP) Send(tos []string, title, content string) (sendResult *result.Sen
 		sendResult.ChannelMsgID = result.PtrOf(fmt.Sprintf("%d", time.Now().UnixNano()))
 		sendResult.Success = err == nil
 		sendResult.MessageID = *sendResult.ChannelMsgID
-		sendResult.Error = result.PtrOf(err.Error())
+		if err != nil {
+			sendResult.Error = result.PtrOf(err.Error())
+		}
 	}()
 	if s.SMTPHost == "" {
 		return sendResult, fmt.Errorf("address is necessary")
 	}
 	var safeTos []string
 	for _, to := range tos {
-		err = CheckEmail(to)
-		if err != nil {
+		if checkErr := CheckEmail(to); checkErr != nil {
 			//log.Error("email check error", zap.Error(err))
+			sendResult.AddRecipient(to, "", checkErr)
 			continue
 		}
 		safeTos = append(safeTos, to)
 	}
 
+	if len(safeTos) == 0 {
+		return sendResult, fmt.Errorf("没有合法的收件人邮箱: %v", tos)
+	}
 	toAddr := strings.Join(safeTos, ";")
 
 	b64 := base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/")
@@ -76,10 +94,18 @@ func (s *SMTP) Send(tos []string, title, content string) (sendResult *result.Sen
 	header := make(map[string]string)
 	header["From"] = s.From
 	header["To"] = toAddr
-	header["Subject"] = fmt.Sprintf("=?UTF-8?B?%s?=", b64.EncodeToString([]byte(title)))
+	header["Subject"] = fmt.Sprintf("=?UTF-8?B?%s?=", b64.EncodeToString([]byte(msg.Title)))
 	header["MIME-Version"] = "1.0"
 
+	content := textBody(msg)
 	header["Content-TypeV1"] = "text/plain"
+	if isHTML(msg) {
+		content = htmlBody(msg)
+		header["Content-Type"] = "text/html; charset=UTF-8"
+	}
+	if msg.IsUrgent() {
+		header["X-Priority"] = "1"
+	}
 	header["Content-Transfer-Encoding"] = "base64"
 	//header.Attach("./Dockerfile")   //添加附件
 
@@ -93,21 +119,40 @@ func (s *SMTP) Send(tos []string, title, content string) (sendResult *result.Sen
 	if !s.Anonymous {
 		auth = smtp.PlainAuth("", s.Username, s.Password, s.SMTPHost)
 	}
-	return sendResult, s.sendMail(auth, safeTos, []byte(message))
+	rejected, err := s.sendMail(ctx, auth, safeTos, []byte(message))
+	var accepted []string
+	for _, to := range safeTos {
+		if rcptErr, ok := rejected[to]; ok {
+			sendResult.AddRecipient(to, "", rcptErr)
+			continue
+		}
+		accepted = append(accepted, to)
+	}
+	sendResult.AddRecipients(accepted, "", err)
+	return sendResult, err
 }
 
-// sendMail will send mail to user
-func (s *SMTP) sendMail(auth smtp.Auth, to []string, msg []byte) (err error) {
+// sendMail will send mail to user, recipients rejected by the server are
+// returned in rejected and the mail is still delivered to the others
+func (s *SMTP) sendMail(ctx context.Context, auth smtp.Auth, to []string, msg []byte) (rejected map[string]error, err error) {
 	if err := validateLine(s.From); err != nil {
-		return err
+		return nil, err
 	}
 	for _, recp := range to {
 		if err := validateLine(recp); err != nil {
-			return err
+			return nil, err
 		}
 	}
 	var client *smtp.Client
 	addr := fmt.Sprintf("%s:%d", s.SMTPHost, s.Port)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return rejected, err
+	}
+	// ctx 结束时关闭连接，中断阻塞中的 smtp 会话
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
 	if s.TLS {
 		// httpsProxyURI, _ := url.Parse("https://your https proxy:443")
 		// httpsDialer, err := proxy.FromURL(httpsProxyURI, HttpsDialer)
@@ -116,24 +161,24 @@ func (s *SMTP) sendMail(auth smtp.Auth, to []string, msg []byte) (err error) {
 			InsecureSkipVerify: s.SkipVerify,
 			ServerName:         s.SMTPHost,
 		}
-		var c *tls.Conn
-		c, err = tls.Dial("tcp", addr, tlsconfig)
-
-		if err != nil {
-			return err
+		c := tls.Client(conn, tlsconfig)
+		if err = c.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return rejected, err
 		}
 
 		// tls.DialWithDialer(dialer *net.Dialer, network string, addr string, config *tls.NotifyConfig)
 		client, err = smtp.NewClient(c, s.SMTPHost)
 		if err != nil {
-			return err
+			return rejected, err
 		}
 
 		defer client.Close()
 	} else {
-		client, err = smtp.Dial(addr)
+		client, err = smtp.NewClient(conn, s.SMTPHost)
 		if err != nil {
-			return err
+			_ = conn.Close()
+			return rejected, err
 		}
 
 		defer client.Close()
@@ -144,36 +189,41 @@ func (s *SMTP) sendMail(auth smtp.Auth, to []string, msg []byte) (err error) {
 				ServerName:         s.SMTPHost,
 			}
 			if err = client.StartTLS(config); err != nil {
-				return err
+				return rejected, err
 			}
 		}
 	}
 	if auth != nil {
 		if err = client.Auth(auth); err != nil {
-			return err
+			return rejected, err
 		}
 	}
 	if err = client.Mail(s.From); err != nil {
-		return err
+		return rejected, err
 	}
+	rejected = make(map[string]error)
+	var rcptErr error
 	for _, addr := range to {
 		if err = client.Rcpt(addr); err != nil {
-			return err
+			rejected[addr], rcptErr = err, err
 		}
 	}
+	if len(rejected) == len(to) {
+		return rejected, fmt.Errorf("all recipients rejected: %w", rcptErr)
+	}
 	w, err := client.Data()
 	if err != nil {
-		return err
+		return rejected, err
 	}
 	_, err = w.Write(msg)
 	if err != nil {
-		return err
+		return rejected, err
 	}
 	err = w.Close()
 	if err != nil {
-		return err
+		return rejected, err
 	}
-	return client.Quit()
+	return rejected, client.Quit()
 
 }
 
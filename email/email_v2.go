@@ -1,11 +1,16 @@
 package email
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/markdown"
 	"github.com/v-mars/notify/result"
 	"github.com/v-mars/notify/types"
 	"gopkg.in/gomail.v2"
+	"html"
+	"io"
 	"log"
 	"time"
 )
@@ -42,7 +47,19 @@ func NewMail(username, password, SMTPAddr, from string, SMTPPort int, tls bool,
 	return &im
 }
 
-func (mailConf *MailboxConf) Send(RecipientList []string, title, body string) (sendResult *result.SendResult, err error) {
+func (mailConf *MailboxConf) Send(RecipientList []string, title, body string) (*result.SendResult, error) {
+	return mailConf.SendContext(context.Background(), RecipientList, title, body)
+}
+
+// SendContext 发送邮件，ctx 取消或超时后不再继续发送剩余的收件人
+func (mailConf *MailboxConf) SendContext(ctx context.Context, RecipientList []string, title, body string) (*result.SendResult, error) {
+	return mailConf.SendMessage(ctx, RecipientList, &notify.Message{Title: title, HTML: body})
+}
+
+// SendMessage 发送结构化邮件
+// 有 HTML 或 Markdown 正文时以 text/html 发送，否则以 text/plain 发送纯文本正文，
+// 消息附件与 AttachList 一并添加，高优先级消息设置 X-Priority 头
+func (mailConf *MailboxConf) SendMessage(ctx context.Context, RecipientList []string, msg *notify.Message) (sendResult *result.SendResult, err error) {
 	sendResult = &result.SendResult{
 		ChannelType:  NotifyTypeEmail,
 		ChannelMsgID: nil,
@@ -65,14 +82,22 @@ func (mailConf *MailboxConf) Send(RecipientList []string, title, body string) (s
 	m := gomail.NewMessage()
 	m.SetHeader(`From`, mailConf.Username)
 	//m.SetHeader(`To`, RecipientList...)
-	m.SetHeader(`Subject`, title)
-	m.SetBody(`text/html`, body)
+	m.SetHeader(`Subject`, msg.Title)
+	if msg.IsUrgent() {
+		m.SetHeader(`X-Priority`, "1")
+	}
+	if isHTML(msg) {
+		m.SetBody(`text/html`, htmlBody(msg))
+	} else {
+		m.SetBody(`text/plain`, textBody(msg))
+	}
 	//m.Attach("./Dockerfile") //添加附件
 	if len(mailConf.AttachList) > 0 {
 		for _, v := range mailConf.AttachList {
 			m.Attach(v)
 		}
 	}
+	attach(m, msg.Attachments)
 
 	if len(RecipientList) == 0 {
 		err = fmt.Errorf("发送邮件失败，邮箱接收者不能为空\n")
@@ -83,49 +108,109 @@ func (mailConf *MailboxConf) Send(RecipientList []string, title, body string) (s
 	//dialer.TLSConfig = &tls.NotifyConfig{InsecureSkipVerify: true}
 	//if mailConf.Tls{dialer.TLSConfig = &tls.NotifyConfig{InsecureSkipVerify: false}}
 
-	// 逐个发送邮件，确保一个失败不会影响其他邮件的发送
-	var failedRecipients []string
-	var successRecipients []string
+	// 逐个发送邮件，确保一个失败不会影响其他邮件的发送，每个收件人的结果记录在 Recipients 中
 	var lastError error
-	for _, recipient := range RecipientList {
+	for i, recipient := range RecipientList {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return sendResult, ctxErr
+		}
+		messageID := fmt.Sprintf("<%d.%d@%s>", sendResult.SendTime.UnixNano(), i, mailConf.SMTPServer)
 		m.SetHeader(`To`, recipient)
-		err = dialer.DialAndSend(m)
-		if err != nil {
-			failedRecipients = append(failedRecipients, recipient)
-			lastError = fmt.Errorf("发送邮件到 %s 失败: %s", recipient, err.Error())
-			log.Println(lastError)
-		} else {
-			successRecipients = append(successRecipients, recipient)
-			log.Printf("发送邮件到 %s 成功", recipient)
+		m.SetHeader(`Message-ID`, messageID)
+		if sendErr := dialAndSend(ctx, dialer, m); sendErr != nil {
+			lastError = fmt.Errorf("发送邮件到 %s 失败: %w", recipient, sendErr)
+			sendResult.AddRecipient(recipient, "", sendErr)
+			continue
 		}
+		sendResult.AddRecipient(recipient, messageID, nil)
 	}
-	// 记录发送结果
-	if len(failedRecipients) > 0 {
-		log.Printf("部分邮件发送失败: %v", failedRecipients)
-		if len(successRecipients) > 0 {
-			log.Printf("部分邮件发送成功: %v", successRecipients)
-		}
-		// 如果有成功的邮件，不返回错误，但记录警告
-		if len(successRecipients) > 0 {
-			log.Printf("虽然部分邮件发送失败，但其他邮件发送成功")
-			// 不返回错误，让调用方知道部分成功
-			return sendResult, nil
-		}
-		// 如果所有邮件都失败，返回最后一个错误
-		return sendResult, fmt.Errorf("所有邮件发送失败，最后的错误: %s", lastError.Error())
-	} else {
-		log.Println("所有邮件发送成功")
-		return sendResult, nil
+	// 部分收件人发送成功时不返回错误，失败的收件人见 Recipients
+	if failed := sendResult.FailedRecipients(); len(failed) == len(RecipientList) {
+		return sendResult, fmt.Errorf("所有邮件发送失败，最后的错误: %w", lastError)
+	} else if len(failed) > 0 {
+		log.Printf("部分邮件发送失败: %v", failed)
 	}
-
-	//log.Println("Send Email Success")
-	//return sendResult, nil
+	return sendResult, nil
 }
 
 func (mailConf *MailboxConf) ChannelType() string {
 	return NotifyTypeEmail
 }
 
+func init() {
+	notify.Register(NotifyTypeEmail, func(section notify.Section) (notify.Sender, error) {
+		var conf types.EmailConfig
+		if err := section.Decode(&conf); err != nil {
+			return nil, err
+		}
+		return NewMail(conf.Username, conf.Password, conf.SMTPServer, conf.Username, conf.SMTPPort, conf.TLS), nil
+	})
+}
+
+// isHTML 有 HTML 或 Markdown 正文时以 HTML 发送
+func isHTML(msg *notify.Message) bool {
+	return msg.HTML != "" || msg.Markdown != ""
+}
+
+// htmlBody HTML 正文，只有 Markdown 正文时转换为 HTML，链接追加在末尾
+func htmlBody(msg *notify.Message) string {
+	body := msg.HTMLBody()
+	if msg.HTML == "" && msg.Markdown != "" {
+		body = markdown.Convert(msg.Markdown, markdown.HTML)
+	}
+	for _, link := range msg.Links {
+		body += fmt.Sprintf("<p><a href=\"%s\">%s</a></p>", html.EscapeString(link.URL), html.EscapeString(link.Text))
+	}
+	return body
+}
+
+// textBody 纯文本正文，链接追加在末尾
+func textBody(msg *notify.Message) string {
+	body := msg.TextBody()
+	for _, link := range msg.Links {
+		body += "\n" + link.Text + ": " + link.URL
+	}
+	return body
+}
+
+// attach 添加消息附件，只有 URL 的附件无法作为邮件附件，忽略
+func attach(m *gomail.Message, attachments []notify.Attachment) {
+	for _, a := range attachments {
+		var settings []gomail.FileSetting
+		if a.ContentType != "" {
+			settings = append(settings, gomail.SetHeader(map[string][]string{"Content-Type": {a.ContentType}}))
+		}
+		switch {
+		case len(a.Data) > 0:
+			data := a.Data
+			settings = append(settings, gomail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(data)
+				return err
+			}))
+			m.Attach(a.Name, settings...)
+		case a.Path != "":
+			if a.Name != "" {
+				settings = append(settings, gomail.Rename(a.Name))
+			}
+			m.Attach(a.Path, settings...)
+		}
+	}
+}
+
+// dialAndSend gomail 不支持 context，ctx 结束时不再等待本次投递的结果
+func dialAndSend(ctx context.Context, dialer *gomail.Dialer, m *gomail.Message) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- dialer.DialAndSend(m)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func SendMailTest() {
 	var mailConf MailboxConf
 	mailConf.Title = "测试用gomail发送邮件"
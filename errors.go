@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"syscall"
+)
+
+// Error 渠道返回的错误，携带渠道错误码和是否可重试的分类
+type Error struct {
+	Code      string // 渠道错误码，如 HTTP 状态码、钉钉/企业微信 errcode、SMTP 回复码
+	Retryable bool   // 是否为临时错误，临时错误可以重试
+	Err       error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewError 创建带错误码和分类的渠道错误
+func NewError(code string, retryable bool, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: code, Retryable: retryable, Err: err}
+}
+
+// Retryable 将错误标记为可重试
+func Retryable(err error) error {
+	return NewError("", true, err)
+}
+
+// Permanent 将错误标记为永久错误，不再重试
+func Permanent(err error) error {
+	return NewError("", false, err)
+}
+
+// HTTPError 接口返回了非 2xx 的 HTTP 状态码
+type HTTPError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("http status %d: %s", e.StatusCode, e.Body)
+}
+
+// Retryable 429、408 和 5xx 可以重试，其他 4xx 为永久错误
+func (e *HTTPError) Retryable() bool {
+	return e.StatusCode == 429 || e.StatusCode == 408 || e.StatusCode >= 500
+}
+
+// IsRetryable 判断错误是否可以重试
+// 已分类的错误以分类为准；网络错误和超时可以重试；
+// SMTP 4xx 回复可以重试，5xx 为永久错误；ctx 取消和其他未分类的错误不重试
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var ne *Error
+	if errors.As(err, &ne) {
+		return ne.Retryable
+	}
+	var classified interface{ Retryable() bool }
+	if errors.As(err, &classified) {
+		return classified.Retryable()
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) {
+		return tpErr.Code >= 400 && tpErr.Code < 500
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return false
+}
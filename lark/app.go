@@ -0,0 +1,286 @@
+package lark
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/result"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultAPIBaseURL = "https://open.feishu.cn/open-apis"
+
+// tokenErrCodes tenant_access_token 无效或过期，重新获取 token 后可以重试
+var tokenErrCodes = map[int]bool{
+	99991661: true,
+	99991663: true,
+	99991677: true,
+}
+
+// receiveIDTypes 接收人可以显式指定的 receive_id_type
+var receiveIDTypes = map[string]bool{
+	"open_id":  true,
+	"user_id":  true,
+	"union_id": true,
+	"email":    true,
+	"chat_id":  true,
+}
+
+// apiClient 调用开放平台接口的 http 客户端
+var apiClient = &http.Client{Timeout: 30 * time.Second}
+
+var (
+	tokensMu sync.Mutex
+	tokens   = map[string]*tenantToken{}
+)
+
+// tenantToken 缓存的 tenant_access_token，提前 5 分钟过期，获取时持有 mu，并发获取只请求一次
+type tenantToken struct {
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// sharedTenantToken 返回 appID、appSecret 和 apiBaseURL 对应的进程内共享的 token 缓存，
+// Manager 每次发送都会创建新的 Lark，共享缓存避免每次发送都重新获取 token
+func sharedTenantToken(appID, appSecret, apiBaseURL string) *tenantToken {
+	key := appID + "\x00" + appSecret + "\x00" + apiBaseURL
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+	t, ok := tokens[key]
+	if !ok {
+		t = &tenantToken{}
+		tokens[key] = t
+	}
+	return t
+}
+
+// invalidate token 仍是当前缓存的 token 时清空，下次获取时重新请求
+func (t *tenantToken) invalidate(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token == token {
+		t.token = ""
+	}
+}
+
+// APIResult 开放平台接口的返回结果
+type APIResult struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data json.RawMessage `json:"data"`
+}
+
+// AppMsg im/v1/messages 的请求参数，Content 为 JSON 字符串
+type AppMsg struct {
+	ReceiveID string `json:"receive_id,omitempty"`
+	MsgType   string `json:"msg_type,omitempty"`
+	Content   string `json:"content"`
+}
+
+// NewLarkApp 创建应用机器人，通过开放平台接口发送消息
+func NewLarkApp(appID, appSecret string) *Lark {
+	d := NewLark("", Sign, "")
+	d.AppID = appID
+	d.AppSecret = appSecret
+	return d
+}
+
+// sendApp 应用机器人逐个发送给每个接收人，返回结果的 ChannelMsgID 为第一条消息的 message_id，
+// 每个接收人的 message_id 记录在 Recipients 中，可用于 Recall 和 Update
+func (d *Lark) sendApp(ctx context.Context, tos []string, msgType string, content any) (sendResult *result.SendResult, err error) {
+	sendResult = &result.SendResult{
+		ChannelType:  NotifyTypeLark,
+		ChannelMsgID: nil,
+		Success:      false,
+		MessageID:    "",
+		SendTime:     time.Now(),
+		Error:        nil,
+		CostMs:       0,
+	}
+	defer func() {
+		sendResult.CostMs = time.Now().Sub(sendResult.SendTime).Milliseconds()
+		sendResult.MessageID = fmt.Sprintf("%d", time.Now().UnixNano())
+		if sendResult.ChannelMsgID == nil {
+			sendResult.ChannelMsgID = result.PtrOf(sendResult.MessageID)
+		}
+		sendResult.Success = err == nil
+		if err != nil {
+			sendResult.Error = result.PtrOf(err.Error())
+		}
+	}()
+	if len(tos) == 0 {
+		return sendResult, notify.Permanent(errors.New("飞书接收人不能为空"))
+	}
+	b, err := json.Marshal(content)
+	if err != nil {
+		return sendResult, err
+	}
+	appMsg := AppMsg{MsgType: msgType, Content: string(b)}
+	var lastErr error
+	for _, to := range tos {
+		if err = ctx.Err(); err != nil {
+			return sendResult, err
+		}
+		idType, id := d.receiveID(to)
+		m := appMsg
+		m.ReceiveID = id
+		var data struct {
+			MessageID string `json:"message_id"`
+		}
+		sendErr := d.openAPI(ctx, http.MethodPost, "/im/v1/messages?receive_id_type="+idType, m, &data)
+		if sendErr != nil {
+			lastErr = fmt.Errorf("发送到 %s 失败: %w", to, sendErr)
+			sendResult.AddRecipient(to, "", sendErr)
+			continue
+		}
+		if sendResult.ChannelMsgID == nil {
+			sendResult.ChannelMsgID = &data.MessageID
+		}
+		sendResult.AddRecipient(to, data.MessageID, nil)
+	}
+	// 部分接收人发送成功时不返回错误，失败的接收人见 Recipients
+	if failed := sendResult.FailedRecipients(); len(failed) == len(tos) {
+		return sendResult, lastErr
+	}
+	return sendResult, nil
+}
+
+// Recall 撤回应用机器人发送的消息
+func (d *Lark) Recall(ctx context.Context, messageID string) error {
+	return d.openAPI(ctx, http.MethodDelete, "/im/v1/messages/"+url.PathEscape(messageID), struct{}{}, nil)
+}
+
+// Update 更新应用机器人发送的消息，卡片消息更新卡片内容，其他消息编辑为新的文本
+func (d *Lark) Update(ctx context.Context, messageID string, msg *notify.Message) error {
+	card, err := notify.MetadataValue[Card](msg, MetadataCard)
+	if err != nil {
+		return err
+	}
+	ok := card != nil
+	if !ok && (d.MsgType == "interactive" || msg.Markdown != "" || len(msg.Links) > 0) {
+		card, ok = d.card(msg), true
+	}
+	if ok {
+		return d.UpdateCard(ctx, messageID, card)
+	}
+	b, err := json.Marshal(Content{Text: strings.TrimPrefix(msg.Title+"\n"+textBody(msg), "\n")})
+	if err != nil {
+		return err
+	}
+	return d.openAPI(ctx, http.MethodPut, "/im/v1/messages/"+url.PathEscape(messageID), AppMsg{MsgType: "text", Content: string(b)}, nil)
+}
+
+// UpdateCard 校验并更新应用机器人发送的卡片
+func (d *Lark) UpdateCard(ctx context.Context, messageID string, card *Card) error {
+	if err := card.Validate(); err != nil {
+		return notify.Permanent(fmt.Errorf("飞书卡片校验失败: %w", err))
+	}
+	b, err := json.Marshal(card)
+	if err != nil {
+		return err
+	}
+	return d.openAPI(ctx, http.MethodPatch, "/im/v1/messages/"+url.PathEscape(messageID), AppMsg{Content: string(b)}, nil)
+}
+
+// receiveID 按前缀识别接收人的 receive_id_type，"类型:ID" 的写法优先
+func (d *Lark) receiveID(to string) (idType, id string) {
+	to = strings.TrimSpace(to)
+	if t, v, ok := strings.Cut(to, ":"); ok && receiveIDTypes[t] {
+		return t, v
+	}
+	switch {
+	case strings.HasPrefix(to, "ou_"):
+		return "open_id", to
+	case strings.HasPrefix(to, "oc_"):
+		return "chat_id", to
+	case strings.HasPrefix(to, "on_"):
+		return "union_id", to
+	case strings.Contains(to, "@"):
+		return "email", to
+	case d.ReceiveIDType != "":
+		return d.ReceiveIDType, to
+	}
+	return "user_id", to
+}
+
+// openAPI 调用开放平台接口，data 不为 nil 时解析返回的 data 字段
+func (d *Lark) openAPI(ctx context.Context, method, path string, body, data any) error {
+	token, err := d.tenantAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{"Authorization": "Bearer " + token}
+	resp, err := notify.JSONPostContext(ctx, method, d.apiBaseURL()+path, body, apiClient, headers)
+	var res APIResult
+	if jsonErr := json.Unmarshal(resp, &res); jsonErr != nil {
+		if err == nil {
+			err = fmt.Errorf("invalid lark response: %s", resp)
+		}
+		return err
+	}
+	if res.Code != 0 {
+		if tokenErrCodes[res.Code] {
+			// token 失效，清空后下次请求重新获取
+			d.tenantToken().invalidate(token)
+		}
+		return notify.NewError(strconv.Itoa(res.Code), retryableErrCodes[res.Code] || tokenErrCodes[res.Code],
+			fmt.Errorf("errmsg: %s errcode: %d", res.Msg, res.Code))
+	}
+	if err != nil {
+		return err
+	}
+	if data != nil && len(res.Data) > 0 {
+		return json.Unmarshal(res.Data, data)
+	}
+	return nil
+}
+
+// tenantAccessToken 返回缓存的 tenant_access_token，过期后重新获取
+func (d *Lark) tenantAccessToken(ctx context.Context) (string, error) {
+	t := d.tenantToken()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Now().Before(t.expiresAt) {
+		return t.token, nil
+	}
+	req := map[string]string{"app_id": d.AppID, "app_secret": d.AppSecret}
+	resp, err := notify.JSONPostContext(ctx, http.MethodPost, d.apiBaseURL()+"/auth/v3/tenant_access_token/internal", req, apiClient, nil)
+	if err != nil {
+		return "", fmt.Errorf("获取 tenant_access_token 失败: %w", err)
+	}
+	var res struct {
+		Code              int    `json:"code"`
+		Msg               string `json:"msg"`
+		TenantAccessToken string `json:"tenant_access_token"`
+		Expire            int    `json:"expire"`
+	}
+	if err = json.Unmarshal(resp, &res); err != nil {
+		return "", fmt.Errorf("解析 tenant_access_token 失败: %w", err)
+	}
+	if res.Code != 0 {
+		return "", notify.NewError(strconv.Itoa(res.Code), retryableErrCodes[res.Code],
+			fmt.Errorf("获取 tenant_access_token 失败: errmsg: %s errcode: %d", res.Msg, res.Code))
+	}
+	t.token = res.TenantAccessToken
+	t.expiresAt = time.Now().Add(time.Duration(res.Expire)*time.Second - 5*time.Minute)
+	return t.token, nil
+}
+
+func (d *Lark) tenantToken() *tenantToken {
+	return sharedTenantToken(d.AppID, d.AppSecret, d.apiBaseURL())
+}
+
+func (d *Lark) apiBaseURL() string {
+	if d.APIBaseURL == "" {
+		return defaultAPIBaseURL
+	}
+	return strings.TrimRight(d.APIBaseURL, "/")
+}
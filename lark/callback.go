@@ -0,0 +1,295 @@
+package lark
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxCallbackBody 回调请求体的最大长度
+	maxCallbackBody = 1 << 20
+	// defaultMaxSkew 回调请求的 X-Lark-Request-Timestamp 与当前时间的最大差值
+	defaultMaxSkew = time.Hour
+)
+
+// CardAction 卡片交互回调（card.action.trigger）解析后的事件
+type CardAction struct {
+	Operator Operator
+	// Action 按钮 value 中的 "action" 字段，用于分发到注册的处理函数
+	Action    string
+	Value     map[string]any
+	Tag       string         // 交互组件的类型，如 button、select_static
+	Option    string         // 下拉选择的选项
+	FormValue map[string]any // 表单提交的值
+	MessageID string         // 卡片所在消息的 open_message_id
+	ChatID    string         // 卡片所在会话的 open_chat_id
+	Token     string         // 用于延时更新卡片的 token，30 分钟内有效
+}
+
+// Operator 点击卡片的用户
+type Operator struct {
+	OpenID  string `json:"open_id"`
+	UserID  string `json:"user_id"`
+	UnionID string `json:"union_id"`
+}
+
+// CardResponse 处理函数的返回，Toast 为弹出的提示，Card 不为 nil 时替换原卡片
+type CardResponse struct {
+	Toast *Toast
+	Card  *Card
+}
+
+// Toast 卡片上弹出的提示，Type 为 info、success、warning 或 error
+type Toast struct {
+	Type    string `json:"type"`
+	Content string `json:"content"`
+}
+
+// CardActionFunc 卡片交互的处理函数，返回 error 时在卡片上提示通用的失败信息，错误详情记录在日志中
+type CardActionFunc func(ctx context.Context, action *CardAction) (*CardResponse, error)
+
+// CardHandler 卡片交互回调的 http.Handler：校验签名和 Verification Token，解密请求，
+// 响应 URL 校验，按按钮 value 中的 "action" 分发到注册的处理函数。
+// VerificationToken 和 EncryptKey 都为空时拒绝所有请求
+type CardHandler struct {
+	VerificationToken string
+	// EncryptKey 配置了 Encrypt Key 时请求体加密，并且必须带有签名
+	EncryptKey string
+	// MaxSkew 签名中的 timestamp 与当前时间的最大差值，默认 1 小时
+	MaxSkew  time.Duration
+	handlers map[string]CardActionFunc
+}
+
+// NewCardHandler 创建卡片回调处理器，参数为开发者后台「事件与回调」中的 Verification Token 和 Encrypt Key，
+// 两者不能都为空，建议配置 Encrypt Key 以校验签名
+func NewCardHandler(verificationToken, encryptKey string) (*CardHandler, error) {
+	if verificationToken == "" && encryptKey == "" {
+		return nil, errors.New("飞书卡片回调的 Verification Token 和 Encrypt Key 不能都为空")
+	}
+	return &CardHandler{
+		VerificationToken: verificationToken,
+		EncryptKey:        encryptKey,
+		handlers:          make(map[string]CardActionFunc),
+	}, nil
+}
+
+// Handle 注册 action 的处理函数，action 为空时处理没有匹配的处理函数的交互；需要在处理请求前注册
+func (h *CardHandler) Handle(action string, fn CardActionFunc) {
+	h.handlers[action] = fn
+}
+
+// callbackRequest 回调请求体，兼容 URL 校验和 2.0 版本的事件
+type callbackRequest struct {
+	Encrypt   string `json:"encrypt"`
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Token     string `json:"token"`
+	Schema    string `json:"schema"`
+	Header    struct {
+		EventType string `json:"event_type"`
+		Token     string `json:"token"`
+	} `json:"header"`
+	Event struct {
+		Operator Operator `json:"operator"`
+		Token    string   `json:"token"`
+		Action   struct {
+			Value     map[string]any `json:"value"`
+			Tag       string         `json:"tag"`
+			Option    string         `json:"option"`
+			FormValue map[string]any `json:"form_value"`
+		} `json:"action"`
+		Context struct {
+			OpenMessageID string `json:"open_message_id"`
+			OpenChatID    string `json:"open_chat_id"`
+		} `json:"context"`
+	} `json:"event"`
+}
+
+func (h *CardHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.VerificationToken == "" && h.EncryptKey == "" {
+		http.Error(w, "callback secret is not configured", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCallbackBody))
+	if err != nil {
+		http.Error(w, "read body failed", http.StatusBadRequest)
+		return
+	}
+	signature := r.Header.Get("X-Lark-Signature")
+	if signature != "" && !h.verify(r.Header.Get("X-Lark-Request-Timestamp"), r.Header.Get("X-Lark-Request-Nonce"), signature, body) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	req, err := h.decode(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Type == "url_verification" {
+		if !h.checkToken(req.Token) {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		writeJSON(w, map[string]string{"challenge": req.Challenge})
+		return
+	}
+	if h.EncryptKey != "" && signature == "" {
+		http.Error(w, "missing signature", http.StatusUnauthorized)
+		return
+	}
+	if !h.checkToken(req.Header.Token) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	if req.Header.EventType != "card.action.trigger" {
+		writeJSON(w, struct{}{})
+		return
+	}
+
+	action := &CardAction{
+		Operator:  req.Event.Operator,
+		Value:     req.Event.Action.Value,
+		Tag:       req.Event.Action.Tag,
+		Option:    req.Event.Action.Option,
+		FormValue: req.Event.Action.FormValue,
+		MessageID: req.Event.Context.OpenMessageID,
+		ChatID:    req.Event.Context.OpenChatID,
+		Token:     req.Event.Token,
+	}
+	action.Action, _ = action.Value["action"].(string)
+	fn, ok := h.handlers[action.Action]
+	if !ok {
+		fn = h.handlers[""]
+	}
+	if fn == nil {
+		writeJSON(w, struct{}{})
+		return
+	}
+	resp, err := fn(r.Context(), action)
+	if err != nil {
+		log.Printf("飞书卡片回调 %s 处理失败: %v", action.Action, err)
+		writeJSON(w, map[string]any{"toast": Toast{Type: "error", Content: "处理失败，请稍后重试"}})
+		return
+	}
+	writeJSON(w, cardResponse(resp))
+}
+
+// cardResponse 转换为回调的响应体，卡片校验失败时提示错误，不更新卡片
+func cardResponse(resp *CardResponse) map[string]any {
+	out := map[string]any{}
+	if resp == nil {
+		return out
+	}
+	if resp.Toast != nil {
+		out["toast"] = resp.Toast
+	}
+	if resp.Card != nil {
+		if err := resp.Card.Validate(); err != nil {
+			log.Printf("飞书卡片回调返回的卡片无效: %v", err)
+			out["toast"] = Toast{Type: "error", Content: "卡片更新失败"}
+			return out
+		}
+		out["card"] = map[string]any{"type": "raw", "data": resp.Card}
+	}
+	return out
+}
+
+// verify 校验签名：sha256(timestamp + nonce + encrypt_key + body) 的十六进制，
+// 并拒绝 timestamp（秒）与当前时间相差超过 MaxSkew 的请求，防止重放
+func (h *CardHandler) verify(timestamp, nonce, signature string, body []byte) bool {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	maxSkew := h.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = defaultMaxSkew
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > maxSkew || skew < -maxSkew {
+		return false
+	}
+	s := sha256.New()
+	s.Write([]byte(timestamp + nonce + h.EncryptKey))
+	s.Write(body)
+	expected := hex.EncodeToString(s.Sum(nil))
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(signature)) == 1
+}
+
+// checkToken 校验 Verification Token，未配置时由签名和加密校验请求
+func (h *CardHandler) checkToken(token string) bool {
+	return h.VerificationToken == "" || subtle.ConstantTimeCompare([]byte(h.VerificationToken), []byte(token)) == 1
+}
+
+// decode 解析请求体，加密的请求先解密
+func (h *CardHandler) decode(body []byte) (*callbackRequest, error) {
+	var req callbackRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("invalid body: %w", err)
+	}
+	if req.Encrypt == "" {
+		if h.EncryptKey != "" {
+			return nil, errors.New("request is not encrypted")
+		}
+		return &req, nil
+	}
+	if h.EncryptKey == "" {
+		return nil, errors.New("encrypt key is not configured")
+	}
+	plain, err := Decrypt(req.Encrypt, h.EncryptKey)
+	if err != nil {
+		return nil, err
+	}
+	req = callbackRequest{}
+	if err = json.Unmarshal(plain, &req); err != nil {
+		return nil, fmt.Errorf("invalid decrypted body: %w", err)
+	}
+	return &req, nil
+}
+
+// Decrypt 解密回调请求：AES-256-CBC，密钥为 sha256(encryptKey)，密文的前 16 字节为 IV
+func Decrypt(encrypt, encryptKey string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encrypt)
+	if err != nil {
+		return nil, fmt.Errorf("decode encrypt: %w", err)
+	}
+	if len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid encrypt length")
+	}
+	key := sha256.Sum256([]byte(encryptKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	iv, data := data[:aes.BlockSize], data[aes.BlockSize:]
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > aes.BlockSize || pad > len(plain) {
+		return nil, errors.New("invalid padding")
+	}
+	return plain[:len(plain)-pad], nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,571 @@
+package lark
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MetadataCard Message.Metadata 中带有 *Card 时直接发送该卡片，用于通过 Manager 发送自定义卡片；
+// 经过 outbox 持久化后卡片为 map[string]any，发送时按 tag 重新解码
+const MetadataCard = "lark_card"
+
+// 卡片标题的颜色主题
+const (
+	TemplateBlue      = "blue"
+	TemplateWathet    = "wathet"
+	TemplateTurquoise = "turquoise"
+	TemplateGreen     = "green"
+	TemplateYellow    = "yellow"
+	TemplateOrange    = "orange"
+	TemplateRed       = "red"
+	TemplateCarmine   = "carmine"
+	TemplateViolet    = "violet"
+	TemplatePurple    = "purple"
+	TemplateIndigo    = "indigo"
+	TemplateGrey      = "grey"
+)
+
+// 按钮样式
+const (
+	ButtonDefault = "default"
+	ButtonPrimary = "primary"
+	ButtonDanger  = "danger"
+)
+
+// 文本类型
+const (
+	TextPlain    = "plain_text"
+	TextMarkdown = "lark_md"
+)
+
+// maxCardSize 卡片 JSON 的最大长度
+const maxCardSize = 30 * 1024
+
+var (
+	templates    = []string{TemplateBlue, TemplateWathet, TemplateTurquoise, TemplateGreen, TemplateYellow, TemplateOrange, TemplateRed, TemplateCarmine, TemplateViolet, TemplatePurple, TemplateIndigo, TemplateGrey}
+	buttonTypes  = []string{"", ButtonDefault, ButtonPrimary, ButtonDanger}
+	columnWidths = []string{"", "auto", "weighted"}
+)
+
+// Card 飞书消息卡片，使用 NewCard 和链式方法构建，发送前通过 Validate 校验
+type Card struct {
+	Config   *CardConfig `json:"config,omitempty"`
+	Header   *CardHeader `json:"header,omitempty"`
+	Elements []Element   `json:"elements"`
+}
+
+// CardConfig 卡片配置
+type CardConfig struct {
+	WideScreenMode bool `json:"wide_screen_mode"`
+	EnableForward  bool `json:"enable_forward"`
+	// UpdateMulti 更新卡片时所有接收人看到的内容都更新
+	UpdateMulti bool `json:"update_multi,omitempty"`
+}
+
+// CardHeader 卡片标题
+type CardHeader struct {
+	Title    Text   `json:"title"`
+	Subtitle *Text  `json:"subtitle,omitempty"`
+	Template string `json:"template,omitempty"`
+}
+
+// Text 卡片中的文本，Tag 为 plain_text 或 lark_md
+type Text struct {
+	Tag     string `json:"tag"`
+	Content string `json:"content"`
+}
+
+// Element 卡片中的元素：Div、Markdown、Hr、Note、Image、Action 和 ColumnSet
+type Element interface {
+	tag() string
+	validate() error
+}
+
+// Div 文本块，Fields 中 IsShort 的字段按两列排列
+type Div struct {
+	Text   *Text   `json:"text,omitempty"`
+	Fields []Field `json:"fields,omitempty"`
+}
+
+// Field Div 中的字段
+type Field struct {
+	IsShort bool `json:"is_short"`
+	Text    Text `json:"text"`
+}
+
+// Markdown Markdown 块，支持 lark_md 语法和 <at id=xxx></at>
+type Markdown struct {
+	Content string `json:"content"`
+}
+
+// Hr 分割线
+type Hr struct{}
+
+// Note 备注，显示为灰色小字，Elements 为 Text 或 Image
+type Note struct {
+	Elements []Element `json:"elements"`
+}
+
+// Image 图片，ImgKey 通过上传图片接口获取
+type Image struct {
+	ImgKey string `json:"img_key"`
+	Alt    Text   `json:"alt"`
+	Title  *Text  `json:"title,omitempty"`
+	Mode   string `json:"mode,omitempty"`
+}
+
+// Action 按钮组
+type Action struct {
+	Actions []Button `json:"actions"`
+	Layout  string   `json:"layout,omitempty"`
+}
+
+// Button 按钮，URL 为跳转链接，Value 为点击后回调的数据，两者至少设置一个
+type Button struct {
+	Text  Text           `json:"text"`
+	URL   string         `json:"url,omitempty"`
+	Type  string         `json:"type,omitempty"`
+	Value map[string]any `json:"value,omitempty"`
+}
+
+// ColumnSet 多列布局
+type ColumnSet struct {
+	FlexMode        string   `json:"flex_mode,omitempty"`
+	BackgroundStyle string   `json:"background_style,omitempty"`
+	Columns         []Column `json:"columns"`
+}
+
+// Column ColumnSet 中的一列，Width 为 weighted 时按 Weight 分配宽度
+type Column struct {
+	Width         string    `json:"width,omitempty"`
+	Weight        int       `json:"weight,omitempty"`
+	VerticalAlign string    `json:"vertical_align,omitempty"`
+	Elements      []Element `json:"elements"`
+}
+
+// NewCard 创建带标题的卡片，title 为空时不显示标题
+func NewCard(title string) *Card {
+	c := &Card{Config: &CardConfig{WideScreenMode: true, EnableForward: true}}
+	if title != "" {
+		c.Header = &CardHeader{Title: PlainText(title), Template: TemplateBlue}
+	}
+	return c
+}
+
+// PlainText 纯文本
+func PlainText(s string) Text {
+	return Text{Tag: TextPlain, Content: s}
+}
+
+// MarkdownText lark_md 文本
+func MarkdownText(s string) Text {
+	return Text{Tag: TextMarkdown, Content: s}
+}
+
+// ShortField 两列排列的 lark_md 字段，如 "**级别**\nP1"
+func ShortField(s string) Field {
+	return Field{IsShort: true, Text: MarkdownText(s)}
+}
+
+// LongField 独占一行的 lark_md 字段
+func LongField(s string) Field {
+	return Field{Text: MarkdownText(s)}
+}
+
+// LinkButton 跳转链接的按钮
+func LinkButton(text, url, typ string) Button {
+	return Button{Text: PlainText(text), URL: url, Type: typ}
+}
+
+// CallbackButton 点击后回调 value 的按钮，value 中的 "action" 用于 CardHandler 分发
+func CallbackButton(text string, value map[string]any, typ string) Button {
+	return Button{Text: PlainText(text), Value: value, Type: typ}
+}
+
+// AtUser lark_md 中 @ 用户，id 为 open_id、user_id 或邮箱
+func AtUser(id string) string {
+	return fmt.Sprintf("<at id=%s></at>", id)
+}
+
+// AtAll lark_md 中 @所有人
+func AtAll() string {
+	return "<at id=all></at>"
+}
+
+// Template 设置标题的颜色主题
+func (c *Card) Template(template string) *Card {
+	if c.Header != nil {
+		c.Header.Template = template
+	}
+	return c
+}
+
+// Subtitle 设置副标题
+func (c *Card) Subtitle(subtitle string) *Card {
+	if c.Header != nil && subtitle != "" {
+		t := PlainText(subtitle)
+		c.Header.Subtitle = &t
+	}
+	return c
+}
+
+// UpdateMulti 更新卡片时所有接收人看到的内容都更新，用于按钮回调后更新卡片
+func (c *Card) UpdateMulti() *Card {
+	if c.Config == nil {
+		c.Config = &CardConfig{}
+	}
+	c.Config.UpdateMulti = true
+	return c
+}
+
+// Add 添加元素
+func (c *Card) Add(elements ...Element) *Card {
+	c.Elements = append(c.Elements, elements...)
+	return c
+}
+
+// Markdown 添加 lark_md 文本块
+func (c *Card) Markdown(content string) *Card {
+	return c.Add(&Div{Text: &Text{Tag: TextMarkdown, Content: content}})
+}
+
+// Fields 添加字段，ShortField 两个一行
+func (c *Card) Fields(fields ...Field) *Card {
+	return c.Add(&Div{Fields: fields})
+}
+
+// Hr 添加分割线
+func (c *Card) Hr() *Card {
+	return c.Add(&Hr{})
+}
+
+// Buttons 添加按钮组
+func (c *Card) Buttons(buttons ...Button) *Card {
+	return c.Add(&Action{Actions: buttons})
+}
+
+// Note 添加备注
+func (c *Card) Note(content string) *Card {
+	return c.Add(&Note{Elements: []Element{&Text{Tag: TextMarkdown, Content: content}}})
+}
+
+// Image 添加图片
+func (c *Card) Image(imgKey, alt string) *Card {
+	return c.Add(&Image{ImgKey: imgKey, Alt: PlainText(alt)})
+}
+
+// Columns 添加多列布局
+func (c *Card) Columns(columns ...Column) *Card {
+	return c.Add(&ColumnSet{FlexMode: "none", Columns: columns})
+}
+
+// Validate 校验卡片结构：标题主题、文本类型、按钮、图片和多列布局的必填字段，以及卡片 JSON 的长度
+func (c *Card) Validate() error {
+	var errs []error
+	if c.Header != nil {
+		if err := c.Header.Title.validate(); err != nil || c.Header.Title.Content == "" {
+			errs = append(errs, fmt.Errorf("header.title: 标题不能为空"))
+		}
+		if c.Header.Template != "" && !slices.Contains(templates, c.Header.Template) {
+			errs = append(errs, fmt.Errorf("header.template: 不支持的颜色主题 %q", c.Header.Template))
+		}
+	}
+	if c.Header == nil && len(c.Elements) == 0 {
+		errs = append(errs, errors.New("卡片没有标题和内容"))
+	}
+	errs = append(errs, validateElements("elements", c.Elements)...)
+	if len(errs) == 0 {
+		b, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		if len(b) > maxCardSize {
+			errs = append(errs, fmt.Errorf("卡片 JSON 长度 %d 超过限制 %d", len(b), maxCardSize))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateElements(path string, elements []Element) []error {
+	var errs []error
+	for i, e := range elements {
+		if e == nil {
+			errs = append(errs, fmt.Errorf("%s[%d]: 元素不能为空", path, i))
+			continue
+		}
+		if err := e.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s[%d] %s: %w", path, i, e.tag(), err))
+		}
+	}
+	return errs
+}
+
+func (t *Text) tag() string { return t.Tag }
+func (t *Text) validate() error {
+	if t.Tag != TextPlain && t.Tag != TextMarkdown {
+		return fmt.Errorf("不支持的文本类型 %q", t.Tag)
+	}
+	return nil
+}
+
+func (d *Div) tag() string { return "div" }
+func (d *Div) validate() error {
+	if d.Text == nil && len(d.Fields) == 0 {
+		return errors.New("text 和 fields 不能都为空")
+	}
+	if d.Text != nil {
+		if err := d.Text.validate(); err != nil {
+			return err
+		}
+	}
+	for i := range d.Fields {
+		if err := d.Fields[i].Text.validate(); err != nil {
+			return fmt.Errorf("fields[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (m *Markdown) tag() string { return "markdown" }
+func (m *Markdown) validate() error {
+	if m.Content == "" {
+		return errors.New("content 不能为空")
+	}
+	return nil
+}
+
+func (h *Hr) tag() string     { return "hr" }
+func (h *Hr) validate() error { return nil }
+
+func (n *Note) tag() string { return "note" }
+func (n *Note) validate() error {
+	if len(n.Elements) == 0 {
+		return errors.New("elements 不能为空")
+	}
+	for _, e := range n.Elements {
+		switch e.(type) {
+		case *Text, *Image:
+		default:
+			return fmt.Errorf("不支持的元素 %s，只能是文本或图片", e.tag())
+		}
+	}
+	return errors.Join(validateElements("elements", n.Elements)...)
+}
+
+func (i *Image) tag() string { return "img" }
+func (i *Image) validate() error {
+	if i.ImgKey == "" {
+		return errors.New("img_key 不能为空")
+	}
+	return i.Alt.validate()
+}
+
+func (a *Action) tag() string { return "action" }
+func (a *Action) validate() error {
+	if len(a.Actions) == 0 {
+		return errors.New("actions 不能为空")
+	}
+	for i, b := range a.Actions {
+		if err := b.validate(); err != nil {
+			return fmt.Errorf("actions[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (b *Button) validate() error {
+	if b.Text.Content == "" {
+		return errors.New("按钮文字不能为空")
+	}
+	if err := b.Text.validate(); err != nil {
+		return err
+	}
+	if !slices.Contains(buttonTypes, b.Type) {
+		return fmt.Errorf("不支持的按钮样式 %q", b.Type)
+	}
+	if b.URL == "" && b.Value == nil {
+		return errors.New("url 和 value 不能都为空")
+	}
+	if b.URL != "" {
+		u, err := url.Parse(b.URL)
+		if err != nil || u.Scheme == "" || strings.EqualFold(u.Scheme, "javascript") {
+			return fmt.Errorf("无效的链接 %q", b.URL)
+		}
+	}
+	return nil
+}
+
+func (s *ColumnSet) tag() string { return "column_set" }
+func (s *ColumnSet) validate() error {
+	if len(s.Columns) == 0 {
+		return errors.New("columns 不能为空")
+	}
+	var errs []error
+	for i, col := range s.Columns {
+		if !slices.Contains(columnWidths, col.Width) {
+			errs = append(errs, fmt.Errorf("columns[%d]: 不支持的宽度 %q", i, col.Width))
+		}
+		if col.Width == "weighted" && (col.Weight < 1 || col.Weight > 5) {
+			errs = append(errs, fmt.Errorf("columns[%d]: weight 必须在 1 到 5 之间", i))
+		}
+		for _, e := range col.Elements {
+			if _, ok := e.(*ColumnSet); ok {
+				errs = append(errs, fmt.Errorf("columns[%d]: 不能嵌套多列布局", i))
+			}
+		}
+		errs = append(errs, validateElements(fmt.Sprintf("columns[%d].elements", i), col.Elements)...)
+	}
+	return errors.Join(errs...)
+}
+
+// MarshalJSON 输出元素时加上 tag 字段
+func (d *Div) MarshalJSON() ([]byte, error) {
+	type alias Div
+	return marshalTagged(d.tag(), (*alias)(d))
+}
+
+func (m *Markdown) MarshalJSON() ([]byte, error) {
+	type alias Markdown
+	return marshalTagged(m.tag(), (*alias)(m))
+}
+
+func (h *Hr) MarshalJSON() ([]byte, error) {
+	return marshalTagged(h.tag(), struct{}{})
+}
+
+func (n *Note) MarshalJSON() ([]byte, error) {
+	type alias Note
+	return marshalTagged(n.tag(), (*alias)(n))
+}
+
+func (i *Image) MarshalJSON() ([]byte, error) {
+	type alias Image
+	return marshalTagged(i.tag(), (*alias)(i))
+}
+
+func (a *Action) MarshalJSON() ([]byte, error) {
+	type alias Action
+	return marshalTagged(a.tag(), (*alias)(a))
+}
+
+func (b Button) MarshalJSON() ([]byte, error) {
+	type alias Button
+	return marshalTagged("button", alias(b))
+}
+
+func (s *ColumnSet) MarshalJSON() ([]byte, error) {
+	type alias ColumnSet
+	return marshalTagged(s.tag(), (*alias)(s))
+}
+
+func (c Column) MarshalJSON() ([]byte, error) {
+	type alias Column
+	return marshalTagged("column", alias(c))
+}
+
+// marshalTagged 在 JSON 对象的开头插入 "tag" 字段
+func marshalTagged(tag string, v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	b := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	head := `{"tag":` + strconv.Quote(tag)
+	if len(b) <= 2 {
+		return []byte(head + "}"), nil
+	}
+	return append([]byte(head+","), b[1:]...), nil
+}
+
+// UnmarshalJSON 按 tag 字段解码元素，用于从 JSON 恢复卡片，如经过 outbox 持久化的 MetadataCard
+func (c *Card) UnmarshalJSON(b []byte) error {
+	type alias Card
+	v := struct {
+		*alias
+		Elements []json.RawMessage `json:"elements"`
+	}{alias: (*alias)(c)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	var err error
+	c.Elements, err = unmarshalElements(v.Elements)
+	return err
+}
+
+func (n *Note) UnmarshalJSON(b []byte) error {
+	type alias Note
+	v := struct {
+		*alias
+		Elements []json.RawMessage `json:"elements"`
+	}{alias: (*alias)(n)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	var err error
+	n.Elements, err = unmarshalElements(v.Elements)
+	return err
+}
+
+func (c *Column) UnmarshalJSON(b []byte) error {
+	type alias Column
+	v := struct {
+		*alias
+		Elements []json.RawMessage `json:"elements"`
+	}{alias: (*alias)(c)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	var err error
+	c.Elements, err = unmarshalElements(v.Elements)
+	return err
+}
+
+// unmarshalElements 按 tag 字段创建对应类型的元素并解码
+func unmarshalElements(raws []json.RawMessage) ([]Element, error) {
+	if raws == nil {
+		return nil, nil
+	}
+	elements := make([]Element, 0, len(raws))
+	for i, raw := range raws {
+		var head struct {
+			Tag string `json:"tag"`
+		}
+		if err := json.Unmarshal(raw, &head); err != nil {
+			return nil, fmt.Errorf("elements[%d]: %w", i, err)
+		}
+		var e Element
+		switch head.Tag {
+		case "div":
+			e = &Div{}
+		case "markdown":
+			e = &Markdown{}
+		case "hr":
+			e = &Hr{}
+		case "note":
+			e = &Note{}
+		case "img":
+			e = &Image{}
+		case "action":
+			e = &Action{}
+		case "column_set":
+			e = &ColumnSet{}
+		case TextPlain, TextMarkdown:
+			e = &Text{}
+		default:
+			return nil, fmt.Errorf("elements[%d]: 不支持的元素 %q", i, head.Tag)
+		}
+		if err := json.Unmarshal(raw, e); err != nil {
+			return nil, fmt.Errorf("elements[%d] %s: %w", i, head.Tag, err)
+		}
+		elements = append(elements, e)
+	}
+	return elements, nil
+}
@@ -1,15 +1,19 @@
 package lark
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/markdown"
 	"github.com/v-mars/notify/result"
 	"github.com/v-mars/notify/types"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -48,6 +52,14 @@ type Lark struct {
 	ElementsTag  string
 }
 
+// retryableErrCodes 可以重试的错误码：请求频率超限，
+// 其他错误码（如 19021 签名校验失败、19022 IP 不在白名单、19024 关键词校验失败）为永久错误
+var retryableErrCodes = map[int]bool{
+	9499:     true,
+	11232:    true,
+	99991400: true,
+}
+
 // Result post resp
 type Result struct {
 	Code int    `json:"code"`
@@ -60,11 +72,11 @@ type Content struct {
 
 // SendMsg post json data
 type SendMsg struct {
-	Timestamp string         `json:"timestamp"`
-	Sign      string         `json:"sign"`
-	MsgType   string         `json:"msg_type"`
-	Content   Content        `json:"content"`
-	Card      map[string]any `json:"card"`
+	Timestamp string  `json:"timestamp"`
+	Sign      string  `json:"sign"`
+	MsgType   string  `json:"msg_type"`
+	Content   Content `json:"content"`
+	Card      *Card   `json:"card,omitempty"`
 }
 
 // NewLark init a Lark send conf
@@ -94,7 +106,57 @@ func NewLark(webHookUrl string, sl Secure, secret string) *Lark {
 }
 
 // Send to notify tos is phone number
-func (d *Lark) Send(tos []string, title string, content string) (sendResult *result.SendResult, err error) {
+func (d *Lark) Send(tos []string, title string, content string) (*result.SendResult, error) {
+	return d.SendContext(context.Background(), tos, title, content)
+}
+
+// SendContext 发送消息，ctx 超时或取消时中断请求
+func (d *Lark) SendContext(ctx context.Context, tos []string, title string, content string) (*result.SendResult, error) {
+	return d.SendMessage(ctx, tos, notify.NewMessage(title, content))
+}
+
+// SendMessage 发送结构化消息，配置了 AppID 时通过应用机器人发送给 tos，否则发送到自定义机器人所在的群
+// 消息带有 Markdown 正文或链接时，text 类型自动升级为 interactive 卡片；
+// Metadata 中带有 MetadataCard 时发送该卡片
+func (d *Lark) SendMessage(ctx context.Context, tos []string, msg *notify.Message) (*result.SendResult, error) {
+	if card, err := notify.MetadataValue[Card](msg, MetadataCard); err != nil || card != nil {
+		if err != nil {
+			return nil, err
+		}
+		return d.SendCard(ctx, tos, card)
+	}
+	msgType := d.MsgType
+	if msgType == "text" && (msg.Markdown != "" || len(msg.Links) > 0) {
+		msgType = "interactive"
+	}
+	if msgType == "interactive" {
+		return d.SendCard(ctx, tos, d.card(msg))
+	}
+	if d.AppID != "" {
+		return d.sendApp(ctx, tos, "text", Content{Text: strings.TrimPrefix(msg.Title+"\n"+textBody(msg), "\n")})
+	}
+	return d.sendWebhook(ctx, SendMsg{
+		MsgType: msgType,
+		Content: Content{
+			Text: msg.Title + "\n" + textBody(msg) + "\n",
+		},
+	})
+}
+
+// SendCard 校验并发送卡片，校验失败时不发送，返回永久错误
+func (d *Lark) SendCard(ctx context.Context, tos []string, card *Card) (*result.SendResult, error) {
+	if err := card.Validate(); err != nil {
+		err = notify.Permanent(fmt.Errorf("飞书卡片校验失败: %w", err))
+		return &result.SendResult{ChannelType: NotifyTypeLark, SendTime: time.Now(), Error: result.PtrOf(err.Error())}, err
+	}
+	if d.AppID != "" {
+		return d.sendApp(ctx, tos, "interactive", card)
+	}
+	return d.sendWebhook(ctx, SendMsg{MsgType: "interactive", Card: card})
+}
+
+// sendWebhook 通过自定义机器人发送，配置了 Secret 时签名
+func (d *Lark) sendWebhook(ctx context.Context, sendMsg SendMsg) (sendResult *result.SendResult, err error) {
 	sendResult = d.Result
 	defer func() {
 		sendResult.CostMs = time.Now().Sub(sendResult.SendTime).Milliseconds()
@@ -113,39 +175,10 @@ func (d *Lark) Send(tos []string, title string, content string) (sendResult *res
 			return sendResult, err
 		}
 	}
+	sendMsg.Timestamp = fmt.Sprintf("%d", timestamp)
+	sendMsg.Sign = sign
 
-	sendMsg := SendMsg{
-		Timestamp: fmt.Sprintf("%d", timestamp),
-		Sign:      sign,
-		MsgType:   d.MsgType,
-		Content: Content{
-			Text: title + "\n" + content + "\n",
-		},
-		Card: map[string]any{
-			"header": map[string]interface{}{
-				"title": map[string]interface{}{
-					"tag":     d.TitleTag,
-					"content": title, // 卡片标题
-				},
-				"subtitle": map[string]interface{}{
-					"tag":     d.TitleTag,
-					"content": d.Subtitle, // 卡片标题
-				},
-				"template": d.CardTemplate, // 卡片颜色主题：red, blue, green, orange 等
-			},
-			"elements": []interface{}{
-				map[string]interface{}{
-					"tag": "div",
-					"text": map[string]interface{}{
-						"tag":     d.ElementsTag, // 飞书支持的 Markdown 标签
-						"content": content,
-					},
-				},
-			},
-		},
-	}
-
-	resp, err := notify.JSONPost(http.MethodPost, reqUrl, sendMsg, http.DefaultClient, nil)
+	resp, err := notify.JSONPostContext(ctx, http.MethodPost, reqUrl, sendMsg, apiClient, nil)
 	if err != nil {
 		return sendResult, err
 	}
@@ -155,13 +188,93 @@ func (d *Lark) Send(tos []string, title string, content string) (sendResult *res
 		return sendResult, err
 	}
 	if res.Code != 0 {
-		return sendResult, fmt.Errorf("errmsg: %s errcode: %d", res.Msg, res.Code)
+		return sendResult, notify.NewError(strconv.Itoa(res.Code), retryableErrCodes[res.Code],
+			fmt.Errorf("errmsg: %s errcode: %d", res.Msg, res.Code))
 	}
 	return sendResult, nil
 }
 
+// card 构建 interactive 卡片，高优先级消息使用红色主题
+func (d *Lark) card(msg *notify.Message) *Card {
+	template := d.CardTemplate
+	if msg.IsUrgent() {
+		template = TemplateRed
+	}
+	content := msg.TextBody()
+	if msg.Markdown != "" {
+		content = markdown.Convert(msg.Markdown, markdown.Lark)
+	}
+	if mention := mdMentions(msg.Mentions); mention != "" {
+		content += "\n" + mention
+	}
+	card := NewCard(msg.Title).Template(template).Subtitle(d.Subtitle)
+	if card.Header != nil {
+		card.Header.Title.Tag = d.TitleTag
+	}
+	card.Add(&Div{Text: &Text{Tag: d.ElementsTag, Content: content}})
+	if len(msg.Links) > 0 {
+		var buttons []Button
+		for _, link := range msg.Links {
+			style := link.Style
+			if style == "" {
+				style = ButtonDefault
+			}
+			buttons = append(buttons, LinkButton(link.Text, link.URL, style))
+		}
+		card.Buttons(buttons...)
+	}
+	return card
+}
+
+// textBody 纯文本正文，附加链接和 @ 信息
+func textBody(msg *notify.Message) string {
+	text := msg.TextBody()
+	for _, link := range msg.Links {
+		text += "\n" + link.Text + ": " + link.URL
+	}
+	var ats []string
+	if msg.Mentions.All {
+		ats = append(ats, `<at user_id="all">所有人</at>`)
+	}
+	for _, u := range msg.Mentions.Users {
+		ats = append(ats, fmt.Sprintf(`<at user_id="%s"></at>`, u))
+	}
+	if len(ats) > 0 {
+		text += "\n" + strings.Join(ats, " ")
+	}
+	return text
+}
+
+// mdMentions lark_md 中的 @ 语法
+func mdMentions(m notify.Mentions) string {
+	var ats []string
+	if m.All {
+		ats = append(ats, AtAll())
+	}
+	for _, u := range m.Users {
+		ats = append(ats, AtUser(u))
+	}
+	return strings.Join(ats, " ")
+}
+
 const NotifyTypeLark = "lark"
 
+func init() {
+	notify.Register(NotifyTypeLark, func(section notify.Section) (notify.Sender, error) {
+		var conf types.Lark
+		if err := section.Decode(&conf); err != nil {
+			return nil, err
+		}
+		l := NewLark(conf.WebhookUrl, Sign, conf.Secret)
+		msgType := l.MsgType
+		l.Lark = conf
+		if conf.MsgType == "" {
+			l.MsgType = msgType
+		}
+		return l, nil
+	})
+}
+
 func (d *Lark) ChannelType() string {
 	return NotifyTypeLark
 }
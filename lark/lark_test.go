@@ -1,16 +1,270 @@
 package lark
 
 import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/outbox"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewLark(t *testing.T) {
 	l := NewLark("https://open.feishu.cn/open-apis/bot/v2/hook/xxx",
 		1, "xxxx")
-	err := l.Send([]string{}, "lark title", "test lark text example")
+	_, err := l.Send([]string{}, "lark title", "test lark text example")
 	if err != nil {
 		fmt.Println("err:", err)
 		return
 	}
 }
+
+func TestLarkApp(t *testing.T) {
+	var tokens int
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth/v3/tenant_access_token/internal" {
+			tokens++
+			_, _ = w.Write([]byte(`{"code":0,"tenant_access_token":"t-1","expire":7200}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer t-1" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		var m AppMsg
+		_ = json.NewDecoder(r.Body).Decode(&m)
+		requests = append(requests, r.Method+" "+r.URL.RequestURI()+" "+m.ReceiveID+" "+m.MsgType)
+		if m.ReceiveID == "ou_gone" {
+			_, _ = w.Write([]byte(`{"code":230013,"msg":"Bot has NO availability to this user."}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"code":0,"data":{"message_id":"om_1"}}`))
+	}))
+	defer srv.Close()
+
+	l := NewLarkApp("cli_1", "secret")
+	l.APIBaseURL = srv.URL
+	r, err := l.SendMessage(t.Context(), []string{"ou_1", "a@x.io", "oc_1", "user_id:u1", "ou_gone"}, &notify.Message{Title: "t", Markdown: "**x**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *r.ChannelMsgID != "om_1" || len(r.FailedRecipients()) != 1 {
+		t.Errorf("result = %+v", r)
+	}
+	if err = l.Update(t.Context(), "om_1", &notify.Message{Markdown: "**y**"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Recall(t.Context(), "om_1"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"POST /im/v1/messages?receive_id_type=open_id ou_1 interactive",
+		"POST /im/v1/messages?receive_id_type=email a@x.io interactive",
+		"POST /im/v1/messages?receive_id_type=chat_id oc_1 interactive",
+		"POST /im/v1/messages?receive_id_type=user_id u1 interactive",
+		"POST /im/v1/messages?receive_id_type=open_id ou_gone interactive",
+		"PATCH /im/v1/messages/om_1  ",
+		"DELETE /im/v1/messages/om_1  ",
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests:\n%s", strings.Join(requests, "\n"))
+	}
+	if tokens != 1 {
+		t.Errorf("fetched tenant_access_token %d times, want 1", tokens)
+	}
+
+	// 同一个应用的新实例共用缓存的 token
+	l2 := NewLarkApp("cli_1", "secret")
+	l2.APIBaseURL = srv.URL
+	_, err = l2.Send([]string{"ou_gone"}, "t", "c")
+	if tokens != 1 {
+		t.Errorf("fetched tenant_access_token %d times across instances, want 1", tokens)
+	}
+	if err == nil || notify.IsRetryable(err) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestCard(t *testing.T) {
+	card := NewCard("磁盘使用率过高").Template(TemplateRed).Subtitle("prod").
+		Fields(ShortField("**级别**\nP1"), ShortField("**主机**\ndb1")).
+		Markdown("当前使用率 **95%** "+AtUser("ou_1")).
+		Hr().
+		Columns(Column{Width: "weighted", Weight: 1, Elements: []Element{&Markdown{Content: "左"}}}, Column{Width: "auto", Elements: []Element{&Image{ImgKey: "img_1", Alt: PlainText("图")}}}).
+		Buttons(LinkButton("Runbook", "https://wiki/runbook", ButtonPrimary), CallbackButton("静默 1h", map[string]any{"action": "silence"}, ButtonDanger)).
+		Note("来自 notify")
+	if err := card.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"header":{"title":{"tag":"plain_text","content":"磁盘使用率过高"},"subtitle":{"tag":"plain_text","content":"prod"},"template":"red"}`,
+		`{"tag":"div","fields":[{"is_short":true,"text":{"tag":"lark_md","content":"**级别**\nP1"}}`,
+		`{"tag":"hr"}`,
+		`{"tag":"column_set","flex_mode":"none","columns":[{"tag":"column","width":"weighted","weight":1,"elements":[{"tag":"markdown","content":"左"}]}`,
+		`{"tag":"img","img_key":"img_1","alt":{"tag":"plain_text","content":"图"}}`,
+		`{"tag":"button","text":{"tag":"plain_text","content":"Runbook"},"url":"https://wiki/runbook","type":"primary"}`,
+		`"value":{"action":"silence"}`,
+		`{"tag":"note","elements":[{"tag":"lark_md","content":"来自 notify"}]}`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("card JSON does not contain %s:\n%s", want, b)
+		}
+	}
+
+	bad := NewCard("t").Template("pink").
+		Buttons(Button{Text: PlainText("x")}, LinkButton("y", "javascript:alert(1)", "")).
+		Add(&Div{}, &Image{}, &ColumnSet{Columns: []Column{{Width: "weighted"}}})
+	err = bad.Validate()
+	for _, want := range []string{"颜色主题", "url 和 value 不能都为空", "elements[1] div", "img_key", "weight"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() = %v, want error containing %q", err, want)
+		}
+	}
+
+	r, err := NewLark("http://127.0.0.1:1", Sign, "").SendCard(t.Context(), nil, bad)
+	if err == nil || notify.IsRetryable(err) || r.Success {
+		t.Errorf("SendCard with invalid card: err = %v", err)
+	}
+}
+
+func TestCardMetadataFileStore(t *testing.T) {
+	var got map[string]json.RawMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"code":0,"msg":"success"}`))
+	}))
+	defer srv.Close()
+
+	card := NewCard("发布审批").Template(TemplateOrange).
+		Markdown("**api** v1.2").
+		Columns(Column{Width: "auto", Elements: []Element{&Markdown{Content: "左"}}}).
+		Buttons(CallbackButton("同意", map[string]any{"action": "approve"}, ButtonPrimary)).
+		Note("来自 notify")
+	want, _ := json.Marshal(card)
+
+	// 经过 FileStore 持久化后 Metadata 中的卡片为 map[string]any
+	store, err := outbox.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &notify.Message{Title: "发布", Metadata: map[string]any{MetadataCard: card}}
+	if err = store.Save(&outbox.Record{ID: "r1", Message: msg, Status: outbox.StatusPending}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := store.Get("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.Message.Metadata[MetadataCard].(*Card); ok {
+		t.Fatal("metadata was not round-tripped through JSON")
+	}
+	if _, err = NewLark(srv.URL, Sign, "").SendMessage(t.Context(), nil, r.Message); err != nil {
+		t.Fatal(err)
+	}
+	if got["msg_type"] == nil || string(got["card"]) != string(want) {
+		t.Errorf("card = %s, want %s", got["card"], want)
+	}
+
+	r.Message.Metadata[MetadataCard] = map[string]any{"elements": []any{map[string]any{"tag": "unknown"}}}
+	if _, err = NewLark(srv.URL, Sign, "").SendMessage(t.Context(), nil, r.Message); err == nil || notify.IsRetryable(err) {
+		t.Errorf("invalid card err = %v", err)
+	}
+}
+
+func TestCardHandler(t *testing.T) {
+	const token, key = "v-token", "e-key"
+	if _, err := NewCardHandler("", ""); err == nil {
+		t.Error("NewCardHandler without secrets should fail")
+	}
+	h, err := NewCardHandler(token, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Handle("ack", func(ctx context.Context, a *CardAction) (*CardResponse, error) {
+		card := NewCard("已确认").Template(TemplateGreen).Markdown("由 " + AtUser(a.Operator.OpenID) + " 确认")
+		return &CardResponse{Toast: &Toast{Type: "success", Content: "ok"}, Card: card}, nil
+	})
+	h.Handle("fail", func(ctx context.Context, a *CardAction) (*CardResponse, error) {
+		return nil, errors.New("boom")
+	})
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	post := func(payload string, sign bool) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"encrypt": encrypt(t, payload, key)})
+		req := httptest.NewRequest(http.MethodPost, "/lark/card", bytes.NewReader(body))
+		if sign {
+			s := sha256.Sum256(append([]byte(timestamp+"nonce"+key), body...))
+			req.Header.Set("X-Lark-Request-Timestamp", timestamp)
+			req.Header.Set("X-Lark-Request-Nonce", "nonce")
+			req.Header.Set("X-Lark-Signature", hex.EncodeToString(s[:]))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"type":"url_verification","challenge":"c-1","token":"v-token"}`, false)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"challenge":"c-1"`) {
+		t.Errorf("challenge: %d %s", w.Code, w.Body)
+	}
+
+	event := `{"schema":"2.0","header":{"event_type":"card.action.trigger","token":"v-token"},
+		"event":{"operator":{"open_id":"ou_1"},"action":{"tag":"button","value":{"action":"%s"}},"context":{"open_message_id":"om_1"}}}`
+	w = post(fmt.Sprintf(event, "ack"), true)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"card":{"data":{"config"`) || !strings.Contains(w.Body.String(), "<at id=ou_1></at>") {
+		t.Errorf("ack: %d %s", w.Code, w.Body)
+	}
+	w = post(fmt.Sprintf(event, "fail"), true)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"toast":{"type":"error","content":"处理失败，请稍后重试"}`) {
+		t.Errorf("fail: %d %s", w.Code, w.Body)
+	}
+	if w = post(fmt.Sprintf(event, "ack"), false); w.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned request: %d", w.Code)
+	}
+	if w = post(strings.Replace(fmt.Sprintf(event, "ack"), "v-token", "other", 1), true); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: %d", w.Code)
+	}
+	// 签名正确但 timestamp 超出 MaxSkew 的请求视为重放
+	timestamp = strconv.FormatInt(time.Now().Add(-2*time.Hour).Unix(), 10)
+	if w = post(fmt.Sprintf(event, "ack"), true); w.Code != http.StatusUnauthorized {
+		t.Errorf("stale timestamp: %d", w.Code)
+	}
+
+	// 没有配置密钥的处理器拒绝所有请求
+	w = httptest.NewRecorder()
+	(&CardHandler{}).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/lark/card", strings.NewReader(`{"type":"url_verification","challenge":"c"}`)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("handler without secrets: %d", w.Code)
+	}
+}
+
+// encrypt 按飞书的方式加密回调请求
+func encrypt(t *testing.T, plain, key string) string {
+	k := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(k[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	data := append([]byte(plain), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	out := make([]byte, aes.BlockSize+len(data))
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], data)
+	return base64.StdEncoding.EncodeToString(out)
+}
@@ -0,0 +1,140 @@
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// renderHTML 渲染为邮件使用的 HTML 片段，文本和属性都会转义
+func renderHTML(doc *Node) string {
+	var b strings.Builder
+	for _, n := range doc.Children {
+		htmlBlock(&b, n, false)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// htmlBlock tight 为 true 时段落不输出 <p>，用于紧凑列表的列表项
+func htmlBlock(b *strings.Builder, n *Node, tight bool) {
+	switch n.Kind {
+	case Paragraph:
+		if tight {
+			htmlInlines(b, n.Children)
+			return
+		}
+		b.WriteString("<p>")
+		htmlInlines(b, n.Children)
+		b.WriteString("</p>\n")
+	case Heading:
+		fmt.Fprintf(b, "<h%d>", n.Level)
+		htmlInlines(b, n.Children)
+		fmt.Fprintf(b, "</h%d>\n", n.Level)
+	case CodeBlock:
+		b.WriteString("<pre><code")
+		if n.Info != "" {
+			fmt.Fprintf(b, ` class="language-%s"`, html.EscapeString(n.Info))
+		}
+		b.WriteString(">")
+		b.WriteString(html.EscapeString(n.Text))
+		b.WriteString("</code></pre>\n")
+	case Quote:
+		b.WriteString("<blockquote>\n")
+		for _, c := range n.Children {
+			htmlBlock(b, c, false)
+		}
+		b.WriteString("</blockquote>\n")
+	case List:
+		tag := "ul"
+		if n.Ordered {
+			tag = "ol"
+		}
+		b.WriteString("<" + tag)
+		if n.Ordered && n.Start != 1 {
+			fmt.Fprintf(b, ` start="%d"`, n.Start)
+		}
+		b.WriteString(">\n")
+		for _, item := range n.Children {
+			b.WriteString("<li>")
+			for i, c := range item.Children {
+				if i > 0 && c.Kind == Paragraph {
+					b.WriteString("<br>")
+				}
+				htmlBlock(b, c, true)
+			}
+			b.WriteString("</li>\n")
+		}
+		b.WriteString("</" + tag + ">\n")
+	case Rule:
+		b.WriteString("<hr>\n")
+	case Table:
+		b.WriteString("<table>\n")
+		for i, row := range n.Children {
+			cell := "td"
+			if i == 0 {
+				cell = "th"
+				b.WriteString("<thead>\n")
+			} else if i == 1 {
+				b.WriteString("<tbody>\n")
+			}
+			b.WriteString("<tr>")
+			for _, c := range row.Children {
+				b.WriteString("<" + cell + ">")
+				htmlInlines(b, c.Children)
+				b.WriteString("</" + cell + ">")
+			}
+			b.WriteString("</tr>\n")
+			if i == 0 {
+				b.WriteString("</thead>\n")
+			}
+		}
+		if len(n.Children) > 1 {
+			b.WriteString("</tbody>\n")
+		}
+		b.WriteString("</table>\n")
+	}
+}
+
+func htmlInlines(b *strings.Builder, nodes []*Node) {
+	for _, n := range nodes {
+		switch n.Kind {
+		case Text:
+			b.WriteString(html.EscapeString(n.Text))
+		case Emphasis:
+			htmlWrap(b, "em", n.Children)
+		case Strong:
+			htmlWrap(b, "strong", n.Children)
+		case Strike:
+			htmlWrap(b, "del", n.Children)
+		case Code:
+			b.WriteString("<code>" + html.EscapeString(n.Text) + "</code>")
+		case Link:
+			fmt.Fprintf(b, `<a href="%s">`, html.EscapeString(safeURL(n.URL)))
+			htmlInlines(b, n.Children)
+			b.WriteString("</a>")
+		case Image:
+			fmt.Fprintf(b, `<img src="%s" alt="%s">`, html.EscapeString(safeURL(n.URL)), html.EscapeString(n.PlainText()))
+		case SoftBreak:
+			b.WriteString("\n")
+		case HardBreak:
+			b.WriteString("<br>\n")
+		}
+	}
+}
+
+func htmlWrap(b *strings.Builder, tag string, children []*Node) {
+	b.WriteString("<" + tag + ">")
+	htmlInlines(b, children)
+	b.WriteString("</" + tag + ">")
+}
+
+// safeURL 过滤 javascript: 等可以执行脚本的链接
+func safeURL(url string) string {
+	lower := strings.ToLower(strings.TrimSpace(url))
+	for _, scheme := range []string{"javascript:", "vbscript:", "data:"} {
+		if strings.HasPrefix(lower, scheme) && !strings.HasPrefix(lower, "data:image/") {
+			return "#"
+		}
+	}
+	return url
+}
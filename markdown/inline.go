@@ -0,0 +1,329 @@
+package markdown
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var autolinkRegexp = regexp.MustCompile(`^<((?:[a-zA-Z][a-zA-Z0-9+.-]{1,31}:[^\s<>]*)|(?:[a-zA-Z0-9.!#$%&'*+/=?^_{|}~-]+@[a-zA-Z0-9.-]+))>`)
+
+const punctuation = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+// parseInline 解析行内元素：转义、代码、强调、删除线、链接、图片、自动链接和换行
+func parseInline(s string) []*Node {
+	var nodes []*Node
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			nodes = append(nodes, &Node{Kind: Text, Text: text.String()})
+			text.Reset()
+		}
+	}
+	emit := func(n *Node) {
+		flush()
+		nodes = append(nodes, n)
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			emit(&Node{Kind: HardBreak})
+			i += 2
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(punctuation, s[i+1]) >= 0:
+			text.WriteByte(s[i+1])
+			i += 2
+		case c == '\n':
+			if strings.HasSuffix(text.String(), "  ") {
+				trimmed := strings.TrimRight(text.String(), " ")
+				text.Reset()
+				text.WriteString(trimmed)
+				emit(&Node{Kind: HardBreak})
+			} else {
+				t := strings.TrimRight(text.String(), " ")
+				text.Reset()
+				text.WriteString(t)
+				emit(&Node{Kind: SoftBreak})
+			}
+			i++
+			for i < len(s) && s[i] == ' ' {
+				i++
+			}
+		case c == '`':
+			n := runLength(s, i)
+			end := findCodeClose(s, i+n, n)
+			if end < 0 {
+				text.WriteString(s[i : i+n])
+				i += n
+				continue
+			}
+			code := strings.ReplaceAll(s[i+n:end], "\n", " ")
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+				code = code[1 : len(code)-1]
+			}
+			emit(&Node{Kind: Code, Text: code})
+			i = end + n
+		case c == '!' && i+1 < len(s) && s[i+1] == '[':
+			if n, next, ok := parseLink(s, i+1); ok {
+				n.Kind = Image
+				emit(n)
+				i = next
+				continue
+			}
+			text.WriteByte(c)
+			i++
+		case c == '[':
+			if n, next, ok := parseLink(s, i); ok {
+				emit(n)
+				i = next
+				continue
+			}
+			text.WriteByte(c)
+			i++
+		case c == '<':
+			if m := autolinkRegexp.FindStringSubmatch(s[i:]); m != nil {
+				url := m[1]
+				if !strings.Contains(url, ":") {
+					url = "mailto:" + url
+				}
+				emit(&Node{Kind: Link, URL: url, Children: []*Node{{Kind: Text, Text: m[1]}}})
+				i += len(m[0])
+				continue
+			}
+			text.WriteByte(c)
+			i++
+		case c == '*' || c == '_' || c == '~':
+			if n, next, ok := parseEmphasis(s, i); ok {
+				emit(n)
+				i = next
+				continue
+			}
+			n := runLength(s, i)
+			text.WriteString(s[i : i+n])
+			i += n
+		default:
+			text.WriteByte(c)
+			i++
+		}
+	}
+	flush()
+	return nodes
+}
+
+func runLength(s string, i int) int {
+	n := 1
+	for i+n < len(s) && s[i+n] == s[i] {
+		n++
+	}
+	return n
+}
+
+// findCodeClose 查找与开头长度相同的反引号
+func findCodeClose(s string, from, n int) int {
+	for i := from; i < len(s); {
+		if s[i] != '`' {
+			i++
+			continue
+		}
+		l := runLength(s, i)
+		if l == n {
+			return i
+		}
+		i += l
+	}
+	return -1
+}
+
+// parseLink 解析 [text](url "title")，i 指向 [
+func parseLink(s string, i int) (*Node, int, bool) {
+	depth := 0
+	end := -1
+	for j := i; j < len(s) && end < 0; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '`':
+			n := runLength(s, j)
+			if close := findCodeClose(s, j+n, n); close >= 0 {
+				j = close + n - 1
+			} else {
+				j += n - 1
+			}
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				end = j
+			}
+		}
+	}
+	if end < 0 || end+1 >= len(s) || s[end+1] != '(' {
+		return nil, 0, false
+	}
+	j := end + 2
+	for j < len(s) && s[j] == ' ' {
+		j++
+	}
+	var url string
+	if j < len(s) && s[j] == '<' {
+		close := strings.IndexByte(s[j:], '>')
+		if close < 0 {
+			return nil, 0, false
+		}
+		url = s[j+1 : j+close]
+		j += close + 1
+	} else {
+		start, parens := j, 0
+		for ; j < len(s) && s[j] != ' ' && s[j] != '\n'; j++ {
+			if s[j] == '\\' && j+1 < len(s) {
+				j++
+				continue
+			}
+			if s[j] == '(' {
+				parens++
+			} else if s[j] == ')' {
+				if parens == 0 {
+					break
+				}
+				parens--
+			}
+		}
+		url = unescape(s[start:j])
+	}
+	for j < len(s) && (s[j] == ' ' || s[j] == '\n') {
+		j++
+	}
+	if j < len(s) && (s[j] == '"' || s[j] == '\'') {
+		close := strings.IndexByte(s[j+1:], s[j])
+		if close < 0 {
+			return nil, 0, false
+		}
+		j += close + 2
+		for j < len(s) && s[j] == ' ' {
+			j++
+		}
+	}
+	if j >= len(s) || s[j] != ')' {
+		return nil, 0, false
+	}
+	return &Node{Kind: Link, URL: url, Children: parseInline(s[i+1 : end])}, j + 1, true
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(punctuation, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseEmphasis 解析 *em*、_em_、**strong**、__strong__ 和 ~~strike~~，i 指向分隔符
+func parseEmphasis(s string, i int) (*Node, int, bool) {
+	c := s[i]
+	run := runLength(s, i)
+	// 左侧分隔符后不能是空白，_ 不能出现在单词中间
+	if i+run >= len(s) || isSpace(s, i+run) {
+		return nil, 0, false
+	}
+	if c == '_' && i > 0 && isWord(s, i-1, true) {
+		return nil, 0, false
+	}
+
+	type attempt struct {
+		n    int
+		kind Kind
+	}
+	var attempts []attempt
+	switch {
+	case c == '~':
+		if run != 2 {
+			return nil, 0, false
+		}
+		attempts = []attempt{{2, Strike}}
+	case run >= 2:
+		attempts = []attempt{{2, Strong}, {1, Emphasis}}
+	default:
+		attempts = []attempt{{1, Emphasis}}
+	}
+	for _, a := range attempts {
+		start := i + a.n
+		close := findEmphasisClose(s, start, c, a.n)
+		if close < 0 {
+			continue
+		}
+		n := &Node{Kind: a.kind, Children: parseInline(s[start:close])}
+		if a.n == 1 && run >= 2 {
+			// ** 未闭合时，第一个 * 按文本处理
+			return nil, 0, false
+		}
+		return n, close + a.n, true
+	}
+	return nil, 0, false
+}
+
+// findEmphasisClose 查找右侧分隔符：前面不能是空白，_ 后面不能紧跟单词字符；
+// 查找单个分隔符时跳过成对的 ** 或 __（属于嵌套的强调）
+func findEmphasisClose(s string, from int, c byte, n int) int {
+	for j := from; j < len(s); {
+		switch s[j] {
+		case '\\':
+			j += 2
+			continue
+		case '`':
+			l := runLength(s, j)
+			if close := findCodeClose(s, j+l, l); close >= 0 {
+				j = close + l
+			} else {
+				j += l
+			}
+			continue
+		case c:
+		default:
+			j++
+			continue
+		}
+		l := runLength(s, j)
+		candidate := -1
+		switch {
+		case c == '~':
+			if l == 2 {
+				candidate = j
+			}
+		case n == 2 && l >= 2:
+			candidate = j + l - 2
+		case n == 1 && l != 2:
+			candidate = j + l - 1
+		}
+		if candidate > from && !isSpace(s, candidate-1) &&
+			!(c == '_' && candidate+n < len(s) && isWord(s, candidate+n, false)) {
+			return candidate
+		}
+		j += l
+	}
+	return -1
+}
+
+func isSpace(s string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return unicode.IsSpace(r)
+}
+
+// isWord s[i] 所在的字符是否是字母或数字，before 为 true 时 i 指向前一个字符的最后一个字节
+func isWord(s string, i int, before bool) bool {
+	var r rune
+	if before {
+		r, _ = utf8.DecodeLastRuneInString(s[:i+1])
+	} else {
+		r, _ = utf8.DecodeRuneInString(s[i:])
+	}
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
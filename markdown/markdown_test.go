@@ -0,0 +1,113 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+const doc = "# Deploy *done*\n\n" +
+	"Service **api** v1.2 `ok` ~~old~~ [log](https://x.io/a_(b))\n\n" +
+	"- a\n  - b\n- c\n\n" +
+	"> note\n\n" +
+	"```sh\nmake all\n```\n\n" +
+	"| Host | CPU |\n|---|---|\n| db1 | 80% |"
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    []string
+	}{
+		{DingTalk, []string{
+			"# Deploy *done*",
+			"Service **api** v1.2 ok old [log](https://x.io/a_(b))",
+			"- a\n　◦ b\n- c",
+			"> note",
+			"> make all",
+			"- **Host**: db1; **CPU**: 80%",
+		}},
+		{Lark, []string{
+			"**Deploy *done***",
+			"Service **api** v1.2 ok ~~old~~ [log](https://x.io/a_(b))",
+			"┃ note",
+			"make all",
+		}},
+		{WeCom, []string{
+			"# Deploy done",
+			"Service **api** v1.2 `ok` old [log](https://x.io/a_(b))",
+		}},
+		{Slack, []string{
+			"*Deploy _done_*",
+			"Service *api* v1.2 `ok` ~old~ <https://x.io/a_(b)|log>",
+			"• a\n    ◦ b\n• c",
+			"```\nmake all\n```",
+			"• *Host*: db1; *CPU*: 80%",
+		}},
+		{Telegram, []string{
+			"*Deploy _done_*",
+			`Service *api* v1\.2 ` + "`ok`" + ` ~old~ [log](https://x.io/a_(b\))`,
+			">note",
+			"```sh\nmake all\n```",
+		}},
+		{HTML, []string{
+			"<h1>Deploy <em>done</em></h1>",
+			`<p>Service <strong>api</strong> v1.2 <code>ok</code> <del>old</del> <a href="https://x.io/a_(b)">log</a></p>`,
+			"<li>a<ul>\n<li>b</li>\n</ul>\n</li>",
+			`<pre><code class="language-sh">make all</code></pre>`,
+			"<tr><th>Host</th><th>CPU</th></tr>",
+		}},
+		{Plain, []string{
+			"Deploy done\n\nService api v1.2 ok old log (https://x.io/a_(b))",
+		}},
+	}
+	for _, tt := range tests {
+		got := Convert(doc, tt.dialect)
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: output does not contain %q:\n%s", tt.dialect, want, got)
+			}
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	src := `snake_case a * b a < b & c \*literal\* 1. not a list`
+	tests := map[Dialect]string{
+		DingTalk: `snake\_case a \* b a < b & c \*literal\* 1. not a list`,
+		Lark:     `snake&#95;case a &#42; b a &lt; b & c &#42;literal&#42; 1. not a list`,
+		Slack:    `snake_case a * b a &lt; b &amp; c *literal* 1. not a list`,
+		Telegram: `snake\_case a \* b a < b & c \*literal\* 1\. not a list`,
+		HTML:     `<p>snake_case a * b a &lt; b &amp; c *literal* 1. not a list</p>`,
+	}
+	for d, want := range tests {
+		if got := Convert(src, d); got != want {
+			t.Errorf("%s:\n got %q\nwant %q", d, got, want)
+		}
+	}
+
+	// 转义后的文本在行首不能被识别为块标记
+	if got := Convert(`\# not heading`+"\n"+`3\. not list`, DingTalk); got != "\\# not heading\n3\\. not list" {
+		t.Errorf("line start escape: got %q", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	n := Parse("Title\n===\n\n    code\n\n3. a\n4. b\n\n***\n\n__strong__ and *em **nested***")
+	kinds := []Kind{Heading, CodeBlock, List, Rule, Paragraph}
+	if len(n.Children) != len(kinds) {
+		t.Fatalf("got %d blocks, want %d", len(n.Children), len(kinds))
+	}
+	for i, k := range kinds {
+		if n.Children[i].Kind != k {
+			t.Errorf("block %d kind = %d, want %d", i, n.Children[i].Kind, k)
+		}
+	}
+	if list := n.Children[2]; !list.Ordered || list.Start != 3 || len(list.Children) != 2 {
+		t.Errorf("list = %+v", list)
+	}
+	if got := Convert("__strong__ and *em **nested***", HTML); got != "<p><strong>strong</strong> and <em>em <strong>nested</strong></em></p>" {
+		t.Errorf("emphasis: got %q", got)
+	}
+	if got := Convert("[x](javascript:alert(1))", HTML); got != `<p><a href="#">x</a></p>` {
+		t.Errorf("unsafe link: got %q", got)
+	}
+}
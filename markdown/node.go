@@ -0,0 +1,54 @@
+package markdown
+
+// Kind 节点类型
+type Kind int
+
+const (
+	Document Kind = iota
+	Paragraph
+	Heading   // Level 为 1-6
+	CodeBlock // Text 为代码，Info 为语言
+	Quote
+	List     // Ordered、Start 描述有序列表
+	ListItem // 子节点为块级节点
+	Rule
+	Table    // 子节点为 TableRow，第一行是表头
+	TableRow // 子节点为 TableCell
+	TableCell
+	Text // Text 为原始文本
+	Emphasis
+	Strong
+	Strike
+	Code // Text 为代码
+	Link // URL 为地址，子节点为链接文字
+	Image
+	SoftBreak
+	HardBreak
+)
+
+// Node 语法树节点，块级节点的子节点为块级节点或行内节点，行内节点的子节点为行内节点
+type Node struct {
+	Kind     Kind
+	Children []*Node
+	Text     string
+	URL      string
+	Info     string
+	Level    int
+	Ordered  bool
+	Start    int
+}
+
+// PlainText 节点去除格式后的文本
+func (n *Node) PlainText() string {
+	switch n.Kind {
+	case Text, Code:
+		return n.Text
+	case SoftBreak, HardBreak:
+		return "\n"
+	}
+	var s string
+	for _, c := range n.Children {
+		s += c.PlainText()
+	}
+	return s
+}
@@ -0,0 +1,239 @@
+package markdown
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	headingRegexp = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	fenceRegexp   = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \t]*([^ \t`]*)")
+	ruleRegexp    = regexp.MustCompile(`^ {0,3}(?:(?:-[ \t]*){3,}|(?:\*[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	quoteRegexp   = regexp.MustCompile(`^ {0,3}> ?`)
+	listRegexp    = regexp.MustCompile(`^( {0,3})([-*+]|\d{1,9}[.)])(?:([ \t]+)|$)`)
+	setextRegexp  = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	delimRegexp   = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+)
+
+// Parse 解析 CommonMark 文档，支持标题、段落、引用、列表、代码块、分隔线，
+// 以及 GFM 的表格和删除线；HTML 标签按普通文本处理
+func Parse(src string) *Node {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\t", "    ")
+	return &Node{Kind: Document, Children: parseBlocks(strings.Split(src, "\n"))}
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// startsBlock 是否是可以打断段落的块的开始
+func startsBlock(line string) bool {
+	return headingRegexp.MatchString(line) || fenceRegexp.MatchString(line) ||
+		quoteRegexp.MatchString(line) || ruleRegexp.MatchString(line) || listRegexp.MatchString(line)
+}
+
+func parseBlocks(lines []string) []*Node {
+	var nodes []*Node
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+		case fenceRegexp.MatchString(line):
+			var n *Node
+			n, i = parseFence(lines, i)
+			nodes = append(nodes, n)
+		case headingRegexp.MatchString(line):
+			m := headingRegexp.FindStringSubmatch(line)
+			nodes = append(nodes, &Node{Kind: Heading, Level: len(m[1]), Children: parseInline(m[2])})
+			i++
+		case ruleRegexp.MatchString(line):
+			nodes = append(nodes, &Node{Kind: Rule})
+			i++
+		case quoteRegexp.MatchString(line):
+			var quoted []string
+			for ; i < len(lines) && !isBlank(lines[i]); i++ {
+				if loc := quoteRegexp.FindStringIndex(lines[i]); loc != nil {
+					quoted = append(quoted, lines[i][loc[1]:])
+				} else if len(quoted) > 0 && !startsBlock(lines[i]) {
+					quoted = append(quoted, lines[i]) // 惰性延续行
+				} else {
+					break
+				}
+			}
+			nodes = append(nodes, &Node{Kind: Quote, Children: parseBlocks(quoted)})
+		case listRegexp.MatchString(line):
+			var n *Node
+			n, i = parseList(lines, i)
+			nodes = append(nodes, n)
+		case indentOf(line) >= 4:
+			var code []string
+			for ; i < len(lines) && (isBlank(lines[i]) || indentOf(lines[i]) >= 4); i++ {
+				if len(lines[i]) >= 4 {
+					code = append(code, lines[i][4:])
+				} else {
+					code = append(code, "")
+				}
+			}
+			for len(code) > 0 && isBlank(code[len(code)-1]) {
+				code = code[:len(code)-1]
+			}
+			nodes = append(nodes, &Node{Kind: CodeBlock, Text: strings.Join(code, "\n")})
+		case i+1 < len(lines) && strings.Contains(line, "|") && delimRegexp.MatchString(lines[i+1]):
+			var n *Node
+			n, i = parseTable(lines, i)
+			nodes = append(nodes, n)
+		default:
+			var n *Node
+			n, i = parseParagraph(lines, i)
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+func parseFence(lines []string, i int) (*Node, int) {
+	m := fenceRegexp.FindStringSubmatch(lines[i])
+	indent, fence := len(m[1]), m[2]
+	n := &Node{Kind: CodeBlock, Info: m[3]}
+	var code []string
+	for i++; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" && indentOf(lines[i]) < 4 {
+			i++
+			break
+		}
+		line := lines[i]
+		if strip := min(indent, indentOf(line)); strip > 0 {
+			line = line[strip:]
+		}
+		code = append(code, line)
+	}
+	n.Text = strings.Join(code, "\n")
+	return n, i
+}
+
+func parseParagraph(lines []string, i int) (*Node, int) {
+	var text []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if isBlank(line) {
+			break
+		}
+		if len(text) > 0 {
+			if m := setextRegexp.FindStringSubmatch(line); m != nil {
+				level := 1
+				if m[1][0] == '-' {
+					level = 2
+				}
+				return &Node{Kind: Heading, Level: level, Children: parseInline(strings.Join(text, "\n"))}, i + 1
+			}
+			if startsBlock(line) {
+				break
+			}
+		}
+		text = append(text, strings.TrimLeft(line, " "))
+	}
+	return &Node{Kind: Paragraph, Children: parseInline(strings.Join(text, "\n"))}, i
+}
+
+// parseList 解析连续的同类列表项，嵌套内容按列表项内容的缩进递归解析
+func parseList(lines []string, i int) (*Node, int) {
+	first := listRegexp.FindStringSubmatch(lines[i])
+	ordered := first[2][0] >= '0' && first[2][0] <= '9'
+	list := &Node{Kind: List, Ordered: ordered}
+	if ordered {
+		list.Start, _ = strconv.Atoi(first[2][:len(first[2])-1])
+	}
+	delim := first[2][len(first[2])-1]
+	for i < len(lines) {
+		m := listRegexp.FindStringSubmatch(lines[i])
+		if m == nil || ruleRegexp.MatchString(lines[i]) {
+			break
+		}
+		if (m[2][0] >= '0' && m[2][0] <= '9') != ordered || m[2][len(m[2])-1] != delim {
+			break
+		}
+		// 列表项内容的缩进，标记后为空或超过 4 个空格（缩进代码块）时按标记后一个空格计算
+		width := len(m[1]) + len(m[2]) + len(m[3])
+		if m[3] == "" || len(m[3]) > 4 {
+			width = len(m[1]) + len(m[2]) + 1
+		}
+		item := []string{lines[i][min(width, len(lines[i])):]}
+		blank := false
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if isBlank(line) {
+				blank = true
+				item = append(item, "")
+				continue
+			}
+			if indentOf(line) >= width {
+				item = append(item, line[width:])
+				blank = false
+				continue
+			}
+			if blank || startsBlock(line) {
+				break
+			}
+			item = append(item, strings.TrimLeft(line, " ")) // 惰性延续行
+		}
+		list.Children = append(list.Children, &Node{Kind: ListItem, Children: parseBlocks(item)})
+		if blank && (i >= len(lines) || !listRegexp.MatchString(lines[i])) {
+			break
+		}
+	}
+	return list, i
+}
+
+func parseTable(lines []string, i int) (*Node, int) {
+	table := &Node{Kind: Table}
+	header := splitRow(lines[i])
+	table.Children = append(table.Children, tableRow(header, len(header)))
+	for i += 2; i < len(lines) && !isBlank(lines[i]) && strings.Contains(lines[i], "|") && !startsBlock(lines[i]); i++ {
+		table.Children = append(table.Children, tableRow(splitRow(lines[i]), len(header)))
+	}
+	return table, i
+}
+
+func tableRow(cells []string, width int) *Node {
+	row := &Node{Kind: TableRow}
+	for c := 0; c < width; c++ {
+		cell := &Node{Kind: TableCell}
+		if c < len(cells) {
+			cell.Children = parseInline(cells[c])
+		}
+		row.Children = append(row.Children, cell)
+	}
+	return row
+}
+
+// splitRow 按未转义的 | 拆分表格行
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	var cell strings.Builder
+	for j := 0; j < len(line); j++ {
+		switch {
+		case line[j] == '\\' && j+1 < len(line) && line[j+1] == '|':
+			cell.WriteByte('|')
+			j++
+		case line[j] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[j])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
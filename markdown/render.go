@@ -0,0 +1,339 @@
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Dialect 目标渠道的 Markdown 方言
+type Dialect string
+
+const (
+	DingTalk Dialect = "dingtalk" // 钉钉 markdown 消息
+	Lark     Dialect = "lark"     // 飞书卡片 lark_md
+	WeCom    Dialect = "wecom"    // 企业微信 markdown 消息
+	Slack    Dialect = "slack"    // Slack mrkdwn
+	Telegram Dialect = "telegram" // Telegram MarkdownV2
+	HTML     Dialect = "html"     // 邮件 HTML
+	Plain    Dialect = "plain"    // 去除格式的纯文本
+)
+
+// syntax 方言支持的语法，标记为空表示不支持，渲染时只保留文字
+type syntax struct {
+	bold, italic, strike string
+	code                 bool   // 行内代码
+	codeBlock            bool   // ``` 代码块
+	codePrefix           string // 不支持代码块时每行代码的前缀
+	codeLang             bool   // 代码块是否可以带语言
+	heading              bool   // # 标题，不支持时输出为粗体
+	quote                string
+	image                bool // 不支持时输出为链接
+	rule                 string
+	bullet, subBullet    string
+	indent               string // 嵌套列表每层的缩进，各渠道都不支持真正的嵌套列表
+	link                 func(text, url string) string
+	escape               func(string) string
+	escapeCode           func(string) string
+	escapeLine           bool // 转义行首的 #、>、- 等块标记
+}
+
+var syntaxes = map[Dialect]*syntax{
+	DingTalk: {
+		bold: "**", italic: "*", heading: true, quote: "> ", codePrefix: "> ", image: true, rule: "---",
+		bullet: "- ", subBullet: "◦ ", indent: "　",
+		link: mdLink, escape: mdEscape, escapeCode: identity, escapeLine: true,
+	},
+	Lark: {
+		bold: "**", italic: "*", strike: "~~", quote: "┃ ", rule: "———",
+		bullet: "- ", subBullet: "◦ ", indent: "　",
+		link: mdLink, escape: larkEscape, escapeCode: larkEscape,
+	},
+	WeCom: {
+		bold: "**", code: true, heading: true, quote: "> ", codePrefix: "> ", rule: "———",
+		bullet: "- ", subBullet: "◦ ", indent: "　",
+		link: mdLink, escape: mdEscape, escapeCode: identity, escapeLine: true,
+	},
+	Slack: {
+		bold: "*", italic: "_", strike: "~", code: true, codeBlock: true, quote: "> ", rule: "———",
+		bullet: "• ", subBullet: "◦ ", indent: "    ",
+		link: slackLink, escape: slackEscape, escapeCode: slackEscape,
+	},
+	Telegram: {
+		bold: "*", italic: "_", strike: "~", code: true, codeBlock: true, codeLang: true, quote: ">", rule: "———",
+		bullet: "• ", subBullet: "◦ ", indent: "    ",
+		link: telegramLink, escape: telegramEscape, escapeCode: telegramCodeEscape,
+	},
+	Plain: {
+		quote: "> ", rule: "———", bullet: "- ", subBullet: "◦ ", indent: "  ",
+		link: plainLink, escape: identity, escapeCode: identity,
+	},
+}
+
+// Convert 将 CommonMark 文档转换为目标渠道的格式，不认识的方言原样返回
+func Convert(src string, d Dialect) string {
+	if d != HTML && syntaxes[d] == nil {
+		return src
+	}
+	return Render(Parse(src), d)
+}
+
+// Render 将语法树渲染为目标渠道的格式
+func Render(doc *Node, d Dialect) string {
+	if d == HTML {
+		return renderHTML(doc)
+	}
+	s := syntaxes[d]
+	if s == nil {
+		s = syntaxes[Plain]
+	}
+	r := &renderer{s: s}
+	return r.blocks(doc.Children, 0, "\n\n")
+}
+
+// Escape 转义纯文本中的格式字符，使其在目标渠道中按原样显示，不认识的方言原样返回
+func Escape(text string, d Dialect) string {
+	if d == HTML {
+		return html.EscapeString(text)
+	}
+	s := syntaxes[d]
+	if s == nil {
+		return text
+	}
+	r := &renderer{s: s}
+	return r.lines(s.escape(text))
+}
+
+type renderer struct {
+	s *syntax
+}
+
+func (r *renderer) blocks(nodes []*Node, depth int, sep string) string {
+	var out []string
+	for _, n := range nodes {
+		if b := r.block(n, depth); b != "" {
+			out = append(out, b)
+		}
+	}
+	return strings.Join(out, sep)
+}
+
+func (r *renderer) block(n *Node, depth int) string {
+	s := r.s
+	switch n.Kind {
+	case Paragraph:
+		return r.lines(r.inlines(n.Children))
+	case Heading:
+		text := r.inlines(n.Children)
+		if s.heading {
+			return strings.Repeat("#", n.Level) + " " + text
+		}
+		return wrap(s.bold, text)
+	case CodeBlock:
+		if s.codeBlock {
+			info := ""
+			if s.codeLang {
+				info = n.Info
+			}
+			return "```" + info + "\n" + s.escapeCode(n.Text) + "\n```"
+		}
+		return prefixLines(r.lines(s.escape(n.Text)), s.codePrefix)
+	case Quote:
+		return prefixLines(r.blocks(n.Children, 0, "\n"), s.quote)
+	case List:
+		return r.list(n, depth)
+	case Rule:
+		return s.rule
+	case Table:
+		return r.table(n)
+	}
+	return r.inlines(n.Children)
+}
+
+func (r *renderer) list(n *Node, depth int) string {
+	s := r.s
+	indent := strings.Repeat(s.indent, depth)
+	var out []string
+	for i, item := range n.Children {
+		marker := s.bullet
+		if depth > 0 {
+			marker = s.subBullet
+		}
+		if n.Ordered {
+			marker = s.escape(fmt.Sprintf("%d. ", n.Start+i))
+		}
+		first := true
+		for _, child := range item.Children {
+			if child.Kind == List {
+				out = append(out, r.list(child, depth+1))
+				continue
+			}
+			for _, line := range strings.Split(r.block(child, depth+1), "\n") {
+				if first {
+					out = append(out, indent+marker+line)
+					first = false
+				} else {
+					out = append(out, indent+s.indent+line)
+				}
+			}
+		}
+		if first {
+			out = append(out, indent+strings.TrimRight(marker, " "))
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// table 渠道不支持表格，每行输出为一个列表项，单元格按“表头: 值”排列
+func (r *renderer) table(n *Node) string {
+	if len(n.Children) == 0 {
+		return ""
+	}
+	header := n.Children[0].Children
+	if len(n.Children) == 1 {
+		var cells []string
+		for _, c := range header {
+			cells = append(cells, wrap(r.s.bold, r.inlines(c.Children)))
+		}
+		return strings.Join(cells, " | ")
+	}
+	var out []string
+	for _, row := range n.Children[1:] {
+		var cells []string
+		for i, c := range row.Children {
+			value := r.inlines(c.Children)
+			if value == "" {
+				continue
+			}
+			if i < len(header) && len(header[i].Children) > 0 {
+				value = wrap(r.s.bold, r.inlines(header[i].Children)) + r.s.escape(": ") + value
+			}
+			cells = append(cells, value)
+		}
+		out = append(out, r.s.bullet+strings.Join(cells, r.s.escape("; ")))
+	}
+	return strings.Join(out, "\n")
+}
+
+func (r *renderer) inlines(nodes []*Node) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		b.WriteString(r.inline(n))
+	}
+	return b.String()
+}
+
+func (r *renderer) inline(n *Node) string {
+	s := r.s
+	switch n.Kind {
+	case Text:
+		return s.escape(n.Text)
+	case Emphasis:
+		return wrap(s.italic, r.inlines(n.Children))
+	case Strong:
+		return wrap(s.bold, r.inlines(n.Children))
+	case Strike:
+		return wrap(s.strike, r.inlines(n.Children))
+	case Code:
+		if s.code {
+			return "`" + s.escapeCode(n.Text) + "`"
+		}
+		return s.escape(n.Text)
+	case Link:
+		return s.link(r.inlines(n.Children), n.URL)
+	case Image:
+		if s.image {
+			return "![" + s.escape(n.PlainText()) + "](" + n.URL + ")"
+		}
+		alt := n.PlainText()
+		if alt == "" {
+			alt = n.URL
+		}
+		return s.link(s.escape(alt), n.URL)
+	case SoftBreak, HardBreak:
+		return "\n"
+	}
+	return r.inlines(n.Children)
+}
+
+// lines 转义段落中每行开头会被识别为块标记的字符，如 #、>、- 和 "1."
+func (r *renderer) lines(text string) string {
+	if !r.s.escapeLine {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if !startsBlock(line) && !strings.HasPrefix(strings.TrimLeft(line, " "), "#") {
+			continue
+		}
+		j := indentOf(line)
+		for j < len(line) && line[j] >= '0' && line[j] <= '9' {
+			j++
+		}
+		lines[i] = line[:j] + `\` + line[j:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func wrap(marker, text string) string {
+	if marker == "" || strings.TrimSpace(text) == "" {
+		return text
+	}
+	return marker + text + marker
+}
+
+func prefixLines(text, prefix string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+func identity(s string) string { return s }
+
+var (
+	mdReplacer = strings.NewReplacer(`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`)
+	// 飞书 lark_md 使用 HTML 实体转义特殊字符
+	larkReplacer = strings.NewReplacer("<", "&lt;", ">", "&gt;", "*", "&#42;", "_", "&#95;",
+		"~", "&#126;", "`", "&#96;", "[", "&#91;", "]", "&#93;")
+	slackReplacer    = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	telegramChars    = "_*[]()~`>#+-=|{}.!\\"
+	telegramReplacer = func() *strings.Replacer {
+		var pairs []string
+		for _, c := range telegramChars {
+			pairs = append(pairs, string(c), `\`+string(c))
+		}
+		return strings.NewReplacer(pairs...)
+	}()
+	telegramCodeReplacer = strings.NewReplacer(`\`, `\\`, "`", "\\`")
+	telegramURLReplacer  = strings.NewReplacer(`\`, `\\`, ")", `\)`)
+)
+
+func mdEscape(s string) string           { return mdReplacer.Replace(s) }
+func larkEscape(s string) string         { return larkReplacer.Replace(s) }
+func slackEscape(s string) string        { return slackReplacer.Replace(s) }
+func telegramEscape(s string) string     { return telegramReplacer.Replace(s) }
+func telegramCodeEscape(s string) string { return telegramCodeReplacer.Replace(s) }
+
+func mdLink(text, url string) string {
+	return "[" + text + "](" + url + ")"
+}
+
+func slackLink(text, url string) string {
+	if text == "" || text == slackEscape(url) {
+		return "<" + url + ">"
+	}
+	return "<" + url + "|" + strings.ReplaceAll(text, "|", "¦") + ">"
+}
+
+func telegramLink(text, url string) string {
+	return "[" + text + "](" + telegramURLReplacer.Replace(url) + ")"
+}
+
+func plainLink(text, url string) string {
+	if text == "" || text == url || text == strings.TrimPrefix(url, "mailto:") {
+		return url
+	}
+	return text + " (" + url + ")"
+}
@@ -0,0 +1,144 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/v-mars/notify/result"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Priority 消息优先级
+type Priority string
+
+const (
+	PriorityLow    Priority = "low"
+	PriorityNormal Priority = "normal"
+	PriorityHigh   Priority = "high"
+	PriorityUrgent Priority = "urgent"
+)
+
+// Attachment 消息附件，Path、Data、URL 三者任选其一
+type Attachment struct {
+	Name        string `json:"name"`                   // 附件文件名
+	ContentType string `json:"content_type,omitempty"` // MIME 类型，为空时由渠道自行推断
+	Path        string `json:"path,omitempty"`         // 本地文件路径
+	Data        []byte `json:"data,omitempty"`         // 文件内容
+	URL         string `json:"url,omitempty"`          // 远程地址
+}
+
+// Link 消息中的链接，支持按钮的渠道会渲染为按钮
+type Link struct {
+	Text  string `json:"text"`
+	URL   string `json:"url"`
+	Style string `json:"style,omitempty"` // 按钮样式：default, primary, danger，不支持的渠道忽略
+}
+
+// Mentions 消息中需要 @ 的对象
+type Mentions struct {
+	All   bool     `json:"all,omitempty"`   // @所有人
+	Users []string `json:"users,omitempty"` // 渠道内的用户标识，如钉钉手机号、飞书 open_id
+}
+
+// Message 结构化的消息，各渠道按自身支持的能力选择最丰富的表示形式，
+// 缺少对应的正文时依次回退到其他正文
+type Message struct {
+	Title       string            `json:"title"`
+	Text        string            `json:"text,omitempty"`     // 纯文本正文
+	Markdown    string            `json:"markdown,omitempty"` // Markdown 正文
+	HTML        string            `json:"html,omitempty"`     // HTML 正文，主要用于邮件
+	Attachments []Attachment      `json:"attachments,omitempty"`
+	Links       []Link            `json:"links,omitempty"`
+	Mentions    Mentions          `json:"mentions,omitempty"`
+	Priority    Priority          `json:"priority,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Metadata    map[string]any    `json:"metadata,omitempty"`
+	// MoreURL 完整内容的地址，正文超过渠道长度限制被截断时附加为“查看更多”链接
+	MoreURL string `json:"more_url,omitempty"`
+}
+
+// NewMessage 创建纯文本消息
+func NewMessage(title, text string) *Message {
+	return &Message{Title: title, Text: text}
+}
+
+var htmlTagRegexp = regexp.MustCompile(`(?s)<[^>]*>`)
+
+// MetadataValue 返回 Metadata 中 key 对应的 T 类型的值，值为 *T 或 T 时直接返回；
+// 经过 JSON 往返（如 outbox 的 FileStore 持久化）后值为 map[string]any 或 json.RawMessage，重新解码为 T。
+// 没有 key 时返回 nil，无法解码时返回永久错误
+func MetadataValue[T any](m *Message, key string) (*T, error) {
+	v, ok := m.Metadata[key]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	switch v := v.(type) {
+	case *T:
+		return v, nil
+	case T:
+		return &v, nil
+	}
+	b, ok := v.(json.RawMessage)
+	if !ok {
+		var err error
+		if b, err = json.Marshal(v); err != nil {
+			return nil, Permanent(fmt.Errorf("metadata %s: %w", key, err))
+		}
+	}
+	t := new(T)
+	if err := json.Unmarshal(b, t); err != nil {
+		return nil, Permanent(fmt.Errorf("metadata %s: %w", key, err))
+	}
+	return t, nil
+}
+
+// TextBody 纯文本正文，依次回退到 Markdown 和去除标签后的 HTML
+func (m *Message) TextBody() string {
+	switch {
+	case m.Text != "":
+		return m.Text
+	case m.Markdown != "":
+		return m.Markdown
+	case m.HTML != "":
+		return strings.TrimSpace(html.UnescapeString(htmlTagRegexp.ReplaceAllString(m.HTML, "")))
+	}
+	return ""
+}
+
+// MarkdownBody Markdown 正文，未设置时回退到纯文本
+func (m *Message) MarkdownBody() string {
+	if m.Markdown != "" {
+		return m.Markdown
+	}
+	return m.TextBody()
+}
+
+// HTMLBody HTML 正文，未设置时将纯文本转义后按行换行
+func (m *Message) HTMLBody() string {
+	if m.HTML != "" {
+		return m.HTML
+	}
+	return strings.ReplaceAll(html.EscapeString(m.TextBody()), "\n", "<br>\n")
+}
+
+// IsUrgent 是否为高优先级消息
+func (m *Message) IsUrgent() bool {
+	return m.Priority == PriorityHigh || m.Priority == PriorityUrgent
+}
+
+// MessageSender 支持结构化消息的发送器
+type MessageSender interface {
+	Sender
+	SendMessage(ctx context.Context, to []string, msg *Message) (*result.SendResult, error)
+}
+
+// SendMessage 通过任意 Sender 发送结构化消息
+// 不支持结构化消息的发送器收到标题和 TextBody
+func SendMessage(ctx context.Context, s Sender, to []string, msg *Message) (*result.SendResult, error) {
+	if ms, ok := s.(MessageSender); ok {
+		return ms.SendMessage(ctx, to, msg)
+	}
+	return SendContext(ctx, s, to, msg.Title, msg.TextBody())
+}
@@ -0,0 +1,82 @@
+package notify
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMessageBodies(t *testing.T) {
+	tests := []struct {
+		name         string
+		msg          Message
+		wantText     string
+		wantMarkdown string
+		wantHTML     string
+	}{
+		{
+			name:         "text only",
+			msg:          Message{Text: "a < b\nc"},
+			wantText:     "a < b\nc",
+			wantMarkdown: "a < b\nc",
+			wantHTML:     "a &lt; b<br>\nc",
+		},
+		{
+			name:         "markdown only",
+			msg:          Message{Markdown: "**bold**"},
+			wantText:     "**bold**",
+			wantMarkdown: "**bold**",
+			wantHTML:     "**bold**",
+		},
+		{
+			name:         "html only",
+			msg:          Message{HTML: "<p>Tom &amp; Jerry</p>"},
+			wantText:     "Tom & Jerry",
+			wantMarkdown: "Tom & Jerry",
+			wantHTML:     "<p>Tom &amp; Jerry</p>",
+		},
+		{
+			name:         "all bodies",
+			msg:          Message{Text: "t", Markdown: "m", HTML: "h"},
+			wantText:     "t",
+			wantMarkdown: "m",
+			wantHTML:     "h",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.msg.TextBody(); got != tt.wantText {
+				t.Errorf("TextBody() = %q, want %q", got, tt.wantText)
+			}
+			if got := tt.msg.MarkdownBody(); got != tt.wantMarkdown {
+				t.Errorf("MarkdownBody() = %q, want %q", got, tt.wantMarkdown)
+			}
+			if got := tt.msg.HTMLBody(); got != tt.wantHTML {
+				t.Errorf("HTMLBody() = %q, want %q", got, tt.wantHTML)
+			}
+		})
+	}
+}
+
+func TestMetadataValue(t *testing.T) {
+	type payload struct {
+		Kind string `json:"kind"`
+	}
+	msg := &Message{Metadata: map[string]any{"p": &payload{Kind: "card"}}}
+	b, _ := json.Marshal(msg)
+	var restored Message
+	if err := json.Unmarshal(b, &restored); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*Message{msg, &restored, {Metadata: map[string]any{"p": json.RawMessage(`{"kind":"card"}`)}}} {
+		p, err := MetadataValue[payload](m, "p")
+		if err != nil || p == nil || p.Kind != "card" {
+			t.Errorf("MetadataValue(%v) = %+v, %v", m.Metadata["p"], p, err)
+		}
+	}
+	if p, err := MetadataValue[payload](&Message{}, "p"); p != nil || err != nil {
+		t.Errorf("missing key = %+v, %v", p, err)
+	}
+	if _, err := MetadataValue[payload](&Message{Metadata: map[string]any{"p": "card"}}, "p"); err == nil || IsRetryable(err) {
+		t.Errorf("invalid value err = %v", err)
+	}
+}
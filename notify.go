@@ -2,6 +2,7 @@ package notify
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/v-mars/notify/result"
 	"io"
@@ -14,6 +15,52 @@ type Sender interface {
 	ChannelType() string // 返回渠道类型（如"email"、"sms"）
 }
 
+// ContextSender 支持 context 的发送器，ctx 的超时和取消会传递到渠道的每一次外部调用
+type ContextSender interface {
+	Sender
+	SendContext(ctx context.Context, to []string, title string, content string) (*result.SendResult, error)
+}
+
+// WithContext 将 Sender 包装为 ContextSender
+// 已实现 ContextSender 的发送器原样返回；旧的发送器在 ctx 结束时立即返回 ctx.Err()，
+// 但底层的 Send 调用会在后台继续执行直到其自身返回
+func WithContext(s Sender) ContextSender {
+	if cs, ok := s.(ContextSender); ok {
+		return cs
+	}
+	return &contextAdapter{Sender: s}
+}
+
+// SendContext 使用 ctx 通过任意 Sender 发送消息
+func SendContext(ctx context.Context, s Sender, to []string, title, content string) (*result.SendResult, error) {
+	return WithContext(s).SendContext(ctx, to, title, content)
+}
+
+type contextAdapter struct {
+	Sender
+}
+
+func (a *contextAdapter) SendContext(ctx context.Context, to []string, title, content string) (*result.SendResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	type sendReturn struct {
+		r   *result.SendResult
+		err error
+	}
+	done := make(chan sendReturn, 1)
+	go func() {
+		r, err := a.Sender.Send(to, title, content)
+		done <- sendReturn{r: r, err: err}
+	}()
+	select {
+	case ret := <-done:
+		return ret.r, ret.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 // alarm notify
 // mail
 // chat
@@ -25,11 +72,17 @@ type Sender interface {
 
 // JSONPost Post req json data to url
 func JSONPost(method, url string, data interface{}, client *http.Client, headers map[string]string) ([]byte, error) {
+	return JSONPostContext(context.Background(), method, url, data, client, headers)
+}
+
+// JSONPostContext 与 JSONPost 相同，请求绑定 ctx，ctx 取消或超时后请求立即中断
+// 接口返回 4xx/5xx 时返回响应内容和 *HTTPError
+func JSONPostContext(ctx context.Context, method, url string, data interface{}, client *http.Client, headers map[string]string) ([]byte, error) {
 	jsonBody, err := json.Marshal(data)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest(method, url, bytes.NewReader(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(jsonBody))
 	if err != nil {
 		return nil, err
 	}
@@ -45,10 +98,14 @@ func JSONPost(method, url string, data interface{}, client *http.Client, headers
 		//log.Error("client.Do",err)
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return body, &HTTPError{StatusCode: resp.StatusCode, Body: body}
+	}
 	return body, err
 }
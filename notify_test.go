@@ -1,17 +1,62 @@
 package notify
 
 import (
+	"context"
+	"errors"
+	"github.com/v-mars/notify/result"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func Test_JSONPost(t *testing.T) {
-	_, err := JSONPost(http.MethodPost, "http://webhook.test", nil, http.DefaultClient, nil)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"errcode":0}`))
+	}))
+	defer srv.Close()
+	_, err := JSONPost(http.MethodPost, srv.URL, nil, http.DefaultClient, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
+func Test_JSONPostContext(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := JSONPostContext(ctx, http.MethodPost, srv.URL, nil, http.DefaultClient, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+type blockingSender struct {
+	release chan struct{}
+}
+
+func (b *blockingSender) Send(to []string, title string, content string) (*result.SendResult, error) {
+	<-b.release
+	return &result.SendResult{ChannelType: "blocking", Success: true}, nil
+}
+
+func (b *blockingSender) ChannelType() string { return "blocking" }
+
+func TestWithContext(t *testing.T) {
+	s := &blockingSender{release: make(chan struct{})}
+	defer close(s.release)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := SendContext(ctx, s, []string{"a"}, "title", "content"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want %v", err, context.Canceled)
+	}
+}
+
 //// 消息发送器接口（所有渠道实现此接口）
 //type MessageSender interface {
 //	Send(ctx context.Context, message *Message) (*SendResult, error)
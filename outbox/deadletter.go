@@ -0,0 +1,191 @@
+package outbox
+
+import (
+	"errors"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/result"
+	"github.com/v-mars/notify/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DeadLetter 永久失败的消息，保存重新投递所需的全部信息
+type DeadLetter struct {
+	ID          string            `json:"id"`
+	RecordID    string            `json:"record_id,omitempty"` // 来自发件箱时对应的记录 ID
+	Channel     string            `json:"channel"`             // 渠道实例名称
+	ChannelType string            `json:"channel_type"`        // 渠道类型
+	Recipients  types.NotifyToIds `json:"recipients"`          // 原始接收人，改投其他渠道时据此解析接收人
+	To          []string          `json:"to"`                  // 该渠道的接收人标识
+	Message     *notify.Message   `json:"message"`
+	Error       string            `json:"error"`    // 最后一次失败原因
+	Attempts    []result.Attempt  `json:"attempts"` // 全部发送尝试，包括重新投递
+	Replays     int               `json:"replays"`  // 重新投递的次数
+	FailedAt    time.Time         `json:"failed_at"`
+}
+
+// Filter 死信查询条件，零值字段不参与过滤
+type Filter struct {
+	Channel       string    // 渠道实例名称或渠道类型
+	ErrorContains string    // 失败原因包含的文本
+	Since         time.Time // 失败时间不早于
+	Until         time.Time // 失败时间早于
+	Limit         int       // 最多返回条数
+}
+
+// Match 死信是否满足查询条件
+func (f Filter) Match(d *DeadLetter) bool {
+	if f.Channel != "" && f.Channel != d.Channel && f.Channel != d.ChannelType {
+		return false
+	}
+	if f.ErrorContains != "" && !strings.Contains(d.Error, f.ErrorContains) {
+		return false
+	}
+	if !f.Since.IsZero() && d.FailedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !d.FailedAt.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// DeadLetterStore 死信存储
+type DeadLetterStore interface {
+	// SaveDeadLetter 新增或更新死信
+	SaveDeadLetter(d *DeadLetter) error
+	// ListDeadLetters 按失败时间顺序返回满足条件的死信
+	ListDeadLetters(f Filter) ([]*DeadLetter, error)
+	// GetDeadLetter 按 ID 查找死信，不存在时返回 ErrNotFound
+	GetDeadLetter(id string) (*DeadLetter, error)
+	// DeleteDeadLetter 删除死信
+	DeleteDeadLetter(id string) error
+}
+
+// NewDeadLetter 根据失败的发送结果创建死信
+func NewDeadLetter(recipients types.NotifyToIds, to []string, msg *notify.Message, r *result.SendResult) *DeadLetter {
+	d := &DeadLetter{
+		ID:          NewID(),
+		Channel:     r.Name(),
+		ChannelType: r.ChannelType,
+		Recipients:  recipients,
+		To:          to,
+		Message:     msg,
+		Attempts:    r.Attempts,
+		FailedAt:    time.Now(),
+	}
+	if r.Error != nil {
+		d.Error = *r.Error
+	}
+	return d
+}
+
+// deadLetters 将进入死信的发件箱记录拆分为每个失败渠道一条死信，尝试记录合并所有投递
+func deadLetters(r *Record) []*DeadLetter {
+	if len(r.Deliveries) == 0 {
+		return nil
+	}
+	last := r.Deliveries[len(r.Deliveries)-1]
+	var letters []*DeadLetter
+	for _, res := range last.Results {
+		if res.Success {
+			continue
+		}
+		d := NewDeadLetter(r.To, r.To.GetToTagList(res.Name()), r.Message, res)
+		d.RecordID = r.ID
+		d.Attempts = nil
+		for _, delivery := range r.Deliveries {
+			for _, prev := range delivery.Results {
+				if prev.Name() == res.Name() {
+					d.Attempts = append(d.Attempts, prev.Attempts...)
+				}
+			}
+		}
+		letters = append(letters, d)
+	}
+	if len(letters) == 0 && last.Error != "" {
+		for _, channel := range r.Channels {
+			letters = append(letters, &DeadLetter{
+				ID:          NewID(),
+				RecordID:    r.ID,
+				Channel:     channel,
+				ChannelType: types.ChannelTypeOf(channel),
+				Recipients:  r.To,
+				To:          r.To.GetToTagList(channel),
+				Message:     r.Message,
+				Error:       last.Error,
+				FailedAt:    last.Time,
+			})
+		}
+	}
+	return letters
+}
+
+const deadLetterDir = "deadletter"
+
+// SaveDeadLetter 写入死信
+func (s *FileStore) SaveDeadLetter(d *DeadLetter) error {
+	if err := validID(d.ID); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeJSON(filepath.Join(s.dir, deadLetterDir, d.ID+".json"), d)
+}
+
+// ListDeadLetters 返回满足条件的死信
+func (s *FileStore) ListDeadLetters(f Filter) ([]*DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var letters []*DeadLetter
+	err := readDir(filepath.Join(s.dir, deadLetterDir), func(path string) error {
+		d := &DeadLetter{}
+		if err := readJSON(path, d); err != nil {
+			return err
+		}
+		if f.Match(d) {
+			letters = append(letters, d)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(letters, func(i, j int) bool { return letters[i].FailedAt.Before(letters[j].FailedAt) })
+	if f.Limit > 0 && len(letters) > f.Limit {
+		letters = letters[:f.Limit]
+	}
+	return letters, nil
+}
+
+// GetDeadLetter 按 ID 查找死信
+func (s *FileStore) GetDeadLetter(id string) (*DeadLetter, error) {
+	if err := validID(id); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := &DeadLetter{}
+	err := readJSON(filepath.Join(s.dir, deadLetterDir, id+".json"), d)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return d, err
+}
+
+// DeleteDeadLetter 删除死信
+func (s *FileStore) DeleteDeadLetter(id string) error {
+	if err := validID(id); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.Remove(filepath.Join(s.dir, deadLetterDir, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
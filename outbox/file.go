@@ -0,0 +1,259 @@
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStore 基于本地目录的 Store，每条记录一个 json 文件，按状态存放在 pending、done、dead 子目录中，
+// 同时实现 DeadLetterStore，死信存放在 deadletter 子目录中
+// 写入先落盘到临时文件再原子重命名，进程崩溃不会留下不完整的记录；
+// 无法解析的文件重命名为 .corrupt 后跳过，不影响其他记录的投递
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+	// next 待投递记录文件的 NextAttempt，文件没有变化时 Due 不需要重新解析未到期的记录
+	next map[string]nextAttempt
+}
+
+// nextAttempt 缓存的记录文件的 NextAttempt，modTime 和 size 与文件不一致时缓存失效
+type nextAttempt struct {
+	modTime time.Time
+	size    int64
+	at      time.Time
+}
+
+var statuses = []Status{StatusPending, StatusDone, StatusDead}
+
+// errCorrupt 记录文件无法解析，如写入被截断或来自不兼容的旧版本
+var errCorrupt = errors.New("outbox: decode")
+
+// NewFileStore 打开或创建目录存储
+func NewFileStore(dir string) (*FileStore, error) {
+	for _, sub := range []string{string(StatusPending), string(StatusDone), string(StatusDead), deadLetterDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &FileStore{dir: dir, next: make(map[string]nextAttempt)}, nil
+}
+
+func (s *FileStore) path(status Status, id string) string {
+	return filepath.Join(s.dir, string(status), id+".json")
+}
+
+// Save 写入记录，并删除其他状态目录下的同 ID 记录
+func (s *FileStore) Save(r *Record) error {
+	if err := validID(r.ID); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := writeJSON(s.path(r.Status, r.ID), r); err != nil {
+		return err
+	}
+	delete(s.next, s.path(StatusPending, r.ID))
+	for _, status := range statuses {
+		if status == r.Status {
+			continue
+		}
+		if err := os.Remove(s.path(status, r.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Due 返回到期的待投递记录，文件没有变化且上次读取时未到期的记录不重新解析
+func (s *FileStore) Due(now time.Time, limit int) ([]*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next == nil {
+		s.next = make(map[string]nextAttempt)
+	}
+	var due []*Record
+	err := readDir(filepath.Join(s.dir, string(StatusPending)), func(path string) error {
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n, ok := s.next[path]; ok && n.modTime.Equal(info.ModTime()) && n.size == info.Size() && n.at.After(now) {
+			return nil
+		}
+		r, err := readRecord(path)
+		if err != nil {
+			return err
+		}
+		s.next[path] = nextAttempt{modTime: info.ModTime(), size: info.Size(), at: r.NextAttempt}
+		if r.NextAttempt.After(now) {
+			return nil
+		}
+		due = append(due, r)
+		if limit > 0 && len(due) >= limit {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return due, nil
+}
+
+// Get 按 ID 查找记录
+func (s *FileStore) Get(id string) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, status := range statuses {
+		r, err := readRecord(s.path(status, id))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		return r, err
+	}
+	return nil, ErrNotFound
+}
+
+// List 返回指定状态的记录，按 ID（即创建时间）排序
+func (s *FileStore) List(status Status) ([]*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []*Record
+	err := readDir(filepath.Join(s.dir, string(status)), func(path string) error {
+		r, err := readRecord(path)
+		if err != nil {
+			return err
+		}
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Delete 删除记录
+func (s *FileStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.next, s.path(StatusPending, id))
+	for _, status := range statuses {
+		if err := os.Remove(s.path(status, id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func readRecord(path string) (*Record, error) {
+	r := &Record{}
+	if err := readJSON(path, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func validID(id string) error {
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return fmt.Errorf("outbox: invalid id %q", id)
+	}
+	return nil
+}
+
+// readDir 按文件名顺序读取目录下的 json 文件，fn 返回 filepath.SkipAll 时停止读取；
+// 无法解析的文件重命名为 .corrupt 后跳过，避免一个损坏的文件导致整个目录无法读取
+func readDir(dir string, fn func(path string) error) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		err = fn(path)
+		switch {
+		case errors.Is(err, filepath.SkipAll):
+			return nil
+		case errors.Is(err, errCorrupt):
+			log.Println("outbox: skip corrupt file:", err)
+			if err = os.Rename(path, path+".corrupt"); err != nil {
+				log.Println("outbox: move corrupt file aside:", err)
+			}
+		case err != nil:
+			return err
+		}
+	}
+	return nil
+}
+
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w %s: %w", errCorrupt, path, err)
+	}
+	return nil
+}
+
+func writeJSON(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(path, data)
+}
+
+// writeFileSync 写入临时文件并 fsync 后重命名为目标文件，再 fsync 所在目录使重命名落盘
+func writeFileSync(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir fsync 目录，Windows 不支持对目录 fsync
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,331 @@
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/result"
+	"github.com/v-mars/notify/retry"
+	"github.com/v-mars/notify/types"
+	"log"
+	"sync"
+	"time"
+)
+
+// Status 记录状态
+type Status string
+
+const (
+	StatusPending Status = "pending" // 待投递
+	StatusDone    Status = "done"    // 已投递
+	StatusDead    Status = "dead"    // 投递失败，不再重试
+)
+
+// ErrNotFound 记录不存在
+var ErrNotFound = errors.New("outbox: record not found")
+
+// Record 持久化的待发送消息
+type Record struct {
+	ID          string                `json:"id"`
+	To          types.NotifyToIds     `json:"to"`
+	Message     *notify.Message       `json:"message"`
+	Channels    []string              `json:"channels"`           // 尚未送达的渠道，部分成功后只保留失败的渠道
+	Fallback    *types.FallbackPolicy `json:"fallback,omitempty"` // 按降级链发送
+	Status      Status                `json:"status"`
+	Deliveries  []Delivery            `json:"deliveries"`   // 每一次投递的结果
+	NextAttempt time.Time             `json:"next_attempt"` // 下一次投递时间
+	CreatedAt   time.Time             `json:"created_at"`
+	UpdatedAt   time.Time             `json:"updated_at"`
+}
+
+// Delivery 一次投递的结果
+type Delivery struct {
+	Time    time.Time          `json:"time"`
+	Results result.SendResults `json:"results"`
+	Error   string             `json:"error,omitempty"`
+}
+
+// Store 记录存储，实现需要保证 Save 返回后记录已持久化
+type Store interface {
+	// Save 新增或更新记录，按 Status 存放
+	Save(r *Record) error
+	// Due 返回 NextAttempt 不晚于 now 的待投递记录，按创建顺序排列，最多 limit 条
+	Due(now time.Time, limit int) ([]*Record, error)
+	// Get 按 ID 查找记录，不存在时返回 ErrNotFound
+	Get(id string) (*Record, error)
+	// List 返回指定状态的所有记录
+	List(status Status) ([]*Record, error)
+	// Delete 删除记录
+	Delete(id string) error
+}
+
+// DeliverFunc 投递一条记录，返回各渠道的发送结果
+type DeliverFunc func(ctx context.Context, r *Record) (result.SendResults, error)
+
+// Options 后台投递选项
+type Options struct {
+	Workers       int                // 并发投递的 worker 数量，默认 1
+	PollInterval  time.Duration      // 扫描待投递记录的间隔，默认 1s
+	MaxDeliveries int                // 最大投递次数，达到后移入死信，默认 5
+	Backoff       *types.RetryPolicy // 两次投递之间的退避策略，默认初始 1s、最大 5min
+	KeepDone      bool               // 是否保留已投递的记录
+	DeadLetters   DeadLetterStore    // 设置后放弃投递的记录按失败渠道转为死信并从发件箱删除
+}
+
+// Outbox 持久化发件箱，消息先写入 Store 再由后台 worker 投递，保证至少投递一次
+type Outbox struct {
+	store   Store
+	deliver DeliverFunc
+	opts    Options
+
+	mu       sync.Mutex
+	inflight map[string]bool
+	wake     chan struct{}
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// New 创建发件箱，调用 Start 后开始后台投递
+func New(store Store, deliver DeliverFunc, opts Options) *Outbox {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.MaxDeliveries <= 0 {
+		opts.MaxDeliveries = 5
+	}
+	if opts.Backoff == nil {
+		opts.Backoff = &types.RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Minute, Jitter: 0.2}
+	}
+	return &Outbox{
+		store:    store,
+		deliver:  deliver,
+		opts:     opts,
+		inflight: make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Store 返回发件箱使用的存储
+func (o *Outbox) Store() Store {
+	return o.store
+}
+
+// Enqueue 持久化记录并唤醒后台投递，返回时记录已写入 Store
+func (o *Outbox) Enqueue(r *Record) error {
+	now := time.Now()
+	if r.ID == "" {
+		r.ID = NewID()
+	}
+	r.Status = StatusPending
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = now
+	}
+	if r.NextAttempt.IsZero() {
+		r.NextAttempt = now
+	}
+	r.UpdatedAt = now
+	if err := o.store.Save(r); err != nil {
+		return err
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start 启动后台投递，ctx 结束或调用 Close 后停止；重启后未完成的记录会重新投递
+func (o *Outbox) Start(ctx context.Context) {
+	ctx, o.cancel = context.WithCancel(ctx)
+	jobs := make(chan *Record)
+	for i := 0; i < o.opts.Workers; i++ {
+		o.wg.Add(1)
+		go func() {
+			defer o.wg.Done()
+			for r := range jobs {
+				o.process(ctx, r)
+				o.mu.Lock()
+				delete(o.inflight, r.ID)
+				o.mu.Unlock()
+			}
+		}()
+	}
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		defer close(jobs)
+		ticker := time.NewTicker(o.opts.PollInterval)
+		defer ticker.Stop()
+		for {
+			o.dispatch(ctx, jobs)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-o.wake:
+			}
+		}
+	}()
+}
+
+// Close 停止后台投递并等待进行中的投递结束
+func (o *Outbox) Close() error {
+	if o.cancel != nil {
+		o.cancel()
+	}
+	o.wg.Wait()
+	return nil
+}
+
+// dispatch 将到期且未在投递中的记录交给 worker
+func (o *Outbox) dispatch(ctx context.Context, jobs chan<- *Record) {
+	records, err := o.store.Due(time.Now(), o.opts.Workers*4)
+	if err != nil {
+		log.Println("outbox: load due records:", err)
+		return
+	}
+	for _, r := range records {
+		o.mu.Lock()
+		busy := o.inflight[r.ID]
+		if !busy {
+			o.inflight[r.ID] = true
+		}
+		o.mu.Unlock()
+		if busy {
+			continue
+		}
+		// Due 返回后 worker 可能已经保存或删除了同一条记录，标记投递中后重新读取，
+		// 避免投递旧的副本导致重复发送或恢复已完成的记录
+		if r = o.reload(r); r == nil {
+			continue
+		}
+		select {
+		case jobs <- r:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reload 重新读取已标记投递中的记录，记录已被删除、不再待投递或已被更新时取消标记并返回 nil
+func (o *Outbox) reload(due *Record) *Record {
+	r, err := o.store.Get(due.ID)
+	if err == nil && r.Status == StatusPending && r.UpdatedAt.Equal(due.UpdatedAt) {
+		return r
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		log.Println("outbox: reload record:", due.ID, err)
+	}
+	o.mu.Lock()
+	delete(o.inflight, due.ID)
+	o.mu.Unlock()
+	return nil
+}
+
+// process 投递一条记录并根据结果更新状态
+// 全部送达时标记完成；仍有可重试的失败时只保留失败的渠道并延后重试；
+// 永久错误或达到最大投递次数时移入死信
+func (o *Outbox) process(ctx context.Context, r *Record) {
+	results, err := o.deliver(ctx, r)
+	if ctx.Err() != nil {
+		// 停止过程中被中断的投递不计入次数，重启后重新投递
+		return
+	}
+	now := time.Now()
+	d := Delivery{Time: now, Results: results}
+	if err != nil {
+		d.Error = err.Error()
+	}
+	r.Deliveries = append(r.Deliveries, d)
+	r.UpdatedAt = now
+
+	failed, retryable := pendingChannels(r, results, err)
+	switch {
+	case err == nil && len(failed) == 0:
+		r.Status = StatusDone
+	case retryable && len(r.Deliveries) < o.opts.MaxDeliveries:
+		if r.Fallback == nil {
+			r.Channels = failed
+		}
+		r.NextAttempt = now.Add(retry.Backoff(o.opts.Backoff, len(r.Deliveries)))
+	default:
+		r.Status = StatusDead
+	}
+
+	if r.Status == StatusDead && o.opts.DeadLetters != nil {
+		if o.saveDeadLetters(r) {
+			if err := o.store.Delete(r.ID); err != nil {
+				log.Println("outbox: delete record:", r.ID, err)
+			}
+			return
+		}
+	}
+	if r.Status == StatusDone && !o.opts.KeepDone {
+		if err := o.store.Delete(r.ID); err != nil {
+			log.Println("outbox: delete record:", r.ID, err)
+		}
+		return
+	}
+	if err := o.store.Save(r); err != nil {
+		log.Println("outbox: save record:", r.ID, err)
+	}
+}
+
+// saveDeadLetters 将记录写入死信存储，全部写入成功时返回 true，否则记录保留在 dead 状态
+func (o *Outbox) saveDeadLetters(r *Record) bool {
+	for _, d := range deadLetters(r) {
+		if err := o.opts.DeadLetters.SaveDeadLetter(d); err != nil {
+			log.Println("outbox: save dead letter:", r.ID, err)
+			return false
+		}
+	}
+	return true
+}
+
+// pendingChannels 返回尚未送达的渠道，以及它们是否都可以重试
+// 降级链只要有一步送达即视为完成
+func pendingChannels(r *Record, results result.SendResults, err error) (failed []string, retryable bool) {
+	if err != nil {
+		return r.Channels, notify.IsRetryable(err)
+	}
+	if r.Fallback != nil {
+		if results.Delivered() != nil {
+			return nil, false
+		}
+		retryable = len(results) > 0
+		for _, res := range results {
+			retryable = retryable && lastAttemptRetryable(res)
+		}
+		return r.Fallback.Chain, retryable
+	}
+	retryable = true
+	for _, res := range results {
+		if res.Success {
+			continue
+		}
+		failed = append(failed, res.Name())
+		retryable = retryable && lastAttemptRetryable(res)
+	}
+	return failed, retryable
+}
+
+// lastAttemptRetryable 最后一次尝试的失败是否可以重试，没有发出请求的失败（如配置错误）不重试
+func lastAttemptRetryable(r *result.SendResult) bool {
+	if len(r.Attempts) == 0 {
+		return false
+	}
+	return r.Attempts[len(r.Attempts)-1].Retryable
+}
+
+// NewID 生成按时间排序的记录 ID
+func NewID() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%019d-%s", time.Now().UnixNano(), hex.EncodeToString(b))
+}
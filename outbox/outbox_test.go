@@ -0,0 +1,254 @@
+package outbox
+
+import (
+	"context"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/result"
+	"github.com/v-mars/notify/types"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	first := &Record{ID: NewID(), Status: StatusPending, Message: notify.NewMessage("t", "c"), NextAttempt: now}
+	later := &Record{ID: NewID(), Status: StatusPending, NextAttempt: now.Add(time.Hour)}
+	for _, r := range []*Record{first, later} {
+		if err = store.Save(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	due, err := store.Due(now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].ID != first.ID || due[0].Message.Text != "c" {
+		t.Fatalf("Due() = %+v, want only %s", due, first.ID)
+	}
+
+	first.Status = StatusDead
+	if err = store.Save(first); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ := store.List(StatusPending); len(pending) != 1 {
+		t.Errorf("pending = %d records, want 1", len(pending))
+	}
+	if dead, _ := store.List(StatusDead); len(dead) != 1 || dead[0].ID != first.ID {
+		t.Errorf("dead = %+v, want %s", dead, first.ID)
+	}
+
+	if err = store.Delete(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Get(first.ID); err != ErrNotFound {
+		t.Errorf("Get after Delete err = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestFileStoreCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	later := &Record{ID: NewID(), Status: StatusPending, NextAttempt: now.Add(time.Hour)}
+	if err = store.Save(later); err != nil {
+		t.Fatal(err)
+	}
+	// 被截断的记录不影响其他记录的投递，移到 .corrupt 文件中
+	corrupt := filepath.Join(dir, string(StatusPending), "0-truncated.json")
+	if err = os.WriteFile(corrupt, []byte(`{"id":"0-trunc`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if due, err := store.Due(now, 10); err != nil || len(due) != 0 {
+		t.Fatalf("Due() = %+v, %v", due, err)
+	}
+	if _, err = os.Stat(corrupt + ".corrupt"); err != nil {
+		t.Errorf("corrupt file was not moved aside: %v", err)
+	}
+	if pending, err := store.List(StatusPending); err != nil || len(pending) != 1 {
+		t.Errorf("List() = %d records, %v", len(pending), err)
+	}
+
+	// 未到期的记录更新后重新解析
+	later.NextAttempt = now
+	if err = store.Save(later); err != nil {
+		t.Fatal(err)
+	}
+	if due, err := store.Due(now, 10); err != nil || len(due) != 1 || due[0].ID != later.ID {
+		t.Errorf("Due() after update = %+v, %v", due, err)
+	}
+}
+
+func failed(name string, retryable bool) *result.SendResult {
+	return &result.SendResult{
+		ChannelType: name,
+		Error:       result.PtrOf("failed"),
+		Attempts:    []result.Attempt{{Attempt: 1, Error: result.PtrOf("failed"), Retryable: retryable}},
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	calls := map[string][][]string{}
+	deliver := func(ctx context.Context, r *Record) (result.SendResults, error) {
+		mu.Lock()
+		calls[r.Message.Title] = append(calls[r.Message.Title], r.Channels)
+		n := len(calls[r.Message.Title])
+		mu.Unlock()
+		switch r.Message.Title {
+		case "partial":
+			if n == 1 {
+				return result.SendResults{{ChannelType: "email", Success: true}, failed("sms", true)}, nil
+			}
+			return result.SendResults{{ChannelType: "sms", Success: true}}, nil
+		case "permanent":
+			return result.SendResults{failed("sms", false)}, nil
+		}
+		return result.SendResults{{ChannelType: "email", Success: true}}, nil
+	}
+	ob := New(store, deliver, Options{
+		Workers:      2,
+		PollInterval: 5 * time.Millisecond,
+		Backoff:      &types.RetryPolicy{InitialBackoff: time.Millisecond},
+	})
+	for _, title := range []string{"ok", "partial", "permanent"} {
+		if err = ob.Enqueue(&Record{Message: notify.NewMessage(title, ""), Channels: []string{"email", "sms"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ob.Start(context.Background())
+	defer ob.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		pending, _ := store.List(StatusPending)
+		if len(pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("records still pending: %d", len(pending))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := calls["partial"]; len(got) != 2 || len(got[1]) != 1 || got[1][0] != "sms" {
+		t.Errorf("partial deliveries = %v, want second delivery only to sms", got)
+	}
+	dead, _ := store.List(StatusDead)
+	if len(dead) != 1 || dead[0].Message.Title != "permanent" || len(dead[0].Deliveries) != 1 {
+		t.Errorf("dead = %+v, want the permanent record with one delivery", dead)
+	}
+	if done, _ := store.List(StatusDone); len(done) != 0 {
+		t.Errorf("done = %d records, want 0 when KeepDone is false", len(done))
+	}
+}
+
+func TestOutboxDeadLetters(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	n := 0
+	deliver := func(ctx context.Context, r *Record) (result.SendResults, error) {
+		mu.Lock()
+		n++
+		mu.Unlock()
+		return result.SendResults{failed("sms", true)}, nil
+	}
+	ob := New(store, deliver, Options{
+		PollInterval:  5 * time.Millisecond,
+		MaxDeliveries: 2,
+		Backoff:       &types.RetryPolicy{InitialBackoff: time.Millisecond},
+		DeadLetters:   store,
+	})
+	to := types.NotifyToIds{{Phone: "13800000000"}}
+	if err = ob.Enqueue(&Record{To: to, Message: notify.NewMessage("t", "c"), Channels: []string{"sms"}}); err != nil {
+		t.Fatal(err)
+	}
+	ob.Start(context.Background())
+	defer ob.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	var letters []*DeadLetter
+	for len(letters) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no dead letter written")
+		}
+		time.Sleep(5 * time.Millisecond)
+		letters, _ = store.ListDeadLetters(Filter{Channel: "sms"})
+	}
+	d := letters[0]
+	if d.Channel != "sms" || len(d.To) != 1 || d.To[0] != "13800000000" || len(d.Attempts) != 2 || d.Error != "failed" {
+		t.Errorf("dead letter = %+v, want sms with both deliveries' attempts", d)
+	}
+	if records, _ := store.List(StatusDead); len(records) != 0 {
+		t.Errorf("dead records = %d, want 0 after converting to dead letters", len(records))
+	}
+	if got, _ := store.ListDeadLetters(Filter{ErrorContains: "timeout"}); len(got) != 0 {
+		t.Errorf("ErrorContains filter returned %d letters", len(got))
+	}
+}
+
+// staleStore Due 返回之前读取的记录，模拟读取后记录被 worker 保存或删除
+type staleStore struct {
+	*FileStore
+	due []*Record
+}
+
+func (s *staleStore) Due(time.Time, int) ([]*Record, error) {
+	return s.due, nil
+}
+
+func TestOutboxDispatchStale(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &staleStore{FileStore: fs}
+	ob := New(store, nil, Options{})
+	for _, title := range []string{"deleted", "updated", "pending"} {
+		if err = ob.Enqueue(&Record{Message: notify.NewMessage(title, "")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.due, _ = fs.Due(time.Now(), 10)
+	if len(store.due) != 3 {
+		t.Fatalf("due = %d records", len(store.due))
+	}
+	_ = fs.Delete(store.due[0].ID)
+	updated := *store.due[1]
+	updated.Status = StatusDone
+	updated.UpdatedAt = time.Now().Add(time.Second)
+	_ = fs.Save(&updated)
+
+	jobs := make(chan *Record, 3)
+	ob.dispatch(context.Background(), jobs)
+	close(jobs)
+	var got []string
+	for r := range jobs {
+		got = append(got, r.Message.Title)
+	}
+	if len(got) != 1 || got[0] != "pending" {
+		t.Errorf("dispatched = %v, want only pending", got)
+	}
+	if len(ob.inflight) != 1 {
+		t.Errorf("inflight = %v, want only the dispatched record", ob.inflight)
+	}
+}
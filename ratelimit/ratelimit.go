@@ -0,0 +1,271 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/types"
+	"sync"
+	"time"
+)
+
+// ErrLimited 达到限流且按配置拒绝，属于可以稍后重试的临时错误
+var ErrLimited = notify.NewError("rate_limited", true, errors.New("ratelimit: rate limit exceeded"))
+
+// Defaults 内置渠道的默认限流，取自各平台文档：
+//   - dingding：自定义机器人每分钟最多 20 条
+//   - lark：自定义机器人每秒 5 条、每分钟 100 条
+//   - wecom：应用消息发给同一成员每分钟 30 条、每小时 1000 条
+//   - wecom_robot：群机器人每分钟最多 20 条
+//   - slack：incoming webhook 每秒 1 条
+//   - telegram：机器人每秒最多 30 条，同一会话每秒 1 条
+//
+// 超限时等待令牌；email 和 webhook 没有统一的限制，默认不限流；
+// sms 的频控与签名和模板的配置有关，默认不限流，需要时在配置中使用 SMS
+var Defaults = map[string]*types.RateLimit{
+	"dingding": {Bands: []types.RateBand{{Limit: 20, Per: time.Minute}}},
+	"lark": {Bands: []types.RateBand{
+		{Limit: 5, Per: time.Second},
+		{Limit: 100, Per: time.Minute},
+	}},
+	"wecom": {Recipient: []types.RateBand{
+		{Limit: 30, Per: time.Minute},
+		{Limit: 1000, Per: time.Hour},
+	}},
+	"wecom_robot": {Bands: []types.RateBand{{Limit: 20, Per: time.Minute}}},
+	"slack":       {Bands: []types.RateBand{{Limit: 1, Per: time.Second}}},
+	"telegram": {
+		Bands:     []types.RateBand{{Limit: 30, Per: time.Second}},
+		Recipient: []types.RateBand{{Limit: 1, Per: time.Second}},
+	},
+}
+
+// SMS 阿里云短信的默认频控：同一手机号每分钟 1 条、每小时 5 条、每天 10 条，
+// 等待时间可能长达数小时，超限时直接拒绝。默认不启用，需要时配置为 RateLimit["sms"]
+var SMS = &types.RateLimit{Mode: types.RateLimitReject, Recipient: []types.RateBand{
+	{Limit: 1, Per: time.Minute},
+	{Limit: 5, Per: time.Hour},
+	{Limit: 10, Per: 24 * time.Hour},
+}}
+
+// sweepSize 按接收人限流的限流器超过该数量时回收空闲的限流器
+const sweepSize = 1024
+
+// Limiter 渠道实例的限流器，包含实例级别的令牌桶和按接收人的令牌桶
+type Limiter struct {
+	conf    types.RateLimit
+	channel *buckets
+
+	mu         sync.Mutex
+	recipients map[string]*buckets
+}
+
+// New 按配置创建限流器，没有任何有效规则时返回 nil，nil 限流器不做限制
+func New(conf *types.RateLimit) *Limiter {
+	if conf == nil {
+		return nil
+	}
+	l := &Limiter{conf: *conf, channel: newBuckets(conf.Bands)}
+	if l.channel == nil && newBuckets(conf.Recipient) == nil {
+		return nil
+	}
+	return l
+}
+
+// Wait 获取渠道实例的一个令牌，每次实际请求渠道前调用
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	return l.channel.wait(ctx, &l.conf)
+}
+
+// WaitRecipient 获取接收人的一个令牌
+func (l *Limiter) WaitRecipient(ctx context.Context, to string) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	b, ok := l.recipients[to]
+	if !ok {
+		b = newBuckets(l.conf.Recipient)
+		if b != nil {
+			if l.recipients == nil {
+				l.recipients = make(map[string]*buckets)
+			}
+			if len(l.recipients) >= sweepSize {
+				l.sweep(time.Now())
+			}
+			l.recipients[to] = b
+		}
+	}
+	l.mu.Unlock()
+	return b.wait(ctx, &l.conf)
+}
+
+// RefundRecipient 归还接收人的一个令牌，发送失败时调用，避免没有送达的消息占用频控额度
+func (l *Limiter) RefundRecipient(to string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	b := l.recipients[to]
+	l.mu.Unlock()
+	b.refund(time.Now())
+}
+
+// sweep 回收令牌已经补满的接收人限流器，调用方持有 l.mu
+func (l *Limiter) sweep(now time.Time) {
+	for to, b := range l.recipients {
+		if b.full(now) {
+			delete(l.recipients, to)
+		}
+	}
+}
+
+// buckets 需要同时满足的一组令牌桶
+type buckets struct {
+	mu      sync.Mutex
+	bands   []*bucket
+	waiting int
+}
+
+func newBuckets(bands []types.RateBand) *buckets {
+	var bs []*bucket
+	for _, band := range bands {
+		if band.Limit <= 0 || band.Per <= 0 {
+			continue
+		}
+		burst := band.Burst
+		if burst <= 0 {
+			burst = band.Limit
+		}
+		bs = append(bs, &bucket{
+			rate:   float64(band.Limit) / float64(band.Per),
+			burst:  float64(burst),
+			tokens: float64(burst),
+		})
+	}
+	if len(bs) == 0 {
+		return nil
+	}
+	return &buckets{bands: bs}
+}
+
+// wait 预占每个令牌桶的一个令牌，令牌不足时按 conf.Mode 等待或拒绝；
+// 等待期间 ctx 结束时归还预占的令牌
+func (b *buckets) wait(ctx context.Context, conf *types.RateLimit) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	now := time.Now()
+	var delay time.Duration
+	for _, band := range b.bands {
+		band.advance(now)
+		if d := band.delay(); d > delay {
+			delay = d
+		}
+	}
+	if delay > 0 {
+		switch conf.Mode {
+		case types.RateLimitReject:
+			b.mu.Unlock()
+			return ErrLimited
+		case types.RateLimitQueue:
+			if (conf.QueueSize > 0 && b.waiting >= conf.QueueSize) || (conf.MaxWait > 0 && delay > conf.MaxWait) {
+				b.mu.Unlock()
+				return ErrLimited
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok && now.Add(delay).After(deadline) {
+			// 等到令牌时 ctx 已经超时，不再等待
+			b.mu.Unlock()
+			return ErrLimited
+		}
+	}
+	for _, band := range b.bands {
+		band.tokens--
+	}
+	if delay <= 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	b.waiting++
+	b.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		b.mu.Lock()
+		b.waiting--
+		b.mu.Unlock()
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.waiting--
+		now = time.Now()
+		for _, band := range b.bands {
+			band.advance(now)
+			band.tokens = min(band.tokens+1, band.burst)
+		}
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// refund 每个令牌桶归还一个令牌
+func (b *buckets) refund(now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, band := range b.bands {
+		band.advance(now)
+		band.tokens = min(band.tokens+1, band.burst)
+	}
+}
+
+// full 所有令牌桶是否都已补满且没有等待中的请求
+func (b *buckets) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.waiting > 0 {
+		return false
+	}
+	for _, band := range b.bands {
+		band.advance(now)
+		if band.tokens < band.burst {
+			return false
+		}
+	}
+	return true
+}
+
+// bucket 令牌桶，tokens 可以为负数，表示已被等待中的请求预占
+type bucket struct {
+	rate   float64 // 每纳秒补充的令牌数
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) advance(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = min(b.tokens+float64(now.Sub(b.last))*b.rate, b.burst)
+	}
+	b.last = now
+}
+
+// delay 获得一个令牌需要等待的时间
+func (b *bucket) delay() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate)
+}
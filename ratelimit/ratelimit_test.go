@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/types"
+	"testing"
+	"time"
+)
+
+func TestLimiterModes(t *testing.T) {
+	band := []types.RateBand{{Limit: 2, Per: 100 * time.Millisecond}}
+	ctx := context.Background()
+
+	reject := New(&types.RateLimit{Bands: band, Mode: types.RateLimitReject})
+	for i := 0; i < 2; i++ {
+		if err := reject.Wait(ctx); err != nil {
+			t.Fatalf("token %d: %v", i, err)
+		}
+	}
+	if err := reject.Wait(ctx); !errors.Is(err, ErrLimited) || !notify.IsRetryable(err) {
+		t.Errorf("reject mode err = %v, want retryable ErrLimited", err)
+	}
+
+	block := New(&types.RateLimit{Bands: band})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := block.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cost := time.Since(start); cost < 40*time.Millisecond {
+		t.Errorf("block mode third token after %s, want about 50ms", cost)
+	}
+
+	queue := New(&types.RateLimit{Bands: band, Mode: types.RateLimitQueue, MaxWait: 10 * time.Millisecond})
+	_ = queue.Wait(ctx)
+	_ = queue.Wait(ctx)
+	if err := queue.Wait(ctx); !errors.Is(err, ErrLimited) {
+		t.Errorf("queue mode beyond MaxWait err = %v, want ErrLimited", err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := block.Wait(timeout); !errors.Is(err, ErrLimited) {
+		t.Errorf("block mode past deadline err = %v, want ErrLimited", err)
+	}
+}
+
+func TestLimiterRecipients(t *testing.T) {
+	l := New(&types.RateLimit{
+		Recipient: []types.RateBand{{Limit: 1, Per: time.Hour}},
+		Mode:      types.RateLimitReject,
+	})
+	ctx := context.Background()
+	if err := l.Wait(ctx); err != nil {
+		t.Errorf("no channel bands should not limit, got %v", err)
+	}
+	if err := l.WaitRecipient(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.WaitRecipient(ctx, "b"); err != nil {
+		t.Errorf("recipient b limited by a's bucket: %v", err)
+	}
+	if err := l.WaitRecipient(ctx, "a"); !errors.Is(err, ErrLimited) {
+		t.Errorf("second message to a err = %v, want ErrLimited", err)
+	}
+	l.RefundRecipient("a")
+	if err := l.WaitRecipient(ctx, "a"); err != nil {
+		t.Errorf("message to a after refund err = %v", err)
+	}
+	if New(&types.RateLimit{}) != nil {
+		t.Error("empty RateLimit should disable limiting")
+	}
+}
@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Section 渠道配置段，Decode 将配置解码到渠道自己的配置结构体中
+type Section interface {
+	Decode(v any) error
+}
+
+// Factory 根据渠道配置段创建发送器
+type Factory func(section Section) (Sender, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register 注册渠道工厂，渠道包通常在 init 中调用
+// 同一渠道类型重复注册或 factory 为 nil 时 panic
+func Register(channelType string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if factory == nil {
+		panic("notify: Register factory is nil")
+	}
+	if _, dup := factories[channelType]; dup {
+		panic("notify: Register called twice for channel " + channelType)
+	}
+	factories[channelType] = factory
+}
+
+// Registered 渠道类型是否已注册
+func Registered(channelType string) bool {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	_, ok := factories[channelType]
+	return ok
+}
+
+// Channels 返回已注册的渠道类型，按名称排序
+func Channels() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	list := make([]string, 0, len(factories))
+	for name := range factories {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// NewSender 使用已注册的工厂创建渠道发送器
+func NewSender(channelType string, section Section) (Sender, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[channelType]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("不支持的通知渠道: %s", channelType)
+	}
+	return factory(section)
+}
+
+// SectionOf 将已解码的配置结构体包装为 Section，Decode 时按 json 标签转换为目标结构体
+func SectionOf(v any) Section {
+	return jsonSection{v: v}
+}
+
+type jsonSection struct {
+	v any
+}
+
+func (s jsonSection) Decode(v any) error {
+	data, err := json.Marshal(s.v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
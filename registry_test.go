@@ -0,0 +1,56 @@
+package notify
+
+import (
+	"github.com/v-mars/notify/result"
+	"testing"
+)
+
+type registryTestConf struct {
+	URL string `json:"url"`
+}
+
+type registryTestSender struct {
+	url string
+}
+
+func (s *registryTestSender) Send(to []string, title string, content string) (*result.SendResult, error) {
+	return &result.SendResult{ChannelType: s.ChannelType(), Success: true}, nil
+}
+
+func (s *registryTestSender) ChannelType() string { return "registry_test" }
+
+// 渠道只能注册一次，在 init 中注册使 go test -count=N 可以重复运行
+func init() {
+	Register("registry_test", func(section Section) (Sender, error) {
+		var conf registryTestConf
+		if err := section.Decode(&conf); err != nil {
+			return nil, err
+		}
+		return &registryTestSender{url: conf.URL}, nil
+	})
+}
+
+func TestRegister(t *testing.T) {
+	if !Registered("registry_test") {
+		t.Fatal("registry_test not registered")
+	}
+
+	s, err := NewSender("registry_test", SectionOf(map[string]any{"url": "http://example.com"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.(*registryTestSender).url; got != "http://example.com" {
+		t.Errorf("url = %q, want %q", got, "http://example.com")
+	}
+
+	if _, err = NewSender("registry_missing", nil); err == nil {
+		t.Error("NewSender of unregistered channel should fail")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("duplicate Register should panic")
+		}
+	}()
+	Register("registry_test", func(section Section) (Sender, error) { return nil, nil })
+}
@@ -11,15 +11,81 @@ import (
 // SendResult 单个渠道的消息发送结果
 type SendResult struct {
 	ChannelType  string                    `json:"channel_type"`   // 渠道类型（如"email"、"sms"、"wecom"）
+	Instance     string                    `json:"instance"`       // 渠道实例名称（如"lark:ops"），未使用具名实例时与渠道类型相同
 	Success      bool                      `json:"success"`        // 发送是否成功
 	MessageID    string                    `json:"message_id"`     // 消息在系统中的唯一ID（关联主消息记录）
 	ChannelMsgID *string                   `json:"channel_msg_id"` // 渠道返回的消息ID（如短信平台的msgid，可选）
 	Error        *string                   `json:"error"`          // 失败原因（成功时为nil）
 	SendTime     time.Time                 `json:"send_time"`      // 实际发送时间
 	CostMs       int64                     `json:"cost_ms"`        // 发送耗时（毫秒）
+	Attempts     []Attempt                 `json:"attempts"`       // 每一次发送尝试的记录，按时间顺序
+	FallbackStep int                       `json:"fallback_step"`  // 在降级链中的步骤，从 1 开始，未使用降级链时为 0
+	Recipients   []RecipientResult         `json:"recipients"`     // 每个接收人的发送结果，渠道只返回整体结果时每个接收人与整体结果相同
+	Parts        int                       `json:"parts"`          // 消息超长拆分后发送的条数，未拆分时为 0
+	Warnings     []string                  `json:"warnings"`       // 主消息已送达，附件、提醒等补充消息发送失败的原因，不影响 Success
 	Cb           func(s *SendResult) error `json:"-"`              // 发送完成回调
 }
 
+// Attempt 单次发送尝试的记录
+type Attempt struct {
+	Attempt   int       `json:"attempt"`   // 第几次尝试，从 1 开始
+	SendTime  time.Time `json:"send_time"` // 开始时间
+	CostMs    int64     `json:"cost_ms"`   // 耗时（毫秒）
+	Error     *string   `json:"error"`     // 失败原因（成功时为nil）
+	Retryable bool      `json:"retryable"` // 失败是否为可重试的临时错误
+}
+
+// RecipientResult 单个接收人的发送结果
+type RecipientResult struct {
+	To           string  `json:"to"`             // 接收人标识（邮箱、手机号、用户ID等）
+	Success      bool    `json:"success"`        // 是否送达该接收人
+	ChannelMsgID *string `json:"channel_msg_id"` // 渠道返回的消息ID（如短信的BizId）
+	Error        *string `json:"error"`          // 失败原因（成功时为nil）
+}
+
+// AddRecipient 记录一个接收人的发送结果，channelMsgID 为空时不记录
+func (s *SendResult) AddRecipient(to, channelMsgID string, err error) {
+	r := RecipientResult{To: to, Success: err == nil}
+	if channelMsgID != "" {
+		r.ChannelMsgID = &channelMsgID
+	}
+	if err != nil {
+		r.Error = PtrOf(err.Error())
+	}
+	s.Recipients = append(s.Recipients, r)
+}
+
+// AddRecipients 记录一组结果相同的接收人，用于渠道只返回整体结果的情况
+func (s *SendResult) AddRecipients(tos []string, channelMsgID string, err error) {
+	for _, to := range tos {
+		s.AddRecipient(to, channelMsgID, err)
+	}
+}
+
+// AddWarning 记录主消息送达后补充消息的发送失败
+func (s *SendResult) AddWarning(err error) {
+	s.Warnings = append(s.Warnings, err.Error())
+}
+
+// FailedRecipients 返回发送失败的接收人
+func (s *SendResult) FailedRecipients() []string {
+	var failed []string
+	for _, r := range s.Recipients {
+		if !r.Success {
+			failed = append(failed, r.To)
+		}
+	}
+	return failed
+}
+
+// Name 渠道实例名称，未设置实例时返回渠道类型
+func (s *SendResult) Name() string {
+	if s.Instance != "" {
+		return s.Instance
+	}
+	return s.ChannelType
+}
+
 type SendResults []*SendResult
 
 func (s *SendResults) StatisticalResult() (success, failed int, err error) {
@@ -30,12 +96,23 @@ func (s *SendResults) StatisticalResult() (success, failed int, err error) {
 		} else {
 			failed++
 			if v.Error != nil {
-				errs = append(errs, fmt.Sprintf("%s: %s", v.ChannelType, *v.Error))
+				errs = append(errs, fmt.Sprintf("%s: %s", v.Name(), *v.Error))
 			}
 		}
 	}
 	return success, failed, errors.New(strings.Join(errs, ", "))
 }
+
+// Delivered 返回第一个发送成功的结果，使用降级链时即为实际送达的步骤，全部失败时返回 nil
+func (s *SendResults) Delivered() *SendResult {
+	for _, v := range *s {
+		if v.Success {
+			return v
+		}
+	}
+	return nil
+}
+
 func (s *SendResults) ResultMsg() string {
 	success, failed, err := s.StatisticalResult()
 	if err != nil {
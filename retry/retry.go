@@ -0,0 +1,110 @@
+package retry
+
+import (
+	"context"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/types"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInitialBackoff   = 500 * time.Millisecond
+	defaultMaxBackoff       = 30 * time.Second
+	defaultMultiplier       = 2.0
+	defaultBudgetTokenRatio = 0.1
+)
+
+// Backoff 返回第 attempt 次失败后的等待时间（attempt 从 1 开始），按指数增长并加入随机抖动
+func Backoff(policy *types.RetryPolicy, attempt int) time.Duration {
+	initial := policy.InitialBackoff
+	if initial <= 0 {
+		initial = defaultInitialBackoff
+	}
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	multiplier := policy.Multiplier
+	if multiplier < 1 {
+		multiplier = defaultMultiplier
+	}
+	backoff := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	if backoff > float64(maxBackoff) {
+		backoff = float64(maxBackoff)
+	}
+	if jitter := math.Min(policy.Jitter, 1); jitter > 0 {
+		backoff *= 1 + jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(backoff)
+}
+
+// Budget 重试预算，避免下游故障时重试放大请求量
+// 与 gRPC 的 retry throttling 相同：每次失败消耗 1 个令牌，每次成功归还 tokenRatio 个令牌，
+// 令牌数不高于上限的一半时不再重试
+type Budget struct {
+	mu         sync.Mutex
+	maxTokens  float64
+	tokenRatio float64
+	tokens     float64
+}
+
+// NewBudget 创建重试预算，maxTokens 小于等于 0 时返回 nil，表示不限制
+func NewBudget(maxTokens, tokenRatio float64) *Budget {
+	if maxTokens <= 0 {
+		return nil
+	}
+	if tokenRatio <= 0 {
+		tokenRatio = defaultBudgetTokenRatio
+	}
+	return &Budget{maxTokens: maxTokens, tokenRatio: tokenRatio, tokens: maxTokens}
+}
+
+// OnSuccess 记录一次成功
+func (b *Budget) OnSuccess() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.maxTokens, b.tokens+b.tokenRatio)
+}
+
+// OnFailure 记录一次失败，返回是否仍允许重试
+func (b *Budget) OnFailure() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Max(0, b.tokens-1)
+	return b.tokens > b.maxTokens/2
+}
+
+// Do 按策略执行 fn，直到成功、遇到永久错误、达到最大尝试次数、重试预算耗尽或 ctx 结束
+// fn 的 attempt 参数从 1 开始，返回最后一次执行的错误
+func Do(ctx context.Context, policy *types.RetryPolicy, budget *Budget, fn func(attempt int) error) error {
+	maxAttempts := 1
+	if policy != nil && policy.MaxAttempts > 1 {
+		maxAttempts = policy.MaxAttempts
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil {
+			budget.OnSuccess()
+			return nil
+		}
+		if !budget.OnFailure() || attempt >= maxAttempts || !notify.IsRetryable(err) {
+			return err
+		}
+		timer := time.NewTimer(Backoff(policy, attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
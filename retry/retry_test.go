@@ -0,0 +1,88 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/types"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := &types.RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+	}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}
+	for i, w := range want {
+		if got := Backoff(policy, i+1); got != w {
+			t.Errorf("Backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := Backoff(policy, 1); got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("Backoff with jitter = %v, want within [50ms, 150ms]", got)
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	policy := &types.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "success", errs: []error{nil}, wantAttempts: 1},
+		{name: "retry then success", errs: []error{notify.Retryable(errors.New("busy")), nil}, wantAttempts: 2},
+		{name: "permanent", errs: []error{notify.Permanent(errors.New("bad token"))}, wantAttempts: 1, wantErr: true},
+		{name: "http 4xx", errs: []error{&notify.HTTPError{StatusCode: 400}}, wantAttempts: 1, wantErr: true},
+		{name: "exhausted", errs: []error{
+			&notify.HTTPError{StatusCode: 502},
+			&notify.HTTPError{StatusCode: 429},
+			&notify.HTTPError{StatusCode: 503},
+		}, wantAttempts: 3, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := Do(context.Background(), policy, nil, func(attempt int) error {
+				attempts++
+				if attempt != attempts {
+					t.Errorf("attempt = %d, want %d", attempt, attempts)
+				}
+				return tt.errs[attempt-1]
+			})
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBudget(t *testing.T) {
+	policy := &types.RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Millisecond}
+	budget := NewBudget(4, 1)
+	attempts := 0
+	_ = Do(context.Background(), policy, budget, func(attempt int) error {
+		attempts++
+		return notify.Retryable(errors.New("busy"))
+	})
+	// 令牌 4 -> 3 -> 2，第二次失败后令牌不高于上限的一半，停止重试
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+	budget.OnSuccess()
+	budget.OnSuccess()
+	if !budget.OnFailure() {
+		t.Error("budget should allow retry after successes")
+	}
+}
@@ -0,0 +1,92 @@
+package sender
+
+import (
+	"context"
+	"github.com/v-mars/notify/breaker"
+	"github.com/v-mars/notify/result"
+	"github.com/v-mars/notify/types"
+	"sort"
+)
+
+// ChannelHealth 渠道实例的健康状态
+type ChannelHealth struct {
+	Channel string `json:"channel"`
+	breaker.Status
+	Fallback string `json:"fallback,omitempty"` // 熔断期间改发的渠道
+}
+
+// Healthy 渠道是否可以正常发送，半开状态视为不健康
+func (h ChannelHealth) Healthy() bool {
+	return h.State == breaker.StateClosed
+}
+
+// breakerConfig 渠道的熔断配置，依次按渠道实例名、渠道类型、"default" 查找
+func (m *Manager) breakerConfig(channel string) *types.BreakerConfig {
+	if m == nil || m.Conf == nil {
+		return nil
+	}
+	for _, key := range []string{channel, types.ChannelTypeOf(channel), "default"} {
+		if conf, ok := m.Conf.Breaker[key]; ok {
+			return conf
+		}
+	}
+	return nil
+}
+
+// breaker 渠道实例的熔断器，同一实例的多次发送共享，未配置熔断时返回 nil
+func (m *Manager) breaker(channel string) *breaker.Breaker {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if b, ok := m.breakers[channel]; ok {
+		return b
+	}
+	if m.breakers == nil {
+		m.breakers = make(map[string]*breaker.Breaker)
+	}
+	b := breaker.New(m.breakerConfig(channel))
+	m.breakers[channel] = b
+	return b
+}
+
+// sendChannel 发送到一个渠道，渠道熔断或半开状态的试探名额已用完，且配置了熔断降级渠道时改发到降级渠道
+func (m *Manager) sendChannel(ctx context.Context, channel string, to types.NotifyToIds, render renderFunc) *result.SendResult {
+	if conf := m.breakerConfig(channel); conf != nil && conf.Fallback != "" && conf.Fallback != channel &&
+		!m.breaker(channel).Available() {
+		return m.sendRenderedToChannel(ctx, conf.Fallback, to.GetToTagList(conf.Fallback), render)
+	}
+	return m.sendRenderedToChannel(ctx, channel, to.GetToTagList(channel), render)
+}
+
+// Health 返回默认渠道、具名实例和已发送过的渠道的熔断状态，按渠道名称排序，不会创建熔断器
+func (m *Manager) Health() []ChannelHealth {
+	if m == nil || m.Conf == nil {
+		return nil
+	}
+	channels := map[string]bool{}
+	for _, channel := range m.Conf.Channels {
+		channels[channel] = true
+	}
+	for channel := range m.Conf.Instances {
+		channels[channel] = true
+	}
+	m.mu.Lock()
+	for channel := range m.breakers {
+		channels[channel] = true
+	}
+	m.mu.Unlock()
+
+	var health []ChannelHealth
+	for channel := range channels {
+		// 只读取已有的熔断器，没有发送过的渠道为正常状态
+		m.mu.Lock()
+		b := m.breakers[channel]
+		m.mu.Unlock()
+		h := ChannelHealth{Channel: channel, Status: b.Status()}
+		if conf := m.breakerConfig(channel); conf != nil {
+			h.Fallback = conf.Fallback
+		}
+		health = append(health, h)
+	}
+	sort.Slice(health, func(i, j int) bool { return health[i].Channel < health[j].Channel })
+	return health
+}
@@ -0,0 +1,105 @@
+package sender
+
+import (
+	"context"
+	"fmt"
+	"github.com/v-mars/notify/outbox"
+	"github.com/v-mars/notify/result"
+	"github.com/v-mars/notify/types"
+	"log"
+)
+
+// ReplayOptions 死信重新投递选项
+type ReplayOptions struct {
+	Channel string // 改投的渠道，为空时投递到死信原来的渠道；改投时按原始接收人解析该渠道的接收人
+	Keep    bool   // 重新投递成功后保留死信，默认删除
+}
+
+// deadLetterStore 返回使用的死信存储，优先使用 DeadLetters，其次是发件箱的存储
+func (m *Manager) deadLetterStore() outbox.DeadLetterStore {
+	if m == nil {
+		return nil
+	}
+	if m.DeadLetters != nil {
+		return m.DeadLetters
+	}
+	if ob := m.getOutbox(); ob != nil {
+		store, _ := ob.Store().(outbox.DeadLetterStore)
+		return store
+	}
+	return nil
+}
+
+// ListDeadLetters 查询死信，可按渠道、失败原因和失败时间过滤
+func (m *Manager) ListDeadLetters(filter outbox.Filter) ([]*outbox.DeadLetter, error) {
+	store := m.deadLetterStore()
+	if store == nil {
+		return nil, fmt.Errorf("没有配置死信存储")
+	}
+	return store.ListDeadLetters(filter)
+}
+
+// Replay 重新投递指定的死信，返回每条死信的发送结果
+// 投递成功的死信默认删除；失败时追加本次的尝试记录并保留
+func (m *Manager) Replay(ctx context.Context, ids []string, opts ReplayOptions) (result.SendResults, error) {
+	store := m.deadLetterStore()
+	if store == nil {
+		return nil, fmt.Errorf("没有配置死信存储")
+	}
+	var results result.SendResults
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		d, err := store.GetDeadLetter(id)
+		if err != nil {
+			return results, fmt.Errorf("读取死信 %s 失败: %w", id, err)
+		}
+		channel, to := d.Channel, d.To
+		if opts.Channel != "" && opts.Channel != d.Channel {
+			channel, to = opts.Channel, d.Recipients.GetToTagList(opts.Channel)
+		}
+		r := m.SendMessageToChannel(ctx, channel, to, d.Message)
+		results = append(results, r)
+
+		if r.Success && !opts.Keep {
+			if err = store.DeleteDeadLetter(id); err != nil {
+				return results, err
+			}
+			continue
+		}
+		d.Replays++
+		d.Attempts = append(d.Attempts, r.Attempts...)
+		if r.Error != nil {
+			d.Error = *r.Error
+		}
+		if err = store.SaveDeadLetter(d); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// saveDeadLetters 将发送失败的渠道写入死信；降级链中有渠道送达时不写入，ctx 被取消的发送不写入
+func (m *Manager) saveDeadLetters(ctx context.Context, to types.NotifyToIds, render renderFunc, results result.SendResults) {
+	store := m.deadLetterStore()
+	if store == nil || ctx.Err() != nil {
+		return
+	}
+	if len(results) > 0 && results[0].FallbackStep > 0 && results.Delivered() != nil {
+		return
+	}
+	for _, r := range results {
+		if r == nil || r.Success {
+			continue
+		}
+		msg, err := render(r.Name())
+		if err != nil {
+			continue
+		}
+		d := outbox.NewDeadLetter(to, to.GetToTagList(r.Name()), msg, r)
+		if err := store.SaveDeadLetter(d); err != nil {
+			log.Println("保存死信失败:", r.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package sender
+
+import (
+	"context"
+	"fmt"
+	"github.com/v-mars/notify/result"
+	"github.com/v-mars/notify/types"
+)
+
+// fallbackPolicy 确定本次发送使用的降级链
+// 优先使用 SendOptions.Fallback，调用方未指定渠道时使用配置中的降级链
+func (m *Manager) fallbackPolicy(opts SendOptions) *types.FallbackPolicy {
+	if opts.Fallback != nil {
+		return opts.Fallback
+	}
+	if len(opts.Channels) == 0 && m.Conf != nil {
+		return m.Conf.Fallback
+	}
+	return nil
+}
+
+// sendFallback 按降级链顺序发送，每个结果记录其所在步骤
+// first_success 模式下某一步成功后停止，all 模式下发送到链上所有渠道
+func (m *Manager) sendFallback(ctx context.Context, to types.NotifyToIds, render renderFunc, policy *types.FallbackPolicy) (result.SendResults, error) {
+	if len(policy.Chain) == 0 {
+		return nil, fmt.Errorf("降级链没有指定发送渠道")
+	}
+	mode := policy.Mode
+	if mode == "" {
+		mode = types.FallbackFirstSuccess
+	}
+	if mode != types.FallbackFirstSuccess && mode != types.FallbackAll {
+		return nil, fmt.Errorf("不支持的降级模式: %s", mode)
+	}
+
+	var results result.SendResults
+	for i, channel := range policy.Chain {
+		if ctx.Err() != nil {
+			break
+		}
+		r := m.sendStep(ctx, channel, to, render, policy)
+		r.FallbackStep = i + 1
+		results = append(results, r)
+		if r.Success && mode == types.FallbackFirstSuccess {
+			break
+		}
+	}
+	return results, nil
+}
+
+// sendStep 发送降级链中的一步，StepTimeout 大于 0 时单独限制这一步的耗时；
+// 与并行发送一样经过熔断判断，熔断时改发到该渠道的熔断降级渠道
+func (m *Manager) sendStep(ctx context.Context, channel string, to types.NotifyToIds, render renderFunc, policy *types.FallbackPolicy) *result.SendResult {
+	if policy.StepTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.StepTimeout)
+		defer cancel()
+	}
+	return m.sendChannel(ctx, channel, to, render)
+}
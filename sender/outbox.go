@@ -0,0 +1,101 @@
+package sender
+
+import (
+	"context"
+	"fmt"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/outbox"
+	"github.com/v-mars/notify/result"
+	"github.com/v-mars/notify/types"
+)
+
+// StartOutbox 启动持久化发件箱和后台投递 worker
+// store 为 nil 时使用 Conf.Outbox.Dir 目录下的 FileStore，投递复用 Manager 的重试和并发控制；
+// 未设置 DeadLetters 且 store 实现了 DeadLetterStore 时，放弃投递的消息写入 store 的死信
+func (m *Manager) StartOutbox(ctx context.Context, store outbox.Store) error {
+	if m == nil || m.Conf == nil {
+		return fmt.Errorf("notify manager is nil")
+	}
+	conf := m.Conf.Outbox
+	if conf == nil {
+		conf = &types.OutboxConfig{}
+	}
+	if store == nil {
+		if conf.Dir == "" {
+			return fmt.Errorf("没有配置发件箱存储目录")
+		}
+		fs, err := outbox.NewFileStore(conf.Dir)
+		if err != nil {
+			return err
+		}
+		store = fs
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.outbox != nil {
+		return fmt.Errorf("发件箱已启动")
+	}
+	deadLetters := m.DeadLetters
+	if deadLetters == nil {
+		deadLetters, _ = store.(outbox.DeadLetterStore)
+	}
+	m.outbox = outbox.New(store, m.deliverRecord, outbox.Options{
+		Workers:       conf.Workers,
+		PollInterval:  conf.PollInterval,
+		MaxDeliveries: conf.MaxDeliveries,
+		KeepDone:      conf.KeepDone,
+		DeadLetters:   deadLetters,
+	})
+	m.outbox.Start(ctx)
+	return nil
+}
+
+// Enqueue 将消息写入发件箱后立即返回记录 ID，由后台 worker 投递
+// 返回时消息已持久化，进程重启后未完成的消息会重新投递
+func (m *Manager) Enqueue(ctx context.Context, to types.NotifyToIds, msg *notify.Message, opts SendOptions) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	ob := m.getOutbox()
+	if ob == nil {
+		return "", fmt.Errorf("发件箱未启动")
+	}
+	// 入队时确定降级链和默认渠道，重试时只重发失败的渠道，不会重发降级链中已送达后的步骤
+	r := &outbox.Record{
+		To:       to,
+		Message:  msg,
+		Fallback: m.fallbackPolicy(opts),
+	}
+	if r.Fallback == nil {
+		r.Channels = opts.Channels
+		if len(r.Channels) == 0 && m.Conf != nil {
+			r.Channels = m.Conf.Channels
+		}
+	}
+	if err := ob.Enqueue(r); err != nil {
+		return "", err
+	}
+	return r.ID, nil
+}
+
+// Close 停止发件箱的后台投递，等待进行中的投递结束
+func (m *Manager) Close() error {
+	if ob := m.getOutbox(); ob != nil {
+		return ob.Close()
+	}
+	return nil
+}
+
+func (m *Manager) getOutbox() *outbox.Outbox {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.outbox
+}
+
+// deliverRecord 投递发件箱中的一条记录，放弃投递时由发件箱写入死信
+func (m *Manager) deliverRecord(ctx context.Context, r *outbox.Record) (result.SendResults, error) {
+	return m.sendMessage(ctx, r.To, staticMessage(r.Message), SendOptions{Channels: r.Channels, Fallback: r.Fallback})
+}
@@ -0,0 +1,64 @@
+package sender
+
+import (
+	"context"
+	"github.com/v-mars/notify/ratelimit"
+	"github.com/v-mars/notify/result"
+	"github.com/v-mars/notify/types"
+)
+
+// rateLimit 渠道的限流配置，依次按渠道实例名、渠道类型、"default" 查找，都未配置时使用内置默认值
+func (m *Manager) rateLimit(channel string) *types.RateLimit {
+	for _, key := range []string{channel, types.ChannelTypeOf(channel), "default"} {
+		if conf, ok := m.Conf.RateLimit[key]; ok {
+			return conf
+		}
+	}
+	return ratelimit.Defaults[types.ChannelTypeOf(channel)]
+}
+
+// limiter 渠道实例的限流器，同一实例的多次发送共享，未限流时返回 nil
+func (m *Manager) limiter(channel string) *ratelimit.Limiter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l, ok := m.limiters[channel]; ok {
+		return l
+	}
+	if m.limiters == nil {
+		m.limiters = make(map[string]*ratelimit.Limiter)
+	}
+	l := ratelimit.New(m.rateLimit(channel))
+	m.limiters[channel] = l
+	return l
+}
+
+// limitRecipients 按接收人限流，返回可以发送的接收人、被限流的接收人结果和最后一个限流错误
+func limitRecipients(ctx context.Context, l *ratelimit.Limiter, to []string) (allowed []string, limited []result.RecipientResult, err error) {
+	r := &result.SendResult{}
+	for _, t := range to {
+		if waitErr := l.WaitRecipient(ctx, t); waitErr != nil {
+			r.AddRecipient(t, "", waitErr)
+			err = waitErr
+			continue
+		}
+		allowed = append(allowed, t)
+	}
+	return allowed, r.Recipients, err
+}
+
+// refundRecipients 归还没有送达的接收人的令牌，delivered 为渠道返回的每个接收人的结果，
+// 渠道没有返回接收人结果时按 err 判断所有接收人是否送达
+func refundRecipients(l *ratelimit.Limiter, to []string, delivered []result.RecipientResult, err error) {
+	if l == nil {
+		return
+	}
+	ok := map[string]bool{}
+	for _, r := range delivered {
+		ok[r.To] = r.Success
+	}
+	for _, t := range to {
+		if success, reported := ok[t]; (reported && !success) || (!reported && err != nil) {
+			l.RefundRecipient(t)
+		}
+	}
+}
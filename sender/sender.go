@@ -1,13 +1,22 @@
 package sender
 
 import (
+	"context"
 	"fmt"
 	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/breaker"
 	"github.com/v-mars/notify/dingding"
 	"github.com/v-mars/notify/email"
 	"github.com/v-mars/notify/lark"
+	"github.com/v-mars/notify/outbox"
+	"github.com/v-mars/notify/ratelimit"
 	"github.com/v-mars/notify/result"
+	"github.com/v-mars/notify/retry"
+	"github.com/v-mars/notify/sizelimit"
+	"github.com/v-mars/notify/slack"
 	"github.com/v-mars/notify/sms"
+	"github.com/v-mars/notify/telegram"
+	"github.com/v-mars/notify/templates"
 	"github.com/v-mars/notify/types"
 	"github.com/v-mars/notify/webhook"
 	"github.com/v-mars/notify/wechat"
@@ -29,11 +38,24 @@ type Manager struct {
 	MsgType        string `json:"msg_type" yaml:"msg_type"`
 	ToParty, ToTag []string
 	MaxConcurrency int // 最大并发数，默认为0表示无限制
+	// DeadLetters 死信存储，设置后发送失败的渠道写入死信，可通过 Replay 重新投递；
+	// 未设置时使用发件箱的存储（如果它实现了 DeadLetterStore）
+	DeadLetters outbox.DeadLetterStore
+	// Templates 消息模板，SendTemplate 使用；未设置时按 Conf.Templates 加载
+	Templates *templates.Registry
+
+	mu       sync.Mutex
+	budgets  map[string]*retry.Budget      // 各渠道实例的重试预算
+	limiters map[string]*ratelimit.Limiter // 各渠道实例的限流器
+	breakers map[string]*breaker.Breaker   // 各渠道实例的熔断器
+	outbox   *outbox.Outbox                // 持久化发件箱，StartOutbox 后可用
 }
 
 // SendOptions 发送选项
 type SendOptions struct {
-	Channels []string // 指定发送渠道，为空时使用默认渠道
+	Channels []string // 指定发送渠道，可以是渠道类型或具名实例（如 "lark:ops"），为空时使用默认渠道
+	// Fallback 按降级链发送，设置后忽略 Channels；未设置且未指定 Channels 时使用配置中的降级链
+	Fallback *types.FallbackPolicy
 }
 
 type Msg struct {
@@ -42,6 +64,15 @@ type Msg struct {
 	ImBody    string
 }
 
+// Message 转换为结构化消息，EmailBody 作为 HTML 正文，ImBody 作为纯文本正文
+func (msg Msg) Message() *notify.Message {
+	return &notify.Message{
+		Title: msg.Title,
+		Text:  msg.ImBody,
+		HTML:  msg.EmailBody,
+	}
+}
+
 // Send 发送通知消息到指定的渠道
 // 参数:
 //
@@ -54,9 +85,44 @@ type Msg struct {
 //
 //	发送结果列表和可能的错误
 func (m *Manager) Send(to types.NotifyToIds, msg Msg, opts SendOptions) (result.SendResults, error) {
+	return m.SendContext(context.Background(), to, msg, opts)
+}
+
+// SendContext 与 Send 相同，ctx 的超时和取消会传递到每个渠道的发送请求
+func (m *Manager) SendContext(ctx context.Context, to types.NotifyToIds, msg Msg, opts SendOptions) (result.SendResults, error) {
+	return m.SendMessage(ctx, to, msg.Message(), opts)
+}
+
+// SendMessage 发送结构化消息到指定的渠道，每个渠道选择自己支持的最丰富的正文
+func (m *Manager) SendMessage(ctx context.Context, to types.NotifyToIds, msg *notify.Message, opts SendOptions) (result.SendResults, error) {
+	return m.sendRendered(ctx, to, staticMessage(msg), opts)
+}
+
+// sendRendered 发送每个渠道各自渲染的消息，并将失败的渠道写入死信
+func (m *Manager) sendRendered(ctx context.Context, to types.NotifyToIds, render renderFunc, opts SendOptions) (result.SendResults, error) {
+	results, err := m.sendMessage(ctx, to, render, opts)
+	if err == nil {
+		m.saveDeadLetters(ctx, to, render, results)
+	}
+	return results, err
+}
+
+// renderFunc 返回发送到某个渠道的消息
+type renderFunc func(channel string) (*notify.Message, error)
+
+// staticMessage 所有渠道发送同一条消息
+func staticMessage(msg *notify.Message) renderFunc {
+	return func(string) (*notify.Message, error) { return msg, nil }
+}
+
+// sendMessage 发送结构化消息，不写入死信
+func (m *Manager) sendMessage(ctx context.Context, to types.NotifyToIds, render renderFunc, opts SendOptions) (result.SendResults, error) {
 	if m == nil {
 		return nil, fmt.Errorf("notify manager is nil")
 	}
+	if policy := m.fallbackPolicy(opts); policy != nil {
+		return m.sendFallback(ctx, to, render, policy)
+	}
 	// 确定发送渠道
 	channels := opts.Channels
 	if len(channels) == 0 {
@@ -88,15 +154,18 @@ func (m *Manager) Send(to types.NotifyToIds, msg Msg, opts SendOptions) (result.
 			defer wg.Done()
 
 			// 获取信号量，控制并发数
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }() // 释放信号量
-			var r *result.SendResult
-			if ch == email.NotifyTypeEmail {
-				r = m.SendToChannel(ch, to.GetToTagList(ch), msg.Title, msg.EmailBody)
-			} else {
-				r = m.SendToChannel(ch, to.GetToTagList(ch), msg.Title, msg.ImBody)
+			select {
+			case semaphore <- struct{}{}:
+			case <-ctx.Done():
+				resultChan <- &result.SendResult{
+					ChannelType: ch,
+					SendTime:    time.Now(),
+					Error:       result.PtrOf(ctx.Err().Error()),
+				}
+				return
 			}
-			resultChan <- r
+			defer func() { <-semaphore }() // 释放信号量
+			resultChan <- m.sendChannel(ctx, ch, to, render)
 		}(channel)
 	}
 
@@ -124,9 +193,53 @@ func (m *Manager) Send(to types.NotifyToIds, msg Msg, opts SendOptions) (result.
 //
 //	发送结果
 func (m *Manager) SendToChannel(channel string, to []string, title, content string) *result.SendResult {
+	return m.SendToChannelContext(context.Background(), channel, to, title, content)
+}
+
+// SendToChannelContext 与 SendToChannel 相同，发送请求绑定 ctx
+func (m *Manager) SendToChannelContext(ctx context.Context, channel string, to []string, title, content string) *result.SendResult {
+	return m.deliver(ctx, channel, to, func(sender notify.Sender, to []string) (*result.SendResult, error) {
+		return notify.SendContext(ctx, sender, to, title, content)
+	})
+}
+
+// SendMessageToChannel 向指定渠道发送结构化消息，超过渠道长度限制时按配置截断或拆分为多条发送
+func (m *Manager) SendMessageToChannel(ctx context.Context, channel string, to []string, msg *notify.Message) *result.SendResult {
+	parts := sizelimit.Apply(m.sizeLimit(channel), msg)
+	if len(parts) > 1 {
+		return m.sendParts(ctx, channel, to, parts)
+	}
+	msg = parts[0]
+	return m.deliver(ctx, channel, to, func(sender notify.Sender, to []string) (*result.SendResult, error) {
+		return notify.SendMessage(ctx, sender, to, msg)
+	})
+}
+
+// sendRenderedToChannel 渲染渠道的消息后发送，渲染失败时返回失败结果
+func (m *Manager) sendRenderedToChannel(ctx context.Context, channel string, to []string, render renderFunc) *result.SendResult {
+	msg, err := render(channel)
+	if err != nil {
+		return &result.SendResult{
+			ChannelType: types.ChannelTypeOf(channel),
+			Instance:    channel,
+			SendTime:    time.Now(),
+			Error:       result.PtrOf(err.Error()),
+		}
+	}
+	return m.SendMessageToChannel(ctx, channel, to, msg)
+}
+
+// deliver 创建渠道发送器并调用 send 发送，统一处理接收人过滤、重试、错误结果和耗时
+func (m *Manager) deliver(ctx context.Context, channel string, to []string, send func(sender notify.Sender, to []string) (*result.SendResult, error)) (ret *result.SendResult) {
 	defer func() {
 		if err := recover(); err != nil {
 			log.Println("panic:", err)
+			ret = &result.SendResult{
+				ChannelType: types.ChannelTypeOf(channel),
+				Instance:    channel,
+				SendTime:    time.Now(),
+				Error:       result.PtrOf(fmt.Sprintf("panic: %v", err)),
+			}
 		}
 	}()
 	var tmp []string
@@ -136,10 +249,13 @@ func (m *Manager) SendToChannel(channel string, to []string, title, content stri
 		}
 	}
 	to = tmp
+	channelType := types.ChannelTypeOf(channel)
 	if len(to) == 0 {
 		return result.PtrOf(result.SendResult{
-			Success: false,
-			Error:   result.PtrOf(fmt.Sprintf("发送渠道[%s]没有指定接收人", channel)),
+			ChannelType: channelType,
+			Instance:    channel,
+			Success:     false,
+			Error:       result.PtrOf(fmt.Sprintf("发送渠道[%s]没有指定接收人", channel)),
 		})
 	}
 
@@ -147,7 +263,8 @@ func (m *Manager) SendToChannel(channel string, to []string, title, content stri
 
 	// 创建默认失败结果
 	r := &result.SendResult{
-		ChannelType: channel,
+		ChannelType: channelType,
+		Instance:    channel,
 		Success:     false,
 		MessageID:   fmt.Sprintf("%d", time.Now().UnixNano()),
 		SendTime:    startTime,
@@ -155,83 +272,13 @@ func (m *Manager) SendToChannel(channel string, to []string, title, content stri
 	}
 	var sender notify.Sender
 	var err error
-	if m == nil {
+	if m == nil || m.Conf == nil {
 		r.Error = result.PtrOf(fmt.Errorf("notify manager is nil").Error())
 		return r
 	}
 
-	// 根据渠道类型创建对应的发送器
-	switch channel {
-	case email.NotifyTypeEmail:
-		if m.Conf.Email != nil {
-			sender = email.NewMail(
-				m.Conf.Email.Username,
-				m.Conf.Email.Password,
-				m.Conf.Email.SMTPServer,
-				m.Conf.Email.Username,
-				m.Conf.Email.SMTPPort,
-				m.Conf.Email.TLS,
-			)
-		}
-	case sms.NotifyTypeSms:
-		if m.Conf.Sms != nil {
-			sender = sms.NewSms(
-				m.Conf.Sms.Host,
-				m.Conf.Sms.AccessKeyId,
-				m.Conf.Sms.AccessKeySecret,
-				m.Conf.Sms.SignName,
-				m.Conf.Sms.TemplateCode,
-			)
-			// 设置短信签名和模板
-		}
-	case dingding.NotifyTypeDingDing:
-		if m.Conf.Ding != nil {
-			sender = dingding.NewDing(
-				m.Conf.Ding.WebhookUrl,
-				dingding.Sign, // 默认使用签名安全模式
-				m.Conf.Ding.Secret,
-			)
-			// 设置消息类型
-			d := sender.(*dingding.Ding)
-			d.MsgType = m.Conf.Ding.MsgType
-		}
-	case lark.NotifyTypeLark:
-		if m.Conf.Lark != nil {
-			sender = lark.NewLark(
-				m.Conf.Lark.WebhookUrl,
-				lark.Sign,
-				m.Conf.Lark.Secret,
-			)
-			// 设置消息类型
-			l := sender.(*lark.Lark)
-			l.MsgType = m.Conf.Lark.MsgType
-		}
-	case wechat.NotifyTypeWecom:
-		if m.Conf.Wecom != nil {
-			sender = wechat.NewWeChat(
-				m.Conf.Wecom.CorpID,
-				m.Conf.Wecom.AgentID,
-				m.Conf.Wecom.Secret,
-				nil,
-				nil, nil,
-			)
-		}
-	case webhook.NotifyTypeWebhook:
-		if m.Conf.Webhook != nil {
-			sender = webhook.NewWebhook(
-				m.Conf.Webhook.URL,
-				m.Conf.Webhook.Timeout,
-				m.Conf.Webhook.Headers,
-			)
-		}
-	default:
-		err = fmt.Errorf("不支持的通知渠道: %s", channel)
-	}
-
-	// 检查发送器是否创建成功
-	if sender == nil {
-		err = fmt.Errorf("渠道 %s 配置不存在或无效", channel)
-	}
+	// 通过渠道注册表创建对应的发送器
+	sender, err = m.newSender(channel)
 
 	// 如果创建发送器过程中出现错误，直接返回错误结果
 	if err != nil {
@@ -241,17 +288,167 @@ func (m *Manager) SendToChannel(channel string, to []string, title, content stri
 		return r
 	}
 
-	// 发送消息
-	sendResult, err := sender.Send(to, title, content)
+	// 按接收人限流，被限流的接收人不发送，记录在结果的 Recipients 中
+	limiter := m.limiter(channel)
+	to, limited, err := limitRecipients(ctx, limiter, to)
+	if len(to) == 0 {
+		r.Error = result.PtrOf(err.Error())
+		r.Recipients = limited
+		r.CostMs = time.Since(startTime).Milliseconds()
+		return r
+	}
+
+	// 发送消息，临时错误按渠道的重试策略重试，每次请求渠道前获取渠道实例的令牌
+	policy := m.retryPolicy(channel)
+	var sendResult *result.SendResult
+	var attempts []result.Attempt
+	br := m.breaker(channel)
+	err = retry.Do(ctx, policy, m.budget(channel, policy), func(attempt int) error {
+		attemptStart := time.Now()
+		skipped := func(skipErr error) {
+			attempts = append(attempts, result.Attempt{
+				Attempt:   attempt,
+				SendTime:  attemptStart,
+				Error:     result.PtrOf(skipErr.Error()),
+				Retryable: notify.IsRetryable(skipErr),
+			})
+		}
+		// 熔断中快速失败，不再等待重试
+		if openErr := br.Allow(); openErr != nil {
+			skipped(openErr)
+			return notify.Permanent(openErr)
+		}
+		if waitErr := limiter.Wait(ctx); waitErr != nil {
+			br.Cancel()
+			skipped(waitErr)
+			return waitErr
+		}
+		attemptStart = time.Now()
+		res, sendErr := send(sender, to)
+		br.Done(sendErr)
+		a := result.Attempt{
+			Attempt:  attempt,
+			SendTime: attemptStart,
+			CostMs:   time.Since(attemptStart).Milliseconds(),
+		}
+		if sendErr != nil {
+			a.Error = result.PtrOf(sendErr.Error())
+			a.Retryable = notify.IsRetryable(sendErr)
+		}
+		attempts = append(attempts, a)
+		sendResult = res
+		return sendErr
+	})
 	if err != nil {
 		// 发送失败，记录错误信息
 		errorMsg := err.Error()
 		r.Error = &errorMsg
+		r.Attempts = attempts
+		if sendResult != nil {
+			// 保留渠道返回的每个接收人的结果
+			r.ChannelMsgID = sendResult.ChannelMsgID
+			r.Recipients = sendResult.Recipients
+		}
+		refundRecipients(limiter, to, r.Recipients, err)
+		r.Recipients = append(r.Recipients, limited...)
 		r.CostMs = time.Since(startTime).Milliseconds()
 		return r
 	}
 
 	// 发送成功，更新耗时信息
+	sendResult.Instance = channel
+	sendResult.Attempts = attempts
+	refundRecipients(limiter, to, sendResult.Recipients, nil)
+	sendResult.Recipients = append(sendResult.Recipients, limited...)
 	sendResult.CostMs = time.Since(startTime).Milliseconds()
 	return sendResult
 }
+
+// newSender 根据渠道名称查找配置段，通过渠道注册表创建发送器
+// channel 可以是渠道类型（如 "lark"），也可以是具名实例（如 "lark:ops"）
+func (m *Manager) newSender(channel string) (notify.Sender, error) {
+	channelType := types.ChannelTypeOf(channel)
+	if !notify.Registered(channelType) {
+		return nil, fmt.Errorf("不支持的通知渠道: %s", channelType)
+	}
+	section := m.section(channel)
+	if section == nil {
+		return nil, fmt.Errorf("渠道 %s 配置不存在或无效", channel)
+	}
+	sender, err := notify.NewSender(channelType, section)
+	if err != nil {
+		return nil, fmt.Errorf("渠道 %s 配置不存在或无效: %w", channel, err)
+	}
+	if sender == nil {
+		return nil, fmt.Errorf("渠道 %s 配置不存在或无效", channel)
+	}
+	return sender, nil
+}
+
+// section 返回渠道的配置段，具名实例从 Instances 中查找，
+// 内置渠道使用 NotifyConfig 中对应的字段，其他渠道从 Custom 中查找
+func (m *Manager) section(channel string) notify.Section {
+	conf := m.Conf
+	if s, ok := conf.Instances[channel]; ok {
+		return s
+	}
+	switch channel {
+	case email.NotifyTypeEmail:
+		return sectionOf(conf.Email)
+	case sms.NotifyTypeSms:
+		return sectionOf(conf.Sms)
+	case dingding.NotifyTypeDingDing:
+		return sectionOf(conf.Ding)
+	case lark.NotifyTypeLark:
+		return sectionOf(conf.Lark)
+	case wechat.NotifyTypeWecom:
+		return sectionOf(conf.Wecom)
+	case wechat.NotifyTypeWecomRobot:
+		return sectionOf(conf.WecomRobot)
+	case webhook.NotifyTypeWebhook:
+		return sectionOf(conf.Webhook)
+	case slack.NotifyTypeSlack:
+		return sectionOf(conf.Slack)
+	case telegram.NotifyTypeTelegram:
+		return sectionOf(conf.Telegram)
+	}
+	if s, ok := conf.Custom[channel]; ok {
+		return s
+	}
+	return nil
+}
+
+func sectionOf[T any](v *T) notify.Section {
+	if v == nil {
+		return nil
+	}
+	return notify.SectionOf(v)
+}
+
+// retryPolicy 渠道的重试策略，依次按渠道实例名、渠道类型、"default" 查找
+func (m *Manager) retryPolicy(channel string) *types.RetryPolicy {
+	for _, key := range []string{channel, types.ChannelTypeOf(channel), "default"} {
+		if p, ok := m.Conf.Retry[key]; ok && p != nil {
+			return p
+		}
+	}
+	return nil
+}
+
+// budget 渠道实例的重试预算，同一实例的多次发送共享
+func (m *Manager) budget(channel string, policy *types.RetryPolicy) *retry.Budget {
+	if policy == nil || policy.BudgetMaxTokens <= 0 {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.budgets == nil {
+		m.budgets = make(map[string]*retry.Budget)
+	}
+	b, ok := m.budgets[channel]
+	if !ok {
+		b = retry.NewBudget(policy.BudgetMaxTokens, policy.BudgetTokenRatio)
+		m.budgets[channel] = b
+	}
+	return b
+}
@@ -1,9 +1,18 @@
 package sender
 
 import (
+	"context"
+	"errors"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/breaker"
+	"github.com/v-mars/notify/outbox"
+	"github.com/v-mars/notify/result"
 	"github.com/v-mars/notify/types"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestNewNotifySender(t *testing.T) {
@@ -83,4 +92,423 @@ func TestNewNotifySender(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+// downCalls prefix 为 "down-" 的发送器被调用的次数
+var downCalls atomic.Int32
+
+type customSender struct {
+	prefix string
+}
+
+func (c *customSender) Send(to []string, title string, content string) (*result.SendResult, error) {
+	r := &result.SendResult{ChannelType: c.ChannelType()}
+	if c.prefix == "down-" {
+		downCalls.Add(1)
+		return r, notify.Retryable(errors.New("custom_test unavailable"))
+	}
+	if c.prefix == "fail-" {
+		err := errors.New("custom_test failed")
+		r.AddRecipients(to, "", err)
+		return r, err
+	}
+	r.Success = true
+	r.MessageID = c.prefix + to[0]
+	if c.prefix == "echo-" {
+		r.MessageID = title + "|" + content
+	}
+	r.AddRecipients(to, r.MessageID, nil)
+	return r, nil
+}
+
+func (c *customSender) ChannelType() string { return "custom_test" }
+
+func init() {
+	notify.Register("custom_test", func(section notify.Section) (notify.Sender, error) {
+		var conf struct {
+			Prefix string `json:"prefix"`
+		}
+		if err := section.Decode(&conf); err != nil {
+			return nil, err
+		}
+		return &customSender{prefix: conf.Prefix}, nil
+	})
+}
+
+func TestManagerCustomChannel(t *testing.T) {
+	m := NewNotifySender(&types.NotifyConfig{
+		Custom: map[string]types.ChannelSection{
+			"custom_test": {"prefix": "p-"},
+		},
+	}, 0)
+	to := types.NotifyToIds{{Extra: map[string]string{"custom_test": "u1"}}}
+
+	results, err := m.SendContext(context.Background(), to, Msg{Title: "t", ImBody: "c"}, SendOptions{Channels: []string{"custom_test", "unknown_test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byType := map[string]*result.SendResult{}
+	for _, r := range results {
+		byType[r.ChannelType] = r
+	}
+	if r := byType["custom_test"]; r == nil || !r.Success || r.MessageID != "p-u1" {
+		t.Errorf("custom_test result = %+v", r)
+	}
+	if r := byType["unknown_test"]; r != nil && r.Success {
+		t.Errorf("unknown_test should fail, got %+v", r)
+	}
+}
+
+func TestManagerNamedInstances(t *testing.T) {
+	m := NewNotifySender(&types.NotifyConfig{
+		Channels: []string{"custom_test:ops", "custom_test:dba"},
+		Instances: map[string]types.ChannelSection{
+			"custom_test:ops": {"prefix": "ops-"},
+			"custom_test:dba": {"prefix": "dba-"},
+		},
+	}, 0)
+	to := types.NotifyToIds{{Extra: map[string]string{
+		"custom_test":     "u1",
+		"custom_test:dba": "u2",
+	}}}
+
+	results, err := m.Send(to, Msg{Title: "t", ImBody: "c"}, SendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"custom_test:ops": "ops-u1",
+		"custom_test:dba": "dba-u2",
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for _, r := range results {
+		if r.ChannelType != "custom_test" {
+			t.Errorf("ChannelType = %q, want %q", r.ChannelType, "custom_test")
+		}
+		if !r.Success || r.MessageID != want[r.Instance] {
+			t.Errorf("instance %q result = %+v, want message id %q", r.Instance, r, want[r.Instance])
+		}
+	}
+}
+
+func TestManagerFallback(t *testing.T) {
+	conf := &types.NotifyConfig{
+		Instances: map[string]types.ChannelSection{
+			"custom_test:primary": {"prefix": "fail-"},
+			"custom_test:second":  {"prefix": "second-"},
+			"custom_test:third":   {"prefix": "third-"},
+		},
+		Fallback: &types.FallbackPolicy{
+			Chain:       []string{"custom_test:primary", "custom_test:second", "custom_test:third"},
+			StepTimeout: time.Second,
+		},
+	}
+	m := NewNotifySender(conf, 0)
+	to := types.NotifyToIds{{Extra: map[string]string{"custom_test": "u1"}}}
+
+	results, err := m.Send(to, Msg{Title: "t", ImBody: "c"}, SendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("first_success got %d results, want 2", len(results))
+	}
+	delivered := results.Delivered()
+	if delivered == nil || delivered.FallbackStep != 2 || delivered.Instance != "custom_test:second" {
+		t.Errorf("delivered = %+v, want step 2 custom_test:second", delivered)
+	}
+	if results[0].Success || results[0].FallbackStep != 1 {
+		t.Errorf("step 1 = %+v, want failed step 1", results[0])
+	}
+
+	all := *conf.Fallback
+	all.Mode = types.FallbackAll
+	results, err = m.Send(to, Msg{Title: "t", ImBody: "c"}, SendOptions{Fallback: &all})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("all got %d results, want 3", len(results))
+	}
+	for i, r := range results {
+		if r.FallbackStep != i+1 {
+			t.Errorf("result %d step = %d, want %d", i, r.FallbackStep, i+1)
+		}
+	}
+}
+
+func TestManagerEnqueueFallback(t *testing.T) {
+	store, err := outbox.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewNotifySender(&types.NotifyConfig{
+		Instances: map[string]types.ChannelSection{
+			"custom_test:primary": {"prefix": "fail-"},
+			"custom_test:second":  {"prefix": "second-"},
+		},
+		Fallback: &types.FallbackPolicy{Chain: []string{"custom_test:primary", "custom_test:second"}},
+		Outbox:   &types.OutboxConfig{KeepDone: true, PollInterval: 10 * time.Millisecond},
+	}, 0)
+	if err = m.StartOutbox(context.Background(), store); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	to := types.NotifyToIds{{Extra: map[string]string{"custom_test": "u1"}}}
+	id, err := m.Enqueue(context.Background(), to, notify.NewMessage("t", "c"), SendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r, err := store.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Status == outbox.StatusDone {
+			// 配置中的降级链记录在发件箱中，第二步送达后不再重发失败的第一步
+			if r.Fallback == nil || len(r.Channels) != 0 || len(r.Deliveries) != 1 {
+				t.Errorf("record = %+v", r)
+			}
+			break
+		}
+		if r.Status == outbox.StatusDead || time.Now().After(deadline) {
+			t.Fatalf("record status = %s, deliveries = %+v", r.Status, r.Deliveries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestManagerDeadLetters(t *testing.T) {
+	store, err := outbox.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewNotifySender(&types.NotifyConfig{
+		Channels: []string{"custom_test:bad", "custom_test:ok"},
+		Instances: map[string]types.ChannelSection{
+			"custom_test:bad": {"prefix": "fail-"},
+			"custom_test:ok":  {"prefix": "ok-"},
+		},
+	}, 0)
+	m.DeadLetters = store
+	to := types.NotifyToIds{{Extra: map[string]string{"custom_test": "u1"}}}
+
+	if _, err = m.Send(to, Msg{Title: "t", ImBody: "c"}, SendOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	letters, err := m.ListDeadLetters(outbox.Filter{Channel: "custom_test", ErrorContains: "failed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].Channel != "custom_test:bad" || len(letters[0].Attempts) == 0 {
+		t.Fatalf("dead letters = %+v, want one for custom_test:bad with attempts", letters)
+	}
+	if got, _ := m.ListDeadLetters(outbox.Filter{Since: time.Now().Add(time.Hour)}); len(got) != 0 {
+		t.Errorf("future Since filter returned %d letters", len(got))
+	}
+
+	id := letters[0].ID
+	results, err := m.Replay(context.Background(), []string{id}, ReplayOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Success {
+		t.Fatalf("replay to original channel = %+v, want failure", results)
+	}
+	if d, _ := store.GetDeadLetter(id); d == nil || d.Replays != 1 {
+		t.Errorf("dead letter after failed replay = %+v, want Replays 1", d)
+	}
+
+	results, err = m.Replay(context.Background(), []string{id}, ReplayOptions{Channel: "custom_test:ok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].Success || results[0].MessageID != "ok-u1" {
+		t.Fatalf("replay to custom_test:ok = %+v", results)
+	}
+	if _, err = store.GetDeadLetter(id); err != outbox.ErrNotFound {
+		t.Errorf("GetDeadLetter after successful replay err = %v, want %v", err, outbox.ErrNotFound)
+	}
+}
+
+func TestManagerRecipients(t *testing.T) {
+	m := NewNotifySender(&types.NotifyConfig{
+		Instances: map[string]types.ChannelSection{
+			"custom_test:bad": {"prefix": "fail-"},
+			"custom_test:ok":  {"prefix": "ok-"},
+		},
+	}, 0)
+	to := types.NotifyToIds{{Extra: map[string]string{"custom_test": "u1"}}, {Extra: map[string]string{"custom_test": "u2"}}}
+
+	results, err := m.Send(to, Msg{Title: "t", ImBody: "c"}, SendOptions{Channels: []string{"custom_test:bad", "custom_test:ok"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if len(r.Recipients) != 2 || r.Recipients[0].To != "u1" || r.Recipients[1].To != "u2" {
+			t.Errorf("%s recipients = %+v, want u1 and u2", r.Name(), r.Recipients)
+			continue
+		}
+		for _, rr := range r.Recipients {
+			if rr.Success != r.Success {
+				t.Errorf("%s recipient %s success = %v, want %v", r.Name(), rr.To, rr.Success, r.Success)
+			}
+		}
+	}
+}
+
+func TestManagerRateLimit(t *testing.T) {
+	m := NewNotifySender(&types.NotifyConfig{
+		Custom:    map[string]types.ChannelSection{"custom_test": {"prefix": "p-"}},
+		Instances: map[string]types.ChannelSection{"custom_test:bad": {"prefix": "fail-"}},
+		RateLimit: map[string]*types.RateLimit{
+			"custom_test": {
+				Recipient: []types.RateBand{{Limit: 1, Per: time.Hour}},
+				Mode:      types.RateLimitReject,
+			},
+		},
+	}, 0)
+	opts := SendOptions{Channels: []string{"custom_test"}}
+	u1 := types.NotifyToIds{{Extra: map[string]string{"custom_test": "u1"}}}
+	if results, _ := m.Send(u1, Msg{Title: "t", ImBody: "c"}, opts); !results[0].Success {
+		t.Fatalf("first send = %+v", results[0])
+	}
+
+	both := append(u1, types.NotifyToId{Extra: map[string]string{"custom_test": "u2"}})
+	results, _ := m.Send(both, Msg{Title: "t", ImBody: "c"}, opts)
+	r := results[0]
+	if !r.Success || r.MessageID != "p-u2" {
+		t.Fatalf("second send = %+v, want u2 delivered", r)
+	}
+	if failed := r.FailedRecipients(); len(failed) != 1 || failed[0] != "u1" {
+		t.Errorf("failed recipients = %v, want [u1] rate limited", failed)
+	}
+
+	results, _ = m.Send(u1, Msg{Title: "t", ImBody: "c"}, opts)
+	if results[0].Success {
+		t.Errorf("all recipients limited should fail, got %+v", results[0])
+	}
+
+	// 发送失败时归还接收人的令牌
+	bad := SendOptions{Channels: []string{"custom_test:bad"}}
+	for i := 0; i < 2; i++ {
+		results, _ = m.Send(u1, Msg{Title: "t", ImBody: "c"}, bad)
+		if e := results[0].Error; e == nil || !strings.Contains(*e, "custom_test failed") {
+			t.Errorf("send %d to failing channel error = %v, want channel error", i, e)
+		}
+	}
+}
+
+func TestManagerBreaker(t *testing.T) {
+	m := NewNotifySender(&types.NotifyConfig{
+		Channels: []string{"custom_test:down"},
+		Instances: map[string]types.ChannelSection{
+			"custom_test:down":   {"prefix": "down-"},
+			"custom_test:backup": {"prefix": "backup-"},
+		},
+		Breaker: map[string]*types.BreakerConfig{
+			"custom_test:down": {FailureThreshold: 2, OpenTimeout: time.Hour, Fallback: "custom_test:backup"},
+		},
+	}, 0)
+	to := types.NotifyToIds{{Extra: map[string]string{"custom_test": "u1"}}}
+	downCalls.Store(0)
+	if health := m.Health(); len(health) != 2 || len(m.breakers) != 0 {
+		t.Errorf("health = %+v, breakers = %d, want read-only health", health, len(m.breakers))
+	}
+
+	for i := 0; i < 2; i++ {
+		results, _ := m.Send(to, Msg{Title: "t", ImBody: "c"}, SendOptions{})
+		if results[0].Success {
+			t.Fatalf("send %d to down channel succeeded", i)
+		}
+	}
+	health := m.Health()
+	if len(health) != 2 || health[1].Channel != "custom_test:down" || health[1].State != breaker.StateOpen || health[1].Healthy() {
+		t.Fatalf("health = %+v, want custom_test:down open", health)
+	}
+
+	results, _ := m.Send(to, Msg{Title: "t", ImBody: "c"}, SendOptions{})
+	if r := results[0]; !r.Success || r.Instance != "custom_test:backup" || r.MessageID != "backup-u1" {
+		t.Errorf("send while open = %+v, want redirected to custom_test:backup", r)
+	}
+	r := m.SendMessageToChannel(context.Background(), "custom_test:down", []string{"u1"}, notify.NewMessage("t", "c"))
+	if r.Success || r.Error == nil || *r.Error != breaker.ErrOpen.Error() {
+		t.Errorf("direct send while open = %+v, want fast failure", r)
+	}
+	chain := &types.FallbackPolicy{Chain: []string{"custom_test:down"}}
+	results, _ = m.Send(to, Msg{Title: "t", ImBody: "c"}, SendOptions{Fallback: chain})
+	if r := results[0]; !r.Success || r.Instance != "custom_test:backup" {
+		t.Errorf("fallback chain step while open = %+v, want redirected to custom_test:backup", r)
+	}
+	if n := downCalls.Load(); n != 2 {
+		t.Errorf("down channel called %d times, want 2", n)
+	}
+}
+
+func TestManagerSendTemplate(t *testing.T) {
+	m := NewNotifySender(&types.NotifyConfig{
+		Channels: []string{"custom_test:ops", "custom_test:dba"},
+		Instances: map[string]types.ChannelSection{
+			"custom_test:ops": {"prefix": "echo-"},
+			"custom_test:dba": {"prefix": "echo-"},
+		},
+		Templates: &types.TemplateConfig{Templates: map[string]*types.Template{
+			"alert": {
+				TemplateBody: types.TemplateBody{Title: "[{{.level | upper}}] {{.name}}", Text: "{{.name}} is down"},
+				Vars:         []string{"level", "name"},
+				Channels: map[string]types.TemplateBody{
+					"custom_test:dba": {Text: "db {{.name}} is down"},
+				},
+			},
+		}},
+	}, 0)
+	to := types.NotifyToIds{{Extra: map[string]string{"custom_test": "u1"}}}
+
+	results, err := m.SendTemplate(to, "alert", map[string]any{"level": "p1", "name": "mysql"}, SendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"custom_test:ops": "[P1] mysql|mysql is down",
+		"custom_test:dba": "[P1] mysql|db mysql is down",
+	}
+	for _, r := range results {
+		if !r.Success || r.MessageID != want[r.Instance] {
+			t.Errorf("instance %q result = %+v, want message id %q", r.Instance, r, want[r.Instance])
+		}
+	}
+
+	if _, err := m.SendTemplate(to, "alert", map[string]any{"level": "p1"}, SendOptions{}); err == nil {
+		t.Error("SendTemplate with missing vars: want error")
+	}
+	if _, err := m.SendTemplate(to, "missing", nil, SendOptions{}); err == nil {
+		t.Error("SendTemplate with unknown template: want error")
+	}
+}
+
+func TestManagerSizeLimit(t *testing.T) {
+	m := NewNotifySender(&types.NotifyConfig{
+		Custom: map[string]types.ChannelSection{
+			"custom_test": {"prefix": "echo-"},
+		},
+		SizeLimit: map[string]*types.SizeLimit{
+			"custom_test": {Text: 40, Mode: types.SizeLimitSplit},
+		},
+	}, 0)
+	to := types.NotifyToIds{{Extra: map[string]string{"custom_test": "u1"}}}
+
+	text := strings.Repeat("0123456789\n", 10)
+	results, err := m.SendContext(context.Background(), to, Msg{Title: "t", ImBody: text}, SendOptions{Channels: []string{"custom_test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].Success || results[0].Parts < 3 {
+		t.Fatalf("results = %+v", results)
+	}
+	if r := results[0]; !strings.HasPrefix(r.MessageID, "t (1/") || len(r.Attempts) != r.Parts {
+		t.Errorf("merged result = %+v", r)
+	}
+}
@@ -0,0 +1,52 @@
+package sender
+
+import (
+	"context"
+	"fmt"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/result"
+	"github.com/v-mars/notify/sizelimit"
+	"github.com/v-mars/notify/types"
+)
+
+// sizeLimit 渠道的长度限制，依次按渠道实例名、渠道类型、"default" 查找，都未配置时使用内置默认值
+func (m *Manager) sizeLimit(channel string) *types.SizeLimit {
+	if m.Conf != nil {
+		for _, key := range []string{channel, types.ChannelTypeOf(channel), "default"} {
+			if conf, ok := m.Conf.SizeLimit[key]; ok {
+				return conf
+			}
+		}
+	}
+	return sizelimit.Defaults[types.ChannelTypeOf(channel)]
+}
+
+// sendParts 依次发送拆分后的消息，某一条失败时停止发送剩余的部分
+// 合并后的结果以第一条的消息 ID 为准，任意一条失败即为失败
+func (m *Manager) sendParts(ctx context.Context, channel string, to []string, parts []*notify.Message) *result.SendResult {
+	var merged *result.SendResult
+	for i, part := range parts {
+		r := m.deliver(ctx, channel, to, func(sender notify.Sender, to []string) (*result.SendResult, error) {
+			return notify.SendMessage(ctx, sender, to, part)
+		})
+		if merged == nil {
+			merged = r
+		} else {
+			merged.CostMs += r.CostMs
+			merged.Attempts = append(merged.Attempts, r.Attempts...)
+			merged.Warnings = append(merged.Warnings, r.Warnings...)
+		}
+		merged.Parts = i + 1
+		if !r.Success {
+			merged.Success = false
+			merged.Recipients = r.Recipients
+			errMsg := "unknown error"
+			if r.Error != nil {
+				errMsg = *r.Error
+			}
+			merged.Error = result.PtrOf(fmt.Sprintf("第 %d/%d 条发送失败: %s", i+1, len(parts), errMsg))
+			break
+		}
+	}
+	return merged
+}
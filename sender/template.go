@@ -0,0 +1,48 @@
+package sender
+
+import (
+	"context"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/result"
+	"github.com/v-mars/notify/templates"
+	"github.com/v-mars/notify/types"
+)
+
+// templateRegistry 返回模板集合，未设置 Manager.Templates 时按 Conf.Templates 加载一次
+func (m *Manager) templateRegistry() (*templates.Registry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Templates != nil {
+		return m.Templates, nil
+	}
+	var conf *types.TemplateConfig
+	if m.Conf != nil {
+		conf = m.Conf.Templates
+	}
+	registry, err := templates.Load(conf)
+	if err != nil {
+		return nil, err
+	}
+	m.Templates = registry
+	return registry, nil
+}
+
+// SendTemplate 渲染命名模板并发送，每个渠道使用模板中为该渠道定义的正文
+func (m *Manager) SendTemplate(to types.NotifyToIds, templateID string, vars map[string]any, opts SendOptions) (result.SendResults, error) {
+	return m.SendTemplateContext(context.Background(), to, templateID, vars, opts)
+}
+
+// SendTemplateContext 与 SendTemplate 相同，发送请求绑定 ctx
+// 模板不存在或缺少变量时直接返回错误，不发送任何渠道
+func (m *Manager) SendTemplateContext(ctx context.Context, to types.NotifyToIds, templateID string, vars map[string]any, opts SendOptions) (result.SendResults, error) {
+	registry, err := m.templateRegistry()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := registry.Render(templateID, "", vars); err != nil {
+		return nil, err
+	}
+	return m.sendRendered(ctx, to, func(channel string) (*notify.Message, error) {
+		return registry.Render(templateID, channel, vars)
+	}, opts)
+}
@@ -0,0 +1,435 @@
+package sizelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/markdown"
+	"github.com/v-mars/notify/types"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Defaults 内置渠道的默认长度限制，取自各平台文档：
+//   - dingding：自定义机器人 text 和 markdown 最多 20000 字节
+//   - wecom：应用消息 text 最多 2048 字节，markdown 最多 4096 字节
+//   - wecom_robot：群机器人 text 最多 2048 字节，markdown 最多 4096 字节
+//   - lark：自定义机器人请求体最多 20KB，预留 JSON 结构的长度
+//   - slack：消息 text 最多 40000 个字符，按字符计算
+//   - telegram：消息最多 4096 个字符，按字符计算
+//   - sms：阿里云短信模板变量最多 35 个字符
+//
+// 默认超长时截断，email 和 webhook 默认不限制
+var Defaults = map[string]*types.SizeLimit{
+	"dingding":    {Text: 20000, Markdown: 20000, Dialect: string(markdown.DingTalk)},
+	"wecom":       {Text: 2048, Markdown: 4096, Dialect: string(markdown.WeCom)},
+	"wecom_robot": {Text: 2048, Markdown: 4096, Dialect: string(markdown.WeCom)},
+	"lark":        {Text: 18000, Markdown: 18000, Dialect: string(markdown.Lark)},
+	"slack":       {Text: 40000, Markdown: 40000, Unit: types.SizeUnitChar, Dialect: string(markdown.Slack)},
+	"telegram":    {Text: 4096, Markdown: 4096, Unit: types.SizeUnitChar, Dialect: string(markdown.Telegram)},
+	"sms":         {Param: 35},
+}
+
+var fenceRegexp = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+
+const (
+	defaultMaxParts = 10
+	defaultMoreText = "查看更多"
+	ellipsis        = "…"
+	// partReserve 拆分后标题追加的编号，如 " (10/10)"
+	partReserve = len(" (10/10)")
+)
+
+// Apply 按长度限制处理消息，返回需要依次发送的消息，未超长时返回原消息
+// 标题、链接和 @ 的长度计入限制；拆分时 @ 和附件只随第一条发送，链接只随最后一条发送
+func Apply(conf *types.SizeLimit, msg *notify.Message) []*notify.Message {
+	if conf == nil || msg == nil {
+		return []*notify.Message{msg}
+	}
+	l := &limiter{conf: conf}
+	text, markdownBody, html := msg.Text, msg.Markdown, msg.HTML
+	if conf.Param > 0 {
+		text = limitParams(text, conf.Param)
+	}
+	if text == "" && markdownBody == "" && html != "" && conf.Text > 0 && l.length(msg.TextBody())+l.overhead(msg) > conf.Text {
+		// 只有 HTML 正文时，纯文本渠道发送的是去除标签后的文本
+		text = msg.TextBody()
+	}
+	if l.fits(text, false, l.overhead(msg)) && l.fits(markdownBody, true, l.overhead(msg)) {
+		if text == msg.Text {
+			return []*notify.Message{msg}
+		}
+		out := *msg
+		out.Text = text
+		return []*notify.Message{&out}
+	}
+	if conf.Mode == types.SizeLimitSplit {
+		return l.split(msg, text, markdownBody)
+	}
+	out := *msg
+	out.Text = l.truncate(text, false, l.overhead(msg), msg.MoreURL)
+	out.Markdown = l.truncate(markdownBody, true, l.overhead(msg), msg.MoreURL)
+	return []*notify.Message{&out}
+}
+
+type limiter struct {
+	conf *types.SizeLimit
+}
+
+func (l *limiter) limit(md bool) int {
+	if md {
+		return l.conf.Markdown
+	}
+	return l.conf.Text
+}
+
+// length 按 Unit 计算长度，char 为字符数，其他为字节数
+func (l *limiter) length(s string) int {
+	if l.conf.Unit == types.SizeUnitChar {
+		return utf8.RuneCountInString(s)
+	}
+	return len(s)
+}
+
+// measure 正文的长度，Markdown 按转换为渠道方言后的长度计算
+func (l *limiter) measure(s string, md bool) int {
+	if md && l.conf.Dialect != "" {
+		return l.length(markdown.Convert(s, markdown.Dialect(l.conf.Dialect)))
+	}
+	return l.length(s)
+}
+
+func (l *limiter) fits(s string, md bool, overhead int) bool {
+	limit := l.limit(md)
+	return s == "" || limit <= 0 || l.measure(s, md)+overhead <= limit
+}
+
+// budget 正文可用的长度，标题等占用过多时至少保留一半
+func (l *limiter) budget(md bool, overhead int) int {
+	limit := l.limit(md)
+	return max(limit-overhead, limit/2)
+}
+
+// overhead 渠道附加到正文中的标题、链接和 @ 的长度
+func (l *limiter) overhead(msg *notify.Message) int {
+	n := l.length(msg.Title) + 1
+	for _, link := range msg.Links {
+		n += l.length(link.Text) + l.length(link.URL) + 8
+	}
+	for _, u := range msg.Mentions.Users {
+		n += l.length(u) + 2
+	}
+	return n
+}
+
+func (l *limiter) truncate(s string, md bool, overhead int, moreURL string) string {
+	if l.fits(s, md, overhead) {
+		return s
+	}
+	if moreURL == "" {
+		moreURL = l.conf.MoreURL
+	}
+	moreText := l.conf.MoreText
+	if moreText == "" {
+		moreText = defaultMoreText
+	}
+	budget := l.budget(md, overhead)
+	for i := 0; i < 4; i++ {
+		head := l.pack(s, md, budget)[0]
+		out := withMore(head, md, moreText, moreURL)
+		excess := l.measure(out, md) - l.budget(md, overhead)
+		if excess <= 0 || budget <= excess {
+			return out
+		}
+		budget -= excess
+	}
+	return withMore(truncateRunes(s, budget/4), md, moreText, moreURL)
+}
+
+// withMore 追加省略号和“查看更多”链接，代码块之后的省略号单独成段
+func withMore(head string, md bool, moreText, moreURL string) string {
+	trimmed := strings.TrimRightFunc(head, unicode.IsSpace)
+	if md && (strings.HasSuffix(trimmed, "```") || strings.HasSuffix(trimmed, "~~~")) {
+		trimmed += "\n\n"
+	}
+	out := trimmed + ellipsis
+	if moreURL == "" {
+		return out
+	}
+	if md {
+		return out + "\n\n[" + moreText + "](" + moreURL + ")"
+	}
+	return out + "\n" + moreText + ": " + moreURL
+}
+
+func (l *limiter) split(msg *notify.Message, text, markdownBody string) []*notify.Message {
+	maxParts := l.conf.MaxParts
+	if maxParts <= 0 {
+		maxParts = defaultMaxParts
+	}
+	over := l.overhead(msg) + partReserve
+	var textParts, mdParts []string
+	if text != "" {
+		textParts = l.packParts(text, false, over, maxParts, msg.MoreURL)
+	}
+	if markdownBody != "" {
+		mdParts = l.packParts(markdownBody, true, over, maxParts, msg.MoreURL)
+	}
+	n := max(len(textParts), len(mdParts))
+	var out []*notify.Message
+	for i := 0; i < n; i++ {
+		part := *msg
+		part.Title = fmt.Sprintf("%s (%d/%d)", msg.Title, i+1, n)
+		part.Text, part.Markdown = "", ""
+		if i < len(textParts) {
+			part.Text = textParts[i]
+		}
+		if i < len(mdParts) {
+			part.Markdown = mdParts[i]
+		}
+		if i > 0 {
+			part.HTML = ""
+			part.Attachments = nil
+			part.Mentions = notify.Mentions{}
+		}
+		if i < n-1 {
+			part.Links = nil
+		}
+		out = append(out, &part)
+	}
+	return out
+}
+
+// packParts 拆分正文，超过 maxParts 时最后一条截断
+func (l *limiter) packParts(s string, md bool, overhead, maxParts int, moreURL string) []string {
+	parts := l.pack(s, md, l.budget(md, overhead))
+	if len(parts) <= maxParts {
+		return parts
+	}
+	rest := strings.Join(parts[maxParts-1:], "\n\n")
+	return append(parts[:maxParts-1], l.truncate(rest, md, overhead, moreURL))
+}
+
+// pack 将正文拆分为不超过 budget 的多段：优先在段落（空行）之间拆分，其次在行之间，
+// 最后在字符之间；Markdown 代码块按行拆分时每段重新补全围栏
+func (l *limiter) pack(s string, md bool, budget int) []string {
+	var parts []string
+	cur := ""
+	for _, chunk := range chunks(s, md) {
+		if next := join(cur, chunk, "\n\n"); l.measure(next, md) <= budget {
+			cur = next
+			continue
+		}
+		if l.measure(chunk, md) <= budget {
+			parts = append(parts, cur)
+			cur = chunk
+			continue
+		}
+		// 超长的段落按行拆分，第一段接在当前内容之后
+		prefix := ""
+		if cur != "" {
+			prefix = cur + "\n\n"
+		}
+		pieces := l.packLines(chunk, md, budget, prefix)
+		parts = append(parts, pieces[:len(pieces)-1]...)
+		cur = pieces[len(pieces)-1]
+	}
+	if cur != "" || len(parts) == 0 {
+		parts = append(parts, cur)
+	}
+	return parts
+}
+
+// packLines 按行拆分超长的段落，prefix 拼接在第一段之前
+func (l *limiter) packLines(chunk string, md bool, budget int, prefix string) []string {
+	lines := strings.Split(chunk, "\n")
+	open, close := "", ""
+	if md && len(lines) >= 2 {
+		if m := fenceRegexp.FindString(lines[0]); m != "" {
+			open = lines[0]
+			marker := strings.TrimSpace(m)
+			lines = lines[1:]
+			if last := strings.TrimSpace(lines[len(lines)-1]); strings.HasPrefix(last, marker[:3]) && strings.Trim(last, marker[:1]) == "" {
+				close = lines[len(lines)-1]
+				lines = lines[:len(lines)-1]
+			} else {
+				close = marker
+			}
+		}
+	}
+	wrap := func(body string) string {
+		if open == "" {
+			return body
+		}
+		return open + "\n" + body + "\n" + close
+	}
+	fits := func(body string) bool {
+		return l.measure(prefix+wrap(body), md) <= budget
+	}
+
+	var parts []string
+	emit := func(body string) {
+		parts = append(parts, prefix+wrap(body))
+		prefix = ""
+	}
+	cur, has := "", false
+	for _, line := range lines {
+		next := line
+		if has {
+			next = cur + "\n" + line
+		}
+		if fits(next) {
+			cur, has = next, true
+			continue
+		}
+		if has {
+			emit(cur)
+			cur, has = "", false
+		}
+		for line != "" && !fits(line) {
+			head, rest := cutRunes(line, fits)
+			switch {
+			case head != "":
+				emit(head)
+			case prefix != "":
+				// 前面的内容已经占满，先单独发送
+				parts = append(parts, strings.TrimRight(prefix, "\n"))
+				prefix = ""
+				continue
+			default:
+				// 单个字符也超过限制，至少发送一个字符
+				_, size := utf8.DecodeRuneInString(line)
+				head, rest = line[:size], line[size:]
+				emit(head)
+			}
+			line = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		}
+		if line != "" {
+			cur, has = line, true
+		}
+	}
+	if has {
+		emit(cur)
+	}
+	if prefix != "" {
+		parts = append(parts, strings.TrimRight(prefix, "\n"))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, wrap(""))
+	}
+	return parts
+}
+
+// cutRunes 返回满足 fits 的最长前缀，尽量在空白处断开，不会拆开多字节字符；没有满足的前缀时 head 为空
+func cutRunes(line string, fits func(string) bool) (head, rest string) {
+	var bounds []int
+	for i := range line {
+		if i > 0 {
+			bounds = append(bounds, i)
+		}
+	}
+	bounds = append(bounds, len(line))
+	// 二分查找，lo 为 -1 表示一个字符也放不下
+	lo, hi := -1, len(bounds)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if fits(line[:bounds[mid]]) {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	if lo < 0 {
+		return "", line
+	}
+	cut := bounds[lo]
+	if cut < len(line) {
+		if i := strings.LastIndexFunc(line[:cut], unicode.IsSpace); i > cut/2 {
+			cut = i + 1
+		}
+	}
+	return strings.TrimRightFunc(line[:cut], unicode.IsSpace), line[cut:]
+}
+
+// chunks 按空行拆分正文，Markdown 代码块内的空行不拆分
+func chunks(s string, md bool) []string {
+	var out []string
+	var cur []string
+	fence := ""
+	flush := func() {
+		if len(cur) > 0 {
+			out = append(out, strings.Join(cur, "\n"))
+			cur = nil
+		}
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		if md {
+			if m := fenceRegexp.FindString(line); m != "" {
+				marker := strings.TrimSpace(m)
+				switch {
+				case fence == "":
+					flush()
+					fence = marker
+				case strings.HasPrefix(marker, fence[:1]) && len(marker) >= len(fence):
+					cur = append(cur, line)
+					fence = ""
+					flush()
+					continue
+				}
+			}
+		}
+		if fence == "" && strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		cur = append(cur, line)
+	}
+	flush()
+	return out
+}
+
+func join(a, b, sep string) string {
+	if a == "" {
+		return b
+	}
+	return a + sep + b
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n <= 0 {
+		return ""
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + ellipsis
+}
+
+// limitParams 截断短信模板参数 JSON 中超长的变量，正文不是 JSON 对象时原样返回
+func limitParams(text string, n int) string {
+	var params map[string]any
+	if err := json.Unmarshal([]byte(text), &params); err != nil {
+		return text
+	}
+	changed := false
+	for k, v := range params {
+		if s, ok := v.(string); ok && utf8.RuneCountInString(s) > n {
+			params[k] = truncateRunes(s, n)
+			changed = true
+		}
+	}
+	if !changed {
+		return text
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(params); err != nil {
+		return text
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,144 @@
+package sizelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/markdown"
+	"github.com/v-mars/notify/types"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestApplyFits(t *testing.T) {
+	msg := notify.NewMessage("t", "short")
+	parts := Apply(&types.SizeLimit{Text: 100}, msg)
+	if len(parts) != 1 || parts[0] != msg {
+		t.Errorf("Apply() = %v, want the original message", parts)
+	}
+}
+
+func TestApplyTruncate(t *testing.T) {
+	msg := notify.NewMessage("告警", strings.Repeat("磁盘使用率过高。", 100))
+	msg.MoreURL = "https://x.io/a/1"
+	conf := &types.SizeLimit{Text: 200}
+	parts := Apply(conf, msg)
+	if len(parts) != 1 {
+		t.Fatalf("got %d parts, want 1", len(parts))
+	}
+	text := parts[0].Text
+	if n := len(text) + (&limiter{conf: conf}).overhead(msg); n > conf.Text {
+		t.Errorf("truncated text is %d bytes, limit %d", n, conf.Text)
+	}
+	if !utf8.ValidString(text) {
+		t.Errorf("truncated text is not valid UTF-8: %q", text)
+	}
+	if !strings.HasSuffix(text, "…\n查看更多: https://x.io/a/1") {
+		t.Errorf("truncated text = %q", text)
+	}
+	if msg.Text == text {
+		t.Error("Apply modified the original message")
+	}
+
+	// Markdown 按渠道方言计算长度，代码块截断后仍然闭合
+	md := "# 日志\n\n```\n" + strings.Repeat("line of log output\n", 50) + "```\n\nend"
+	parts = Apply(&types.SizeLimit{Markdown: 300, Dialect: string(markdown.Slack), MoreURL: "https://x.io"}, &notify.Message{Markdown: md})
+	got := parts[0].Markdown
+	if n := len(markdown.Convert(got, markdown.Slack)); n > 300 {
+		t.Errorf("truncated markdown is %d bytes", n)
+	}
+	if strings.Count(got, "```")%2 != 0 {
+		t.Errorf("truncated markdown has an unclosed fence:\n%s", got)
+	}
+	if !strings.HasSuffix(got, "```\n\n…\n\n[查看更多](https://x.io)") {
+		t.Errorf("truncated markdown = %q", got)
+	}
+}
+
+func TestApplySplit(t *testing.T) {
+	md := "## 变更\n\n" + strings.Repeat("- 修复了一个问题\n", 30) + "\n```go\n" + strings.Repeat("fmt.Println(\"hello\")\n", 30) + "```\n\n" + strings.Repeat("很长的一段中文说明", 40)
+	msg := &notify.Message{
+		Title:       "发布",
+		Markdown:    md,
+		Links:       []notify.Link{{Text: "详情", URL: "https://x.io"}},
+		Mentions:    notify.Mentions{Users: []string{"u1"}},
+		Attachments: []notify.Attachment{{Name: "a.txt", Data: []byte("a")}},
+	}
+	conf := &types.SizeLimit{Markdown: 400, Dialect: string(markdown.DingTalk), Mode: types.SizeLimitSplit}
+	parts := Apply(conf, msg)
+	if len(parts) < 3 {
+		t.Fatalf("got %d parts, want at least 3", len(parts))
+	}
+	var joined []string
+	for i, p := range parts {
+		if n := len(markdown.Convert(p.Markdown, markdown.DingTalk)) + (&limiter{conf: conf}).overhead(p); n > conf.Markdown {
+			t.Errorf("part %d is %d bytes, limit %d", i+1, n, conf.Markdown)
+		}
+		if !utf8.ValidString(p.Markdown) {
+			t.Errorf("part %d is not valid UTF-8", i+1)
+		}
+		if strings.Count(p.Markdown, "```")%2 != 0 {
+			t.Errorf("part %d has an unclosed fence:\n%s", i+1, p.Markdown)
+		}
+		if want := fmt.Sprintf("发布 (%d/%d)", i+1, len(parts)); p.Title != want {
+			t.Errorf("part %d title = %q", i+1, p.Title)
+		}
+		if (i == 0) != (len(p.Mentions.Users) > 0 && len(p.Attachments) > 0) {
+			t.Errorf("part %d mentions = %v, attachments = %d", i+1, p.Mentions, len(p.Attachments))
+		}
+		if (i == len(parts)-1) != (len(p.Links) > 0) {
+			t.Errorf("part %d links = %v", i+1, p.Links)
+		}
+		joined = append(joined, markdown.Convert(p.Markdown, markdown.Plain))
+	}
+	all := strings.Join(joined, "")
+	if strings.Count(all, "修复了一个问题") != 30 || strings.Count(all, "fmt.Println") != 30 {
+		t.Error("split parts lost content")
+	}
+
+	// 超过 MaxParts 时最后一条截断
+	conf.MaxParts = 2
+	if parts = Apply(conf, msg); len(parts) != 2 || !strings.Contains(parts[1].Markdown, "…") {
+		t.Errorf("MaxParts: got %d parts", len(parts))
+	}
+}
+
+func TestApplySplitLongLine(t *testing.T) {
+	text := strings.Repeat("长", 1000)
+	parts := Apply(&types.SizeLimit{Text: 500, Mode: types.SizeLimitSplit, MaxParts: 20}, notify.NewMessage("t", text))
+	var got string
+	for _, p := range parts {
+		if len(p.Text) > 500 || !utf8.ValidString(p.Text) {
+			t.Errorf("part %q is %d bytes", p.Title, len(p.Text))
+		}
+		got += p.Text
+	}
+	if got != text {
+		t.Error("split parts do not add up to the original text")
+	}
+}
+
+func TestApplyChars(t *testing.T) {
+	// telegram 按字符计算，4000 个汉字不超过 4096 个字符
+	text := strings.Repeat("长", 4000)
+	if parts := Apply(Defaults["telegram"], notify.NewMessage("告警", text)); len(parts) != 1 || parts[0].Text != text {
+		t.Errorf("4000 chars truncated by telegram limit")
+	}
+	parts := Apply(Defaults["telegram"], notify.NewMessage("告警", strings.Repeat("长", 5000)))
+	if n := utf8.RuneCountInString(parts[0].Text) + utf8.RuneCountInString("告警") + 1; n > 4096 || n < 4000 {
+		t.Errorf("truncated text is %d chars, want close to 4096", n)
+	}
+}
+
+func TestApplyParams(t *testing.T) {
+	msg := notify.NewMessage("", `{"code":"1234","detail":"<一个超过三十五个字符的很长很长很长很长很长很长很长很长的短信模板变量>"}`)
+	parts := Apply(Defaults["sms"], msg)
+	var params map[string]string
+	if err := json.Unmarshal([]byte(parts[0].Text), &params); err != nil {
+		t.Fatal(err)
+	}
+	if params["code"] != "1234" || utf8.RuneCountInString(params["detail"]) != 35 || !strings.HasPrefix(params["detail"], "<一个") || !strings.HasSuffix(params["detail"], "…") {
+		t.Errorf("Text = %s", parts[0].Text)
+	}
+}
@@ -1,43 +1,119 @@
 package slack
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/markdown"
 	"github.com/v-mars/notify/result"
+	"github.com/v-mars/notify/types"
 	"net/http"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
-// Slack send conf
+const defaultAPIBaseURL = "https://slack.com/api"
+
+// Block Kit 的长度限制
+const (
+	maxHeaderChars  = 150
+	maxSectionChars = 3000
+	maxBlocks       = 50
+)
+
+// retryableErrors 可以重试的错误：请求频率超限和服务端错误，
+// 其他错误（如 channel_not_found、not_in_channel、invalid_auth）为永久错误
+var retryableErrors = map[string]bool{
+	"ratelimited":         true,
+	"internal_error":      true,
+	"fatal_error":         true,
+	"service_unavailable": true,
+	"request_timeout":     true,
+}
+
+// escaper mrkdwn 中需要转义的控制字符，与 markdown 包的 Slack 方言相同
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// Slack send conf，设置了 Token 时通过 chat.postMessage 发送，否则使用 incoming webhook
 type Slack struct {
-	webhookurl string
+	types.Slack
 	httpclient *http.Client
 }
 
 // SendMsg post json data
 type SendMsg struct {
-	Text string `json:"text"`
-	// Channels string `json:"channel"`
-	Username string `json:"username"`
-	// PreText string `json:"pretext"`
+	Channel  string  `json:"channel,omitempty"`
+	Text     string  `json:"text"`
+	Blocks   []Block `json:"blocks,omitempty"`
+	ThreadTS string  `json:"thread_ts,omitempty"`
+	Username string  `json:"username,omitempty"`
+}
+
+// Block Block Kit 中的一个块
+type Block struct {
+	Type     string  `json:"type"`
+	Text     *Text   `json:"text,omitempty"`
+	Elements []*Text `json:"elements,omitempty"`
+}
+
+// Text Block Kit 中的文本对象，Type 为 plain_text 或 mrkdwn
+type Text struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Emoji bool   `json:"emoji,omitempty"`
+}
+
+// Result chat.postMessage 的返回结果
+type Result struct {
+	OK      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
 }
 
 // NewSlack init
 func NewSlack(webhook string) *Slack {
+	return NewSlackConfig(types.Slack{WebhookUrl: webhook})
+}
+
+// NewSlackConfig 根据配置创建 Slack 发送器
+func NewSlackConfig(conf types.Slack) *Slack {
+	if conf.APIBaseURL == "" {
+		conf.APIBaseURL = defaultAPIBaseURL
+	}
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = time.Second * 30
+	}
 	client := &http.Client{
-		Timeout: time.Second * 30,
+		Timeout: timeout,
 		Transport: &http.Transport{
 			Proxy: http.ProxyFromEnvironment,
 		},
 	}
 	return &Slack{
-		webhookurl: webhook,
+		Slack:      conf,
 		httpclient: client,
 	}
 }
 
 // Send will send send msg to slack channel
-func (s *Slack) Send(tos []string, title string, content string) (sendResult *result.SendResult, err error) {
+func (s *Slack) Send(tos []string, title string, content string) (*result.SendResult, error) {
+	return s.SendContext(context.Background(), tos, title, content)
+}
+
+// SendContext will send msg to slack channel, the request is bound to ctx
+func (s *Slack) SendContext(ctx context.Context, tos []string, title string, content string) (*result.SendResult, error) {
+	return s.SendMessage(ctx, tos, notify.NewMessage(title, content))
+}
+
+// SendMessage will send a structured message as Block Kit blocks, text carries the
+// mrkdwn fallback used in notifications. With a bot token every recipient is a
+// channel or user ID, "C123:1712345678.000100" replies in the thread of that message
+func (s *Slack) SendMessage(ctx context.Context, tos []string, msg *notify.Message) (sendResult *result.SendResult, err error) {
 	sendResult = &result.SendResult{
 		ChannelType:  NotifyTypeSlack,
 		ChannelMsgID: nil,
@@ -49,29 +125,219 @@ func (s *Slack) Send(tos []string, title string, content string) (sendResult *re
 	}
 	defer func() {
 		sendResult.CostMs = time.Now().Sub(sendResult.SendTime).Milliseconds()
-		sendResult.ChannelMsgID = result.PtrOf(fmt.Sprintf("%d", time.Now().UnixNano()))
+		sendResult.MessageID = fmt.Sprintf("%d", time.Now().UnixNano())
+		if sendResult.ChannelMsgID == nil {
+			sendResult.ChannelMsgID = result.PtrOf(sendResult.MessageID)
+		}
 		sendResult.Success = err == nil
-		sendResult.MessageID = *sendResult.ChannelMsgID
 		if err != nil {
 			sendResult.Error = result.PtrOf(err.Error())
 		}
 	}()
 	sendmsg := SendMsg{
-		Text: content + "\n" + content,
+		Text:     mrkdwn(msg),
+		Blocks:   blocks(msg),
+		Username: s.Username,
 	}
-	resp, err := notify.JSONPost(http.MethodPost, s.webhookurl, sendmsg, s.httpclient, nil)
-	if err != nil {
+	if s.Token == "" {
+		err = s.postWebhook(ctx, sendmsg)
 		return sendResult, err
 	}
-	if string(resp) != "ok" {
-		err = fmt.Errorf("send data to slack failed error:%s", resp)
-		return sendResult, err
+
+	if len(tos) == 0 && s.Channel != "" {
+		tos = []string{s.Channel}
+	}
+	if len(tos) == 0 {
+		return sendResult, notify.Permanent(errors.New("slack 接收人不能为空"))
+	}
+	var lastErr error
+	for _, to := range tos {
+		if err = ctx.Err(); err != nil {
+			return sendResult, err
+		}
+		m := sendmsg
+		m.Channel, m.ThreadTS, _ = strings.Cut(strings.TrimSpace(to), ":")
+		ts, postErr := s.postMessage(ctx, m)
+		if postErr != nil {
+			lastErr = fmt.Errorf("发送到 %s 失败: %w", to, postErr)
+			sendResult.AddRecipient(to, "", postErr)
+			continue
+		}
+		if sendResult.ChannelMsgID == nil {
+			sendResult.ChannelMsgID = &ts
+		}
+		sendResult.AddRecipient(to, ts, nil)
+	}
+	// 部分接收人发送成功时不返回错误，失败的接收人见 Recipients
+	if failed := sendResult.FailedRecipients(); len(failed) == len(tos) {
+		return sendResult, lastErr
 	}
 	return sendResult, nil
 }
 
+// postWebhook 通过 incoming webhook 发送，webhook 绑定了频道，接口成功时只返回 ok
+func (s *Slack) postWebhook(ctx context.Context, sendmsg SendMsg) error {
+	resp, err := notify.JSONPostContext(ctx, http.MethodPost, s.WebhookUrl, sendmsg, s.httpclient, nil)
+	if err != nil {
+		return err
+	}
+	if string(resp) != "ok" {
+		return fmt.Errorf("send data to slack failed error:%s", resp)
+	}
+	return nil
+}
+
+// postMessage 调用 chat.postMessage，返回消息的 ts
+func (s *Slack) postMessage(ctx context.Context, sendmsg SendMsg) (string, error) {
+	headers := map[string]string{"Authorization": "Bearer " + s.Token}
+	api := strings.TrimRight(s.APIBaseURL, "/") + "/chat.postMessage"
+	resp, err := notify.JSONPostContext(ctx, http.MethodPost, api, sendmsg, s.httpclient, headers)
+	if err != nil {
+		return "", err
+	}
+	var res Result
+	if err = json.Unmarshal(resp, &res); err != nil {
+		return "", fmt.Errorf("invalid slack response: %s", resp)
+	}
+	if !res.OK {
+		return "", notify.NewError(res.Error, retryableErrors[res.Error], fmt.Errorf("slack error: %s", res.Error))
+	}
+	return res.TS, nil
+}
+
+// blocks 将消息渲染为 Block Kit：标题为 header 块，正文按长度拆分为多个 section 块，
+// 链接和 @ 放在最后的 context 块和 section 块中
+func blocks(msg *notify.Message) []Block {
+	var out []Block
+	if title := strings.TrimSpace(msg.Title); title != "" {
+		out = append(out, Block{Type: "header", Text: &Text{Type: "plain_text", Text: truncate(title, maxHeaderChars), Emoji: true}})
+	}
+	body := escaper.Replace(msg.TextBody())
+	if msg.Markdown != "" {
+		body = markdown.Convert(msg.Markdown, markdown.Slack)
+	}
+	for _, section := range sections(body, maxSectionChars) {
+		out = append(out, Block{Type: "section", Text: &Text{Type: "mrkdwn", Text: section}})
+	}
+	if len(msg.Links) > 0 {
+		var links []string
+		for _, link := range msg.Links {
+			links = append(links, mrkdwnLink(link))
+		}
+		out = append(out, Block{Type: "context", Elements: []*Text{{Type: "mrkdwn", Text: truncate(strings.Join(links, "  ·  "), maxSectionChars)}}})
+	}
+	if ats := mentions(msg.Mentions); ats != "" {
+		out = append(out, Block{Type: "section", Text: &Text{Type: "mrkdwn", Text: ats}})
+	}
+	if len(out) > maxBlocks {
+		out = out[:maxBlocks]
+	}
+	return out
+}
+
+// sections 按行将正文拆分为不超过 limit 个字符的多段，超长的行按字符拆分
+func sections(body string, limit int) []string {
+	var out []string
+	cur := ""
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		for utf8.RuneCountInString(line) > limit {
+			if cur != "" {
+				out = append(out, cur)
+				cur = ""
+			}
+			head := truncateRunes(line, limit)
+			out = append(out, head)
+			line = line[len(head):]
+		}
+		next := line
+		if cur != "" {
+			next = cur + "\n" + line
+		}
+		if utf8.RuneCountInString(next) > limit {
+			out = append(out, cur)
+			next = line
+		}
+		cur = next
+	}
+	if strings.TrimSpace(cur) != "" {
+		out = append(out, cur)
+	}
+	return out
+}
+
+func truncateRunes(s string, n int) string {
+	i := 0
+	for pos := range s {
+		if i == n {
+			return s[:pos]
+		}
+		i++
+	}
+	return s
+}
+
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return truncateRunes(s, n-1) + "…"
+}
+
+// mrkdwn renders the message as slack mrkdwn text
+func mrkdwn(msg *notify.Message) string {
+	var lines []string
+	if msg.Title != "" {
+		lines = append(lines, "*"+escaper.Replace(msg.Title)+"*")
+	}
+	if msg.Markdown != "" {
+		lines = append(lines, markdown.Convert(msg.Markdown, markdown.Slack))
+	} else {
+		lines = append(lines, escaper.Replace(msg.TextBody()))
+	}
+	for _, link := range msg.Links {
+		lines = append(lines, mrkdwnLink(link))
+	}
+	if ats := mentions(msg.Mentions); ats != "" {
+		lines = append(lines, ats)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// mrkdwnLink 渲染 <url|text> 链接，转义控制字符，文字中的 | 替换为 ¦，URL 中的 | 编码为 %7C
+func mrkdwnLink(link notify.Link) string {
+	url := strings.ReplaceAll(escaper.Replace(link.URL), "|", "%7C")
+	if link.Text == "" {
+		return "<" + url + ">"
+	}
+	return "<" + url + "|" + strings.ReplaceAll(escaper.Replace(link.Text), "|", "¦") + ">"
+}
+
+func mentions(m notify.Mentions) string {
+	var ats []string
+	if m.All {
+		ats = append(ats, "<!channel>")
+	}
+	for _, u := range m.Users {
+		ats = append(ats, "<@"+u+">")
+	}
+	return strings.Join(ats, " ")
+}
+
 const NotifyTypeSlack = "slack"
 
+func init() {
+	notify.Register(NotifyTypeSlack, func(section notify.Section) (notify.Sender, error) {
+		var conf types.Slack
+		if err := section.Decode(&conf); err != nil {
+			return nil, err
+		}
+		if conf.Token == "" && conf.WebhookUrl == "" {
+			return nil, errors.New("slack token 和 webhook_url 不能都为空")
+		}
+		return NewSlackConfig(conf), nil
+	})
+}
+
 func (s *Slack) ChannelType() string {
 	return NotifyTypeSlack
 }
@@ -0,0 +1,101 @@
+package slack
+
+import (
+	"encoding/json"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSlackPostMessage(t *testing.T) {
+	var got []SendMsg
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat.postMessage" || r.Header.Get("Authorization") != "Bearer xoxb-1" {
+			t.Errorf("request %s %s", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		var m SendMsg
+		_ = json.NewDecoder(r.Body).Decode(&m)
+		got = append(got, m)
+		if m.Channel == "C404" {
+			_, _ = w.Write([]byte(`{"ok":false,"error":"channel_not_found"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true,"channel":"` + m.Channel + `","ts":"1712345678.000100"}`))
+	}))
+	defer srv.Close()
+
+	s := NewSlackConfig(types.Slack{Token: "xoxb-1", APIBaseURL: srv.URL + "/api"})
+	msg := &notify.Message{
+		Title:    "Deploy",
+		Markdown: "**api** done",
+		Links:    []notify.Link{{Text: "log", URL: "https://x.io"}},
+	}
+	r, err := s.SendMessage(t.Context(), []string{"C1", "C2:1712345600.000200", "C404"}, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ChannelMsgID == nil || *r.ChannelMsgID != "1712345678.000100" {
+		t.Errorf("ChannelMsgID = %v", r.ChannelMsgID)
+	}
+	if len(got) != 3 || got[1].Channel != "C2" || got[1].ThreadTS != "1712345600.000200" {
+		t.Fatalf("requests = %+v", got)
+	}
+	b := got[0].Blocks
+	if len(b) != 3 || b[0].Type != "header" || b[0].Text.Text != "Deploy" || b[1].Text.Text != "*api* done" || b[2].Type != "context" {
+		t.Errorf("blocks = %+v", b)
+	}
+	if failed := r.FailedRecipients(); len(failed) != 1 || failed[0] != "C404" {
+		t.Errorf("failed = %v", failed)
+	}
+
+	_, err = s.SendMessage(t.Context(), []string{"C404"}, msg)
+	if err == nil || notify.IsRetryable(err) || !strings.Contains(err.Error(), "channel_not_found") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestSlackWebhook(t *testing.T) {
+	var got SendMsg
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	if _, err := NewSlack(srv.URL).Send(nil, "title", "content"); err != nil {
+		t.Fatal(err)
+	}
+	if got.Text != "*title*\ncontent" || len(got.Blocks) != 2 || got.Blocks[0].Text.Text != "title" {
+		t.Errorf("webhook payload = %+v", got)
+	}
+}
+
+func TestSections(t *testing.T) {
+	body := strings.Repeat("line\n", 10) + strings.Repeat("长", 25)
+	parts := sections(body, 12)
+	// 拆分只发生在换行处和超长的行中
+	if strings.ReplaceAll(strings.Join(parts, ""), "\n", "") != strings.ReplaceAll(body, "\n", "") {
+		t.Errorf("sections lost content: %q", parts)
+	}
+	for _, p := range parts {
+		if n := len([]rune(p)); n > 12 {
+			t.Errorf("section %q has %d chars", p, n)
+		}
+	}
+}
+
+func TestMrkdwnEscape(t *testing.T) {
+	msg := notify.NewMessage("a < b > c & d", "x<y")
+	msg.Links = []notify.Link{{Text: "p95 > 1s | slow", URL: "https://g.io/d?a=1&b=2|3"}}
+	want := "*a &lt; b &gt; c &amp; d*\nx&lt;y\n<https://g.io/d?a=1&amp;b=2%7C3|p95 &gt; 1s ¦ slow>"
+	if got := mrkdwn(msg); got != want {
+		t.Errorf("mrkdwn = %q, want %q", got, want)
+	}
+	bs := blocks(msg)
+	if got := bs[len(bs)-1].Elements[0].Text; got != "<https://g.io/d?a=1&amp;b=2%7C3|p95 &gt; 1s ¦ slow>" {
+		t.Errorf("context link = %q", got)
+	}
+}
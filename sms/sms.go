@@ -1,6 +1,7 @@
 package sms
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
@@ -11,6 +12,7 @@ import (
 	util "github.com/alibabacloud-go/tea-utils/v2/service"
 	"github.com/alibabacloud-go/tea/tea"
 	log "github.com/sirupsen/logrus"
+	"github.com/v-mars/notify"
 	"github.com/v-mars/notify/result"
 	"github.com/v-mars/notify/types"
 	"strings"
@@ -41,6 +43,17 @@ const (
 	IPCdir
 )
 
+// retryableCodes 可以重试的错误码：系统错误、服务不可用和流控，
+// 其他错误码（如 isv.MOBILE_NUMBER_ILLEGAL 手机号错误、isv.SMS_SIGNATURE_ILLEGAL 签名不合法）为永久错误
+var retryableCodes = map[string]bool{
+	"isp.SYSTEM_ERROR":           true,
+	"isv.BUSINESS_LIMIT_CONTROL": true,
+	"Throttling.User":            true,
+	"Throttling.Api":             true,
+	"ServiceUnavailable":         true,
+	"InternalError":              true,
+}
+
 // SmsConf alarm conf
 type SmsConf struct {
 	Gw string `json:"gw"`
@@ -86,7 +99,12 @@ func NewSms(host, accessKeyId, accessKeySecret, SignName, templateCode string) *
 }
 
 // Send to notify tos is phone number content: {"name":"张三","number":"1390000****"}
-func (d *SmsConf) Send(tos []string, title string, content string) (sendResult *result.SendResult, err error) {
+func (d *SmsConf) Send(tos []string, title string, content string) (*result.SendResult, error) {
+	return d.SendContext(context.Background(), tos, title, content)
+}
+
+// SendContext 发送短信，ctx 的截止时间会转换为 SDK 的连接和读取超时
+func (d *SmsConf) SendContext(ctx context.Context, tos []string, title string, content string) (sendResult *result.SendResult, err error) {
 	sendResult = &result.SendResult{
 		ChannelType:  NotifyTypeSms,
 		ChannelMsgID: nil,
@@ -98,17 +116,30 @@ func (d *SmsConf) Send(tos []string, title string, content string) (sendResult *
 	}
 	defer func() {
 		sendResult.CostMs = time.Now().Sub(sendResult.SendTime).Milliseconds()
-		sendResult.ChannelMsgID = result.PtrOf(fmt.Sprintf("%d", time.Now().UnixNano()))
+		sendResult.MessageID = fmt.Sprintf("%d", time.Now().UnixNano())
+		if sendResult.ChannelMsgID == nil {
+			sendResult.ChannelMsgID = result.PtrOf(sendResult.MessageID)
+		}
 		sendResult.Success = err == nil
-		sendResult.MessageID = *sendResult.ChannelMsgID
 		if err != nil {
 			sendResult.Error = result.PtrOf(err.Error())
 		}
 	}()
+	if err = ctx.Err(); err != nil {
+		return sendResult, err
+	}
 	if d.Gw == "tencent" {
-		return sendResult, d.TencentSender(tos, title, content)
+		err = d.TencentSender(tos, title, content)
+		sendResult.AddRecipients(tos, "", err)
+		return sendResult, err
+	}
+	// 阿里云批量发送只返回一个 BizId，所有号码共用该回执 ID
+	bizID, err := d.aliYunSend(ctx, tos, content)
+	if bizID != "" {
+		sendResult.ChannelMsgID = &bizID
 	}
-	return sendResult, d.AliYunSender(tos, title, content)
+	sendResult.AddRecipients(tos, bizID, err)
+	return sendResult, err
 }
 
 func (d *SmsConf) TencentSender(tos []string, title string, content string) (_err error) {
@@ -165,9 +196,29 @@ func (d *SmsConf) TencentSender(tos []string, title string, content string) (_er
 }
 
 func (d *SmsConf) AliYunSender(tos []string, title string, content string) (_err error) {
+	_, _err = d.aliYunSend(context.Background(), tos, content)
+	return _err
+}
+
+// runtimeOptions 将 ctx 的剩余时间转换为 SDK 的超时设置（毫秒）
+func runtimeOptions(ctx context.Context) *util.RuntimeOptions {
+	runtime := &util.RuntimeOptions{}
+	if deadline, ok := ctx.Deadline(); ok {
+		ms := int(time.Until(deadline).Milliseconds())
+		if ms < 1 {
+			ms = 1
+		}
+		runtime.ConnectTimeout = tea.Int(ms)
+		runtime.ReadTimeout = tea.Int(ms)
+	}
+	return runtime
+}
+
+// aliYunSend 调用阿里云短信接口，返回发送回执 ID（BizId）
+func (d *SmsConf) aliYunSend(ctx context.Context, tos []string, content string) (bizID string, _err error) {
 	client, _err := d.NewAliYunClient()
 	if _err != nil {
-		return _err
+		return "", _err
 	}
 	sendSmsRequest := &dysmsapi20170525.SendSmsRequest{
 		SignName:      &d.SmsConfig.SignName,
@@ -175,15 +226,15 @@ func (d *SmsConf) AliYunSender(tos []string, title string, content string) (_err
 		PhoneNumbers:  tea.String(strings.Join(tos, ",")),
 		TemplateParam: tea.String(content),
 	}
-	runtime := &util.RuntimeOptions{}
+	runtime := runtimeOptions(ctx)
+	var resp *dysmsapi20170525.SendSmsResponse
 	tryErr := func() (_e error) {
 		defer func() {
 			if r := tea.Recover(recover()); r != nil {
 				_e = r
 			}
 		}()
-		// 复制代码运行请自行打印 API 的返回值
-		_, _err = client.SendSmsWithOptions(sendSmsRequest, runtime)
+		resp, _err = client.SendSmsWithOptions(sendSmsRequest, runtime)
 		if _err != nil {
 			return _err
 		}
@@ -192,29 +243,32 @@ func (d *SmsConf) AliYunSender(tos []string, title string, content string) (_err
 	}()
 
 	if tryErr != nil {
-		var _er = &tea.SDKError{}
-		if _t, ok := tryErr.(*tea.SDKError); ok {
-			_er = _t
-		} else {
-			_er.Message = tea.String(tryErr.Error())
+		_er, ok := tryErr.(*tea.SDKError)
+		if !ok {
+			return "", notify.NewError("", notify.IsRetryable(tryErr), tryErr)
 		}
-		// 此处仅做打印展示，请谨慎对待异常处理，在工程项目中切勿直接忽略异常。
-		// 错误 message
-		//fmt.Println(tea.StringValue(_er.Message))
 		// 诊断地址
 		var data interface{}
 		d := json.NewDecoder(strings.NewReader(tea.StringValue(_er.Data)))
-		d.Decode(&data)
+		_ = d.Decode(&data)
 		if m, ok := data.(map[string]interface{}); ok {
 			recommend, _ := m["Recommend"]
 			log.Info(recommend)
 		}
-		_, _err = util.AssertAsString(_er.Message)
-		if _err != nil {
-			return _err
-		}
+		code := tea.StringValue(_er.Code)
+		statusCode := tea.IntValue(_er.StatusCode)
+		retryable := retryableCodes[code] || statusCode == 429 || statusCode >= 500
+		return "", notify.NewError(code, retryable, fmt.Errorf("%s", tea.StringValue(_er.Message)))
 	}
-	return nil
+	if resp != nil && resp.Body != nil && tea.StringValue(resp.Body.Code) != "OK" {
+		code := tea.StringValue(resp.Body.Code)
+		return "", notify.NewError(code, retryableCodes[code],
+			fmt.Errorf("code: %s message: %s", code, tea.StringValue(resp.Body.Message)))
+	}
+	if resp != nil && resp.Body != nil {
+		bizID = tea.StringValue(resp.Body.BizId)
+	}
+	return bizID, nil
 }
 
 // Description:
@@ -242,6 +296,18 @@ func (d *SmsConf) NewAliYunClient() (_result *dysmsapi20170525.Client, _err erro
 
 const NotifyTypeSms = "sms"
 
+func init() {
+	notify.Register(NotifyTypeSms, func(section notify.Section) (notify.Sender, error) {
+		var conf types.SmsConfig
+		if err := section.Decode(&conf); err != nil {
+			return nil, err
+		}
+		d := NewSms(conf.Host, conf.AccessKeyId, conf.AccessKeySecret, conf.SignName, conf.TemplateCode)
+		d.Gw = conf.Type
+		return d, nil
+	})
+}
+
 func (d *SmsConf) ChannelType() string {
 	return NotifyTypeSms
 }
@@ -1,68 +1,302 @@
 package telegram
 
-//
-//import (
-//	"github.com/v-mars/notify"
-//	"net/http"
-//	"strconv"
-//	"sync"
-//	"time"
-//
-//	tb "gopkg.in/tucnak/telebot.v2"
-//)
-//
-//var (
-//	once sync.Once
-//)
-//
-//// Telegram conf
-//type Telegram struct {
-//	token string
-//	bot   *tb.Bot
-//}
-//
-//// NewTelegram init telegram
-//func NewTelegram(token string) (notify.Sender, error) {
-//	telegram := &Telegram{
-//		token: token,
-//	}
-//	client := &http.Client{
-//		Timeout: time.Second * 10,
-//		Transport: &http.Transport{
-//			Proxy: http.ProxyFromEnvironment,
-//		},
-//	}
-//	bot, err := tb.NewBot(
-//		tb.Settings{
-//			Token:  token,
-//			Poller: &tb.LongPoller{Timeout: time.Second * 10},
-//			Client: client,
-//		})
-//	if err != nil {
-//		return nil, err
-//	}
-//	telegram.bot = bot
-//
-//	go func() {
-//		once.Do(func() {
-//			bot.Start()
-//		})
-//	}()
-//	// wait bot start
-//	time.Sleep(time.Second)
-//	return telegram, nil
-//}
-//
-//// Send will send notify to channel
-//func (t *Telegram) Send(tos []string, title string, content string) error {
-//	for _, id := range tos {
-//		uid, err := strconv.Atoi(id)
-//		if err != nil {
-//			return err
-//		}
-//		go func(uid int) {
-//			_, _ = t.bot.Send(&tb.User{ID: uid}, title+"\n"+title)
-//		}(uid)
-//	}
-//	return nil
-//}
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/markdown"
+	"github.com/v-mars/notify/result"
+	"github.com/v-mars/notify/types"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultAPIBaseURL = "https://api.telegram.org"
+
+// Telegram 通过 Bot API 的 sendMessage 接口发送消息
+type Telegram struct {
+	types.Telegram
+	httpclient *http.Client
+}
+
+// SendMsg sendMessage 接口的请求参数
+type SendMsg struct {
+	ChatID              string              `json:"chat_id"`
+	MessageThreadID     int                 `json:"message_thread_id,omitempty"`
+	Text                string              `json:"text"`
+	ParseMode           string              `json:"parse_mode,omitempty"`
+	DisableNotification bool                `json:"disable_notification,omitempty"`
+	LinkPreviewOptions  *LinkPreviewOptions `json:"link_preview_options,omitempty"`
+}
+
+// LinkPreviewOptions 链接预览设置
+type LinkPreviewOptions struct {
+	IsDisabled bool `json:"is_disabled"`
+}
+
+// Response Bot API 的返回结果
+type Response struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Result      struct {
+		MessageID int `json:"message_id"`
+	} `json:"result"`
+}
+
+// NewTelegram init telegram
+func NewTelegram(conf types.Telegram) *Telegram {
+	if conf.APIBaseURL == "" {
+		conf.APIBaseURL = defaultAPIBaseURL
+	}
+	if conf.ParseMode == "" {
+		conf.ParseMode = types.TelegramMarkdownV2
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = time.Second * 30
+	}
+	return &Telegram{
+		Telegram: conf,
+		httpclient: &http.Client{
+			Timeout: conf.Timeout,
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+			},
+		},
+	}
+}
+
+// Send will send notify to telegram chats
+func (t *Telegram) Send(tos []string, title string, content string) (*result.SendResult, error) {
+	return t.SendContext(context.Background(), tos, title, content)
+}
+
+// SendContext will send notify to telegram chats, the request is bound to ctx
+func (t *Telegram) SendContext(ctx context.Context, tos []string, title string, content string) (*result.SendResult, error) {
+	return t.SendMessage(ctx, tos, notify.NewMessage(title, content))
+}
+
+// SendMessage 逐个发送给每个接收人，每个接收人的结果记录在 Recipients 中，
+// 部分接收人发送成功时不返回错误
+func (t *Telegram) SendMessage(ctx context.Context, tos []string, msg *notify.Message) (sendResult *result.SendResult, err error) {
+	sendResult = &result.SendResult{
+		ChannelType:  NotifyTypeTelegram,
+		ChannelMsgID: nil,
+		Success:      false,
+		MessageID:    "",
+		SendTime:     time.Now(),
+		Error:        nil,
+		CostMs:       0,
+	}
+	defer func() {
+		sendResult.CostMs = time.Now().Sub(sendResult.SendTime).Milliseconds()
+		sendResult.MessageID = fmt.Sprintf("%d", time.Now().UnixNano())
+		if sendResult.ChannelMsgID == nil {
+			sendResult.ChannelMsgID = result.PtrOf(sendResult.MessageID)
+		}
+		sendResult.Success = err == nil
+		if err != nil {
+			sendResult.Error = result.PtrOf(err.Error())
+		}
+	}()
+	if len(tos) == 0 {
+		return sendResult, notify.Permanent(errors.New("telegram 接收人不能为空"))
+	}
+	text := t.render(msg)
+	var lastErr error
+	for _, to := range tos {
+		if err = ctx.Err(); err != nil {
+			return sendResult, err
+		}
+		messageID, sendErr := t.sendTo(ctx, to, text, msg)
+		if sendErr != nil {
+			lastErr = fmt.Errorf("发送到 %s 失败: %w", to, sendErr)
+			sendResult.AddRecipient(to, "", sendErr)
+			continue
+		}
+		if sendResult.ChannelMsgID == nil {
+			sendResult.ChannelMsgID = &messageID
+		}
+		sendResult.AddRecipient(to, messageID, nil)
+	}
+	if failed := sendResult.FailedRecipients(); len(failed) == len(tos) {
+		return sendResult, lastErr
+	}
+	return sendResult, nil
+}
+
+// sendTo 发送给一个接收人，返回消息 ID
+func (t *Telegram) sendTo(ctx context.Context, to, text string, msg *notify.Message) (string, error) {
+	chatID, threadID, err := parseRecipient(to)
+	if err != nil {
+		return "", err
+	}
+	if threadID == 0 {
+		threadID = t.MessageThreadID
+	}
+	sendmsg := SendMsg{
+		ChatID:              chatID,
+		MessageThreadID:     threadID,
+		Text:                text,
+		DisableNotification: t.DisableNotification || msg.Priority == notify.PriorityLow,
+	}
+	if t.ParseMode != types.TelegramText {
+		sendmsg.ParseMode = t.ParseMode
+	}
+	if t.DisableLinkPreview {
+		sendmsg.LinkPreviewOptions = &LinkPreviewOptions{IsDisabled: true}
+	}
+	api := strings.TrimRight(t.APIBaseURL, "/") + "/bot" + t.Token + "/sendMessage"
+	resp, err := notify.JSONPostContext(ctx, http.MethodPost, api, sendmsg, t.httpclient, nil)
+	var res Response
+	if jsonErr := json.Unmarshal(resp, &res); jsonErr != nil {
+		if err == nil {
+			return "", fmt.Errorf("invalid telegram response: %s", resp)
+		}
+		// 网络错误的 URL 中包含 token，不能出现在错误信息中
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = strings.Replace(urlErr.URL, t.Token, "<token>", 1)
+		}
+		return "", err
+	}
+	if !res.OK {
+		retryable := res.ErrorCode == http.StatusTooManyRequests || res.ErrorCode >= http.StatusInternalServerError
+		return "", notify.NewError(strconv.Itoa(res.ErrorCode), retryable,
+			fmt.Errorf("description: %s error_code: %d", res.Description, res.ErrorCode))
+	}
+	return strconv.Itoa(res.Result.MessageID), nil
+}
+
+// parseRecipient 解析接收人，支持数字 chat_id、频道的 @username 和 "chat_id:thread_id"
+func parseRecipient(to string) (chatID string, threadID int, err error) {
+	chatID = strings.TrimSpace(to)
+	if id, thread, ok := strings.Cut(chatID, ":"); ok {
+		if threadID, err = strconv.Atoi(thread); err != nil || threadID <= 0 {
+			return "", 0, notify.Permanent(fmt.Errorf("无效的话题 ID: %s", to))
+		}
+		chatID = id
+	}
+	if strings.HasPrefix(chatID, "@") && len(chatID) > 1 {
+		return chatID, threadID, nil
+	}
+	if _, err = strconv.ParseInt(chatID, 10, 64); err != nil {
+		return "", 0, notify.Permanent(fmt.Errorf("无效的 chat_id: %s", to))
+	}
+	return chatID, threadID, nil
+}
+
+// render 按解析模式渲染消息：MarkdownV2 转换 Markdown 正文并转义纯文本，
+// HTML 模式下 HTML 正文需只使用 Telegram 支持的标签，其他正文按纯文本转义
+func (t *Telegram) render(msg *notify.Message) string {
+	var lines []string
+	switch t.ParseMode {
+	case types.TelegramMarkdownV2:
+		if msg.Title != "" {
+			lines = append(lines, "*"+markdown.Escape(msg.Title, markdown.Telegram)+"*")
+		}
+		if msg.Markdown != "" {
+			lines = append(lines, markdown.Convert(msg.Markdown, markdown.Telegram))
+		} else {
+			lines = append(lines, markdown.Escape(msg.TextBody(), markdown.Telegram))
+		}
+		for _, link := range msg.Links {
+			lines = append(lines, markdown.Render(linkDoc(link), markdown.Telegram))
+		}
+	case types.TelegramHTML:
+		if msg.Title != "" {
+			lines = append(lines, "<b>"+html.EscapeString(msg.Title)+"</b>")
+		}
+		switch {
+		case msg.HTML != "":
+			lines = append(lines, msg.HTML)
+		case msg.Markdown != "":
+			lines = append(lines, html.EscapeString(markdown.Convert(msg.Markdown, markdown.Plain)))
+		default:
+			lines = append(lines, html.EscapeString(msg.TextBody()))
+		}
+		for _, link := range msg.Links {
+			lines = append(lines, fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(link.URL), html.EscapeString(link.Text)))
+		}
+	default:
+		if msg.Title != "" {
+			lines = append(lines, msg.Title)
+		}
+		if msg.Markdown != "" {
+			lines = append(lines, markdown.Convert(msg.Markdown, markdown.Plain))
+		} else {
+			lines = append(lines, msg.TextBody())
+		}
+		for _, link := range msg.Links {
+			lines = append(lines, link.Text+": "+link.URL)
+		}
+	}
+	if ats := mentions(msg.Mentions, t.ParseMode); ats != "" {
+		lines = append(lines, ats)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// mentions 数字用户 ID 使用 tg://user 链接提及，其他按 @username 提及；Telegram 不支持 @所有人
+func mentions(m notify.Mentions, parseMode string) string {
+	var ats []string
+	for _, u := range m.Users {
+		u = strings.TrimPrefix(u, "@")
+		if _, err := strconv.ParseInt(u, 10, 64); err != nil {
+			ats = append(ats, markdown.Escape("@"+u, dialect(parseMode)))
+			continue
+		}
+		switch parseMode {
+		case types.TelegramMarkdownV2:
+			ats = append(ats, markdown.Render(linkDoc(notify.Link{Text: u, URL: "tg://user?id=" + u}), markdown.Telegram))
+		case types.TelegramHTML:
+			ats = append(ats, fmt.Sprintf(`<a href="tg://user?id=%s">%s</a>`, u, u))
+		default:
+			ats = append(ats, u)
+		}
+	}
+	return strings.Join(ats, " ")
+}
+
+func dialect(parseMode string) markdown.Dialect {
+	switch parseMode {
+	case types.TelegramMarkdownV2:
+		return markdown.Telegram
+	case types.TelegramHTML:
+		return markdown.HTML
+	}
+	return markdown.Plain
+}
+
+// linkDoc 只包含一个链接的文档，由 markdown 包负责转义链接文字和地址
+func linkDoc(link notify.Link) *markdown.Node {
+	text := &markdown.Node{Kind: markdown.Text, Text: link.Text}
+	a := &markdown.Node{Kind: markdown.Link, URL: link.URL, Children: []*markdown.Node{text}}
+	p := &markdown.Node{Kind: markdown.Paragraph, Children: []*markdown.Node{a}}
+	return &markdown.Node{Kind: markdown.Document, Children: []*markdown.Node{p}}
+}
+
+const NotifyTypeTelegram = "telegram"
+
+func init() {
+	notify.Register(NotifyTypeTelegram, func(section notify.Section) (notify.Sender, error) {
+		var conf types.Telegram
+		if err := section.Decode(&conf); err != nil {
+			return nil, err
+		}
+		if conf.Token == "" {
+			return nil, errors.New("telegram token 不能为空")
+		}
+		return NewTelegram(conf), nil
+	})
+}
+
+func (t *Telegram) ChannelType() string {
+	return NotifyTypeTelegram
+}
@@ -0,0 +1,91 @@
+package telegram
+
+import (
+	"encoding/json"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTelegramSendMessage(t *testing.T) {
+	var got []SendMsg
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bottoken/sendMessage" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		var m SendMsg
+		_ = json.NewDecoder(r.Body).Decode(&m)
+		got = append(got, m)
+		if m.ChatID == "-100" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":7}}`))
+	}))
+	defer srv.Close()
+
+	tg := NewTelegram(types.Telegram{Token: "token", APIBaseURL: srv.URL + "/", MessageThreadID: 3})
+	msg := &notify.Message{
+		Title:    "v1.2 done",
+		Markdown: "**ok**",
+		Links:    []notify.Link{{Text: "log", URL: "https://x.io/a_(b)"}},
+		Priority: notify.PriorityLow,
+	}
+	r, err := tg.SendMessage(t.Context(), []string{"123", "@ops:9", "-100", "bad id"}, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d requests, want 3", len(got))
+	}
+	want := "*v1\\.2 done*\n*ok*\n[log](https://x.io/a_(b\\))"
+	if m := got[0]; m.Text != want || m.ParseMode != "MarkdownV2" || !m.DisableNotification || m.MessageThreadID != 3 {
+		t.Errorf("request = %+v", m)
+	}
+	if m := got[1]; m.ChatID != "@ops" || m.MessageThreadID != 9 {
+		t.Errorf("request = %+v", m)
+	}
+	if len(r.Recipients) != 4 || !r.Recipients[0].Success || *r.Recipients[0].ChannelMsgID != "7" {
+		t.Errorf("recipients = %+v", r.Recipients)
+	}
+	if failed := r.FailedRecipients(); len(failed) != 2 || failed[0] != "-100" || failed[1] != "bad id" {
+		t.Errorf("failed = %v", failed)
+	}
+
+	// 全部失败时返回错误，错误码和分类来自接口返回
+	_, err = tg.SendMessage(t.Context(), []string{"-100"}, msg)
+	if err == nil || notify.IsRetryable(err) || !strings.Contains(err.Error(), "chat not found") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestTelegramRender(t *testing.T) {
+	msg := &notify.Message{
+		Title:    "a < b",
+		Text:     "1. x_y",
+		Mentions: notify.Mentions{Users: []string{"42", "@alice"}},
+	}
+	tests := map[string]string{
+		types.TelegramMarkdownV2: "*a < b*\n1\\. x\\_y\n[42](tg://user?id=42) @alice",
+		types.TelegramHTML:       "<b>a &lt; b</b>\n1. x_y\n<a href=\"tg://user?id=42\">42</a> @alice",
+		types.TelegramText:       "a < b\n1. x_y\n42 @alice",
+	}
+	for mode, want := range tests {
+		tg := NewTelegram(types.Telegram{Token: "token", ParseMode: mode})
+		if got := tg.render(msg); got != want {
+			t.Errorf("%s:\n got %q\nwant %q", mode, got, want)
+		}
+	}
+}
+
+func TestTelegramRedactsToken(t *testing.T) {
+	tg := NewTelegram(types.Telegram{Token: "secret-token", APIBaseURL: "http://127.0.0.1:1"})
+	_, err := tg.Send([]string{"1"}, "t", "c")
+	if err == nil || strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("err = %v", err)
+	}
+}
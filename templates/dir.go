@@ -0,0 +1,104 @@
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/v-mars/notify/types"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// metaFile 模板目录中的可选配置文件，内容为 types.Template 的 JSON
+const metaFile = "template.json"
+
+// LoadDir 读取模板目录，每个子目录是一个模板，目录名为模板 ID：
+//
+//	<dir>/<id>/template.json          可选，声明 vars、params 等，格式同 types.Template
+//	<dir>/<id>/title.tmpl             默认正文，另有 text.tmpl、markdown.tmpl、html.tmpl
+//	<dir>/<id>/<channel>.html.tmpl    渠道覆盖的正文，channel 为渠道类型或实例名，如 email.html.tmpl
+//
+// 同一字段同时在 template.json 和 .tmpl 文件中出现时报错
+func LoadDir(dir string) (map[string]*types.Template, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("templates: %w", err)
+	}
+	out := map[string]*types.Template{}
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		t, err := loadTemplateDir(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		out[e.Name()] = t
+	}
+	return out, nil
+}
+
+func loadTemplateDir(dir string) (*types.Template, error) {
+	t := &types.Template{}
+	if data, err := os.ReadFile(filepath.Join(dir, metaFile)); err == nil {
+		if err := json.Unmarshal(data, t); err != nil {
+			return nil, fmt.Errorf("templates: %s: %w", filepath.Join(dir, metaFile), err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("templates: %w", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, fmt.Errorf("templates: %w", err)
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".tmpl")
+		channel, field := "", name
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			channel, field = name[:i], name[i+1:]
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("templates: %w", err)
+		}
+		b := &t.TemplateBody
+		var over types.TemplateBody
+		if channel != "" {
+			if t.Channels == nil {
+				t.Channels = map[string]types.TemplateBody{}
+			}
+			over = t.Channels[channel]
+			b = &over
+		}
+		if err := setField(b, field, string(data)); err != nil {
+			return nil, fmt.Errorf("templates: %s: %w", file, err)
+		}
+		if channel != "" {
+			t.Channels[channel] = over
+		}
+	}
+	return t, nil
+}
+
+func setField(b *types.TemplateBody, field, src string) error {
+	var dst *string
+	switch field {
+	case "title":
+		dst = &b.Title
+		src = strings.TrimRight(src, "\r\n")
+	case "text":
+		dst = &b.Text
+	case "markdown":
+		dst = &b.Markdown
+	case "html":
+		dst = &b.HTML
+	default:
+		return fmt.Errorf("unknown field %q", field)
+	}
+	if *dst != "" {
+		return fmt.Errorf("%s is also set in %s", field, metaFile)
+	}
+	*dst = src
+	return nil
+}
@@ -0,0 +1,91 @@
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Funcs 模板中可用的辅助函数，参数顺序便于在管道中使用，如 {{.name | default "-"}}、{{.at | date "2006-01-02"}}
+var Funcs = map[string]any{
+	"default":  defaultValue,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"trim":     strings.TrimSpace,
+	"join":     join,
+	"truncate": truncate,
+	"date":     date,
+	"json":     toJSON,
+}
+
+// defaultValue v 为空值时返回 def
+func defaultValue(def, v any) any {
+	if v == nil {
+		return def
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if rv.Len() == 0 {
+			return def
+		}
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return def
+		}
+	}
+	return v
+}
+
+// join 用 sep 连接切片中的元素，{{join .users ", "}}
+func join(v any, sep string) string {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return fmt.Sprint(v)
+	}
+	items := make([]string, rv.Len())
+	for i := range items {
+		items[i] = fmt.Sprint(rv.Index(i).Interface())
+	}
+	return strings.Join(items, sep)
+}
+
+// truncate 截断到 n 个字符，超出时以 "..." 结尾
+func truncate(n int, s string) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	if n <= 3 {
+		return string(runes[:n])
+	}
+	return string(runes[:n-3]) + "..."
+}
+
+// date 按 layout 格式化时间，支持 time.Time、*time.Time 和 Unix 秒，其他类型原样输出
+func date(layout string, v any) string {
+	switch t := v.(type) {
+	case time.Time:
+		return t.Format(layout)
+	case *time.Time:
+		if t == nil {
+			return ""
+		}
+		return t.Format(layout)
+	case int64:
+		return time.Unix(t, 0).Format(layout)
+	case int:
+		return time.Unix(int64(t), 0).Format(layout)
+	case float64:
+		return time.Unix(int64(t), 0).Format(layout)
+	}
+	return fmt.Sprint(v)
+}
+
+func toJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
@@ -0,0 +1,344 @@
+package templates
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/types"
+	htemplate "html/template"
+	"sort"
+	"strings"
+	ttemplate "text/template"
+	"text/template/parse"
+)
+
+// ErrNotFound 模板不存在
+var ErrNotFound = errors.New("templates: template not found")
+
+// smsChannelType 只有短信渠道使用 Params
+const smsChannelType = "sms"
+
+// Registry 已加载并校验的模板集合，可以被多个 goroutine 同时使用
+type Registry struct {
+	templates map[string]*compiled
+}
+
+type compiled struct {
+	id       string
+	vars     []string
+	def      *body
+	channels map[string]*body
+}
+
+// body 编译后的正文，字段为 nil 时使用默认正文
+type body struct {
+	title    *ttemplate.Template
+	text     *ttemplate.Template
+	markdown *ttemplate.Template
+	html     *htemplate.Template
+	params   map[string]*ttemplate.Template
+}
+
+// Load 加载配置中的模板和模板目录，所有模板都通过校验后才返回
+func Load(conf *types.TemplateConfig) (*Registry, error) {
+	all := map[string]*types.Template{}
+	if conf == nil {
+		return New(all)
+	}
+	for id, t := range conf.Templates {
+		all[id] = t
+	}
+	if conf.Dir != "" {
+		dirTemplates, err := LoadDir(conf.Dir)
+		if err != nil {
+			return nil, err
+		}
+		for id, t := range dirTemplates {
+			if _, ok := all[id]; ok {
+				return nil, fmt.Errorf("templates: duplicate template %q in config and %s", id, conf.Dir)
+			}
+			all[id] = t
+		}
+	}
+	return New(all)
+}
+
+// New 编译并校验模板：语法错误、没有任何正文、引用了未在 Vars 中声明的变量都会返回错误
+func New(templates map[string]*types.Template) (*Registry, error) {
+	r := &Registry{templates: make(map[string]*compiled, len(templates))}
+	var errs []error
+	for id, t := range templates {
+		c, err := compile(id, t)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		r.templates[id] = c
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return r, nil
+}
+
+// IDs 返回所有模板 ID，按字母排序
+func (r *Registry) IDs() []string {
+	var ids []string
+	for id := range r.templates {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Render 使用渠道对应的正文渲染模板，渠道依次按实例名、渠道类型查找覆盖的正文
+// 短信渠道有 Params 时，渲染后的参数 JSON 作为消息的纯文本正文
+func (r *Registry) Render(id, channel string, vars map[string]any) (*notify.Message, error) {
+	c, ok := r.templates[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	var missing []string
+	for _, v := range c.vars {
+		if _, ok := vars[v]; !ok {
+			missing = append(missing, v)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("templates: %s missing vars %v", id, missing)
+	}
+
+	over := c.channels[channel]
+	if over == nil {
+		over = c.channels[types.ChannelTypeOf(channel)]
+	}
+	if over == nil {
+		over = &body{}
+	}
+	msg := &notify.Message{}
+	var err error
+	if msg.Title, err = execText(pick(over.title, c.def.title), vars); err != nil {
+		return nil, fmt.Errorf("templates: %s title: %w", id, err)
+	}
+	if msg.Text, err = execText(pick(over.text, c.def.text), vars); err != nil {
+		return nil, fmt.Errorf("templates: %s text: %w", id, err)
+	}
+	if msg.Markdown, err = execText(pick(over.markdown, c.def.markdown), vars); err != nil {
+		return nil, fmt.Errorf("templates: %s markdown: %w", id, err)
+	}
+	html := over.html
+	if html == nil {
+		html = c.def.html
+	}
+	if html != nil {
+		var buf bytes.Buffer
+		if err = html.Execute(&buf, vars); err != nil {
+			return nil, fmt.Errorf("templates: %s html: %w", id, err)
+		}
+		msg.HTML = buf.String()
+	}
+	if types.ChannelTypeOf(channel) == smsChannelType {
+		params := over.params
+		if params == nil {
+			params = c.def.params
+		}
+		if params != nil {
+			if msg.Text, err = execParams(params, vars); err != nil {
+				return nil, fmt.Errorf("templates: %s params: %w", id, err)
+			}
+		}
+	}
+	return msg, nil
+}
+
+func pick(over, def *ttemplate.Template) *ttemplate.Template {
+	if over != nil {
+		return over
+	}
+	return def
+}
+
+func execText(t *ttemplate.Template, vars map[string]any) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func execParams(params map[string]*ttemplate.Template, vars map[string]any) (string, error) {
+	values := make(map[string]string, len(params))
+	for k, t := range params {
+		v, err := execText(t, vars)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", k, err)
+		}
+		values[k] = v
+	}
+	// 短信参数不是 HTML，不转义 <、>、&
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(values); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func compile(id string, t *types.Template) (*compiled, error) {
+	if t == nil {
+		return nil, fmt.Errorf("templates: %s is empty", id)
+	}
+	c := &compiled{id: id, vars: t.Vars, channels: make(map[string]*body, len(t.Channels))}
+	refs := map[string]bool{}
+	var err error
+	if c.def, err = compileBody(id, "", t.TemplateBody, refs); err != nil {
+		return nil, err
+	}
+	hasBody := hasContent(t.TemplateBody)
+	for channel, b := range t.Channels {
+		if c.channels[channel], err = compileBody(id, channel, b, refs); err != nil {
+			return nil, err
+		}
+		hasBody = hasBody || hasContent(b)
+	}
+	if !hasBody {
+		return nil, fmt.Errorf("templates: %s has no body", id)
+	}
+	if len(t.Vars) > 0 {
+		declared := map[string]bool{}
+		for _, v := range t.Vars {
+			declared[v] = true
+		}
+		var undeclared []string
+		for ref := range refs {
+			if !declared[ref] {
+				undeclared = append(undeclared, ref)
+			}
+		}
+		if len(undeclared) > 0 {
+			sort.Strings(undeclared)
+			return nil, fmt.Errorf("templates: %s uses undeclared vars %v", id, undeclared)
+		}
+	}
+	return c, nil
+}
+
+func hasContent(b types.TemplateBody) bool {
+	return b.Text != "" || b.Markdown != "" || b.HTML != "" || len(b.Params) > 0
+}
+
+// compileBody 编译正文的每个字段，并收集引用的顶层变量
+func compileBody(id, channel string, b types.TemplateBody, refs map[string]bool) (*body, error) {
+	name := id
+	if channel != "" {
+		name += "/" + channel
+	}
+	out := &body{}
+	var err error
+	for _, f := range []struct {
+		field string
+		src   string
+		dst   **ttemplate.Template
+	}{
+		{"title", b.Title, &out.title},
+		{"text", b.Text, &out.text},
+		{"markdown", b.Markdown, &out.markdown},
+	} {
+		if f.src == "" {
+			continue
+		}
+		if *f.dst, err = parseText(name+"/"+f.field, f.src, refs); err != nil {
+			return nil, err
+		}
+	}
+	if b.HTML != "" {
+		out.html, err = htemplate.New(name + "/html").Funcs(Funcs).Option("missingkey=error").Parse(b.HTML)
+		if err != nil {
+			return nil, fmt.Errorf("templates: %w", err)
+		}
+		collectRefs(out.html.Tree.Root, true, refs)
+	}
+	if len(b.Params) > 0 {
+		out.params = make(map[string]*ttemplate.Template, len(b.Params))
+		for k, src := range b.Params {
+			if out.params[k], err = parseText(name+"/params/"+k, src, refs); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+func parseText(name, src string, refs map[string]bool) (*ttemplate.Template, error) {
+	t, err := ttemplate.New(name).Funcs(Funcs).Option("missingkey=error").Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("templates: %w", err)
+	}
+	collectRefs(t.Tree.Root, true, refs)
+	return t, nil
+}
+
+// collectRefs 收集模板引用的顶层变量（.name 或 $.name），range 和 with 内部的 . 不是顶层变量
+func collectRefs(node parse.Node, top bool, refs map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			collectRefs(c, top, refs)
+		}
+	case *parse.ActionNode:
+		collectRefs(n.Pipe, top, refs)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			collectRefs(cmd, top, refs)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			collectRefs(arg, top, refs)
+		}
+	case *parse.ChainNode:
+		collectRefs(n.Node, top, refs)
+	case *parse.FieldNode:
+		if top {
+			refs[n.Ident[0]] = true
+		}
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" && len(n.Ident) > 1 {
+			refs[n.Ident[1]] = true
+		}
+	case *parse.IfNode:
+		collectRefs(n.Pipe, top, refs)
+		collectRefs(n.List, top, refs)
+		collectRefs(n.ElseList, top, refs)
+	case *parse.RangeNode:
+		collectRefs(n.Pipe, top, refs)
+		collectRefs(n.List, false, refs)
+		collectRefs(n.ElseList, top, refs)
+	case *parse.WithNode:
+		collectRefs(n.Pipe, top, refs)
+		collectRefs(n.List, false, refs)
+		collectRefs(n.ElseList, top, refs)
+	case *parse.TemplateNode:
+		collectRefs(n.Pipe, top, refs)
+	}
+}
+
+// Vars 返回模板声明的变量
+func (r *Registry) Vars(id string) ([]string, bool) {
+	c, ok := r.templates[id]
+	if !ok {
+		return nil, false
+	}
+	return append([]string(nil), c.vars...), true
+}
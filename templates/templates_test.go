@@ -0,0 +1,166 @@
+package templates
+
+import (
+	"errors"
+	"github.com/v-mars/notify/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	r, err := New(map[string]*types.Template{
+		"deploy": {
+			TemplateBody: types.TemplateBody{
+				Title:    "{{.app}} 发布{{if .ok}}成功{{else}}失败{{end}}",
+				Text:     "{{.app}} {{.version}} by {{join .users \", \"}}",
+				Markdown: "**{{.app}}** `{{.version}}`",
+			},
+			Vars: []string{"app", "version", "ok", "users", "at"},
+			Channels: map[string]types.TemplateBody{
+				"email":    {HTML: "<b>{{.app}}</b> {{.at | date \"2006-01-02\"}}"},
+				"sms":      {Params: map[string]string{"app": "{{.app}}", "ver": "{{.version | truncate 5}}"}},
+				"lark:ops": {Markdown: "ops: {{.app}}"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]any{
+		"app":     "<api>",
+		"version": "v1.2.3-rc1",
+		"ok":      true,
+		"users":   []string{"alice", "bob"},
+		"at":      time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		channel  string
+		text     string
+		markdown string
+		html     string
+	}{
+		{"lark", "<api> v1.2.3-rc1 by alice, bob", "**<api>** `v1.2.3-rc1`", ""},
+		{"lark:ops", "<api> v1.2.3-rc1 by alice, bob", "ops: <api>", ""},
+		{"email:corp", "<api> v1.2.3-rc1 by alice, bob", "**<api>** `v1.2.3-rc1`", "<b>&lt;api&gt;</b> 2024-05-01"},
+		{"sms", `{"app":"<api>","ver":"v1..."}`, "**<api>** `v1.2.3-rc1`", ""},
+	}
+	for _, tt := range tests {
+		msg, err := r.Render("deploy", tt.channel, vars)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.channel, err)
+		}
+		if msg.Title != "<api> 发布成功" {
+			t.Errorf("%s: Title = %q", tt.channel, msg.Title)
+		}
+		if msg.Text != tt.text || msg.Markdown != tt.markdown || msg.HTML != tt.html {
+			t.Errorf("%s: got text %q markdown %q html %q, want %q %q %q",
+				tt.channel, msg.Text, msg.Markdown, msg.HTML, tt.text, tt.markdown, tt.html)
+		}
+	}
+
+	if _, err := r.Render("deploy", "lark", map[string]any{"app": "api"}); err == nil {
+		t.Error("Render with missing vars: want error")
+	}
+	if _, err := r.Render("nope", "lark", vars); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Render unknown template: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		tmpl *types.Template
+		want string
+	}{
+		{"syntax", &types.Template{TemplateBody: types.TemplateBody{Text: "{{.a"}}, "unclosed action"},
+		{"empty", &types.Template{TemplateBody: types.TemplateBody{Title: "t"}}, "no body"},
+		{"undeclared", &types.Template{
+			TemplateBody: types.TemplateBody{Text: "{{.a}} {{range .items}}{{.name}}{{end}} {{$.b}}"},
+			Vars:         []string{"a", "items"},
+		}, "undeclared vars [b]"},
+		{"unknown func", &types.Template{TemplateBody: types.TemplateBody{Text: "{{nope .a}}"}}, "not defined"},
+	}
+	for _, tt := range tests {
+		_, err := New(map[string]*types.Template{tt.name: tt.tmpl})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestRenderMissingKey(t *testing.T) {
+	r, err := New(map[string]*types.Template{
+		"t": {TemplateBody: types.TemplateBody{Text: "{{.name | default \"-\"}} {{.host}}"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Render("t", "lark", map[string]any{"name": ""}); err == nil {
+		t.Error("Render without host: want error")
+	}
+	msg, err := r.Render("t", "lark", map[string]any{"name": "", "host": "h1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Text != "- h1" {
+		t.Errorf("Text = %q, want %q", msg.Text, "- h1")
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"alert/template.json":          `{"vars":["name"],"channels":{"sms":{"params":{"name":"{{.name}}"}}}}`,
+		"alert/title.tmpl":             "告警 {{.name}}\n",
+		"alert/text.tmpl":              "{{.name}} 异常",
+		"alert/email.html.tmpl":        "<p>{{.name}}</p>",
+		"alert/lark:ops.markdown.tmpl": "**{{.name}}**",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := Load(&types.TemplateConfig{
+		Dir: dir,
+		Templates: map[string]*types.Template{
+			"ping": {TemplateBody: types.TemplateBody{Text: "pong"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := r.IDs(); strings.Join(ids, ",") != "alert,ping" {
+		t.Errorf("IDs = %v", ids)
+	}
+	vars := map[string]any{"name": "db1"}
+	msg, err := r.Render("alert", "email", vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Title != "告警 db1" || msg.Text != "db1 异常" || msg.HTML != "<p>db1</p>" {
+		t.Errorf("email message = %+v", msg)
+	}
+	if msg, _ = r.Render("alert", "lark:ops", vars); msg.Markdown != "**db1**" {
+		t.Errorf("lark:ops Markdown = %q", msg.Markdown)
+	}
+	if msg, _ = r.Render("alert", "sms:aliyun", vars); msg.Text != `{"name":"db1"}` {
+		t.Errorf("sms Text = %q", msg.Text)
+	}
+
+	if _, err := Load(&types.TemplateConfig{
+		Dir:       dir,
+		Templates: map[string]*types.Template{"alert": {TemplateBody: types.TemplateBody{Text: "x"}}},
+	}); err == nil {
+		t.Error("Load with duplicate id: want error")
+	}
+}
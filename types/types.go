@@ -1,6 +1,8 @@
 package types
 
 import (
+	"encoding/json"
+	"strings"
 	"time"
 )
 
@@ -13,8 +15,207 @@ type NotifyConfig struct {
 	Lark     *Lark        `json:"lark" yaml:"lark"`
 	Ding     *DingDing    `json:"dingding" yaml:"dingding"`
 	Wecom    *WecomConfig `json:"wecom" yaml:"wecom"`
-	Sms      *SmsConfig   `json:"sms" yaml:"sms"`
-	Webhook  *Webhook     `json:"webhook" yaml:"webhook"`
+	// WecomRobot 企业微信群机器人
+	WecomRobot *WecomRobot `json:"wecom_robot" yaml:"wecom_robot"`
+	Sms        *SmsConfig  `json:"sms" yaml:"sms"`
+	Webhook    *Webhook    `json:"webhook" yaml:"webhook"`
+	Slack      *Slack      `json:"slack" yaml:"slack"`
+	Telegram   *Telegram   `json:"telegram" yaml:"telegram"`
+	// Custom 第三方渠道的配置段，key 为渠道类型
+	Custom map[string]ChannelSection `json:"custom" yaml:"custom"`
+	// Instances 具名渠道实例的配置段，key 为 "渠道类型:实例名"，如 "lark:ops"、"email:alert"，
+	// 实例名可以直接用在 Channels 和 SendOptions.Channels 中
+	Instances map[string]ChannelSection `json:"instances" yaml:"instances"`
+	// Retry 各渠道的重试策略，key 依次按渠道实例名、渠道类型、"default" 查找，未配置时不重试
+	Retry map[string]*RetryPolicy `json:"retry" yaml:"retry"`
+	// RateLimit 各渠道的限流配置，key 依次按渠道实例名、渠道类型、"default" 查找，
+	// 都未配置时使用内置渠道的默认限流（见 ratelimit.Defaults），配置为空的 RateLimit 表示不限流
+	RateLimit map[string]*RateLimit `json:"rate_limit" yaml:"rate_limit"`
+	// SizeLimit 各渠道的消息长度限制，key 依次按渠道实例名、渠道类型、"default" 查找，
+	// 都未配置时使用内置渠道的默认限制（见 sizelimit.Defaults），配置为空的 SizeLimit 表示不限制
+	SizeLimit map[string]*SizeLimit `json:"size_limit" yaml:"size_limit"`
+	// Breaker 各渠道的熔断配置，key 依次按渠道实例名、渠道类型、"default" 查找，未配置时不熔断
+	Breaker map[string]*BreakerConfig `json:"breaker" yaml:"breaker"`
+	// Fallback 默认的渠道降级链，调用方未指定渠道时使用，配置后替代 Channels 的并行发送
+	Fallback *FallbackPolicy `json:"fallback" yaml:"fallback"`
+	// Outbox 持久化发件箱配置
+	Outbox *OutboxConfig `json:"outbox" yaml:"outbox"`
+	// Templates 消息模板配置
+	Templates *TemplateConfig `json:"templates" yaml:"templates"`
+}
+
+// TemplateConfig 消息模板配置，Dir 中的模板与 Templates 中的模板合并，ID 重复时报错
+type TemplateConfig struct {
+	// Dir 模板目录，每个子目录是一个模板，目录名为模板 ID，目录结构见 templates 包
+	Dir string `json:"dir" yaml:"dir"`
+	// Templates 直接写在配置中的模板，key 为模板 ID
+	Templates map[string]*Template `json:"templates" yaml:"templates"`
+}
+
+// Template 命名模板，默认正文用于所有渠道，Channels 按渠道实例名或渠道类型覆盖部分正文
+type Template struct {
+	TemplateBody `yaml:",inline"`
+	// Vars 模板需要的变量，声明后加载时校验模板只引用了这些变量，发送时校验变量齐全
+	Vars []string `json:"vars" yaml:"vars"`
+	// Channels 各渠道的正文，如 email 使用 HTML、dingding 使用 Markdown、sms 使用 Params
+	Channels map[string]TemplateBody `json:"channels" yaml:"channels"`
+}
+
+// TemplateBody 模板正文，字段为空时使用默认正文的同名字段
+// Title、Text、Markdown 使用 text/template 渲染，HTML 使用 html/template 渲染
+type TemplateBody struct {
+	Title    string `json:"title" yaml:"title"`
+	Text     string `json:"text" yaml:"text"`
+	Markdown string `json:"markdown" yaml:"markdown"`
+	HTML     string `json:"html" yaml:"html"`
+	// Params 短信模板参数，每个值是一个 text/template，渲染后编码为 JSON 作为短信的 TemplateParam
+	Params map[string]string `json:"params" yaml:"params"`
+}
+
+// OutboxConfig 持久化发件箱配置，消息先写入本地目录再由后台 worker 投递
+type OutboxConfig struct {
+	Dir           string        `json:"dir" yaml:"dir"`                       // 存储目录
+	Workers       int           `json:"workers" yaml:"workers"`               // 并发投递的 worker 数量，默认 1
+	PollInterval  time.Duration `json:"poll_interval" yaml:"poll_interval"`   // 扫描间隔，默认 1s
+	MaxDeliveries int           `json:"max_deliveries" yaml:"max_deliveries"` // 最大投递次数，达到后移入死信，默认 5
+	KeepDone      bool          `json:"keep_done" yaml:"keep_done"`           // 是否保留已投递的记录
+}
+
+const (
+	// FallbackFirstSuccess 按顺序尝试，第一个渠道发送成功后停止
+	FallbackFirstSuccess = "first_success"
+	// FallbackAll 按顺序发送到链上的所有渠道
+	FallbackAll = "all"
+)
+
+// FallbackPolicy 渠道降级链，如 wecom 失败后发短信，短信失败后发邮件
+type FallbackPolicy struct {
+	// Chain 按顺序尝试的渠道，可以是渠道类型或具名实例
+	Chain []string `json:"chain" yaml:"chain"`
+	// StepTimeout 每一步的超时时间，0 表示不单独限制
+	StepTimeout time.Duration `json:"step_timeout" yaml:"step_timeout"`
+	// Mode 降级模式：first_success（默认）或 all
+	Mode string `json:"mode" yaml:"mode"`
+}
+
+// RetryPolicy 重试策略，只有被判定为临时错误的失败才会重试
+type RetryPolicy struct {
+	// MaxAttempts 最大尝试次数（含首次发送），小于等于 1 时不重试
+	MaxAttempts int `json:"max_attempts" yaml:"max_attempts"`
+	// InitialBackoff 首次重试前的等待时间，默认 500ms
+	InitialBackoff time.Duration `json:"initial_backoff" yaml:"initial_backoff"`
+	// MaxBackoff 单次等待时间上限，默认 30s
+	MaxBackoff time.Duration `json:"max_backoff" yaml:"max_backoff"`
+	// Multiplier 每次重试等待时间的倍数，默认 2
+	Multiplier float64 `json:"multiplier" yaml:"multiplier"`
+	// Jitter 等待时间的随机抖动比例，取值 0~1，如 0.2 表示在 ±20% 范围内随机
+	Jitter float64 `json:"jitter" yaml:"jitter"`
+	// BudgetMaxTokens 重试预算的令牌上限，每次失败消耗 1 个令牌，令牌低于上限的一半时停止重试；0 表示不限制
+	BudgetMaxTokens float64 `json:"budget_max_tokens" yaml:"budget_max_tokens"`
+	// BudgetTokenRatio 每次成功归还的令牌数，默认 0.1
+	BudgetTokenRatio float64 `json:"budget_token_ratio" yaml:"budget_token_ratio"`
+}
+
+// BreakerConfig 熔断配置，只有可重试的临时错误（网络错误、超时、5xx、限流）计为失败
+type BreakerConfig struct {
+	// FailureThreshold 连续失败多少次后熔断，默认 5
+	FailureThreshold int `json:"failure_threshold" yaml:"failure_threshold"`
+	// OpenTimeout 熔断后多久进入半开状态试探，默认 30s
+	OpenTimeout time.Duration `json:"open_timeout" yaml:"open_timeout"`
+	// HalfOpenMaxRequests 半开状态下同时允许的试探请求数，默认 1
+	HalfOpenMaxRequests int `json:"half_open_max_requests" yaml:"half_open_max_requests"`
+	// SuccessThreshold 半开状态下连续成功多少次后恢复，默认 1
+	SuccessThreshold int `json:"success_threshold" yaml:"success_threshold"`
+	// Fallback 熔断期间改发的渠道，为空时快速失败
+	Fallback string `json:"fallback" yaml:"fallback"`
+}
+
+const (
+	// RateLimitBlock 等待令牌，直到 ctx 结束
+	RateLimitBlock = "block"
+	// RateLimitQueue 按到达顺序排队等待，排队数超过 QueueSize 或等待时间超过 MaxWait 时拒绝
+	RateLimitQueue = "queue"
+	// RateLimitReject 没有令牌时立即拒绝
+	RateLimitReject = "reject"
+)
+
+// RateBand 令牌桶规则：每 Per 时间内最多 Limit 条，Burst 为桶容量，默认等于 Limit
+type RateBand struct {
+	Limit int           `json:"limit" yaml:"limit"`
+	Per   time.Duration `json:"per" yaml:"per"`
+	Burst int           `json:"burst" yaml:"burst"`
+}
+
+// RateLimit 渠道实例的限流配置，多条规则需要同时满足，如每秒 5 条且每分钟 100 条
+type RateLimit struct {
+	// Bands 按渠道实例限流的规则，为空时不限制
+	Bands []RateBand `json:"bands" yaml:"bands"`
+	// Recipient 按接收人限流的规则，如短信同一手机号每分钟 1 条，为空时不限制
+	Recipient []RateBand `json:"recipient" yaml:"recipient"`
+	// Mode 达到限制时的处理方式：block（默认）、queue 或 reject
+	Mode string `json:"mode" yaml:"mode"`
+	// MaxWait queue 模式下的最长等待时间，0 表示不限制
+	MaxWait time.Duration `json:"max_wait" yaml:"max_wait"`
+	// QueueSize queue 模式下最多排队的请求数，0 表示不限制
+	QueueSize int `json:"queue_size" yaml:"queue_size"`
+}
+
+const (
+	SizeLimitTruncate = "truncate" // 截断正文并附加“查看更多”链接
+	SizeLimitSplit    = "split"    // 拆分为多条带编号的消息
+)
+
+const (
+	SizeUnitByte = "byte" // 按 UTF-8 字节计算长度
+	SizeUnitChar = "char" // 按字符（Unicode 码点）计算长度
+)
+
+// SizeLimit 渠道实例的消息长度限制，长度默认按 UTF-8 字节计算，见 Unit
+type SizeLimit struct {
+	// Text 纯文本正文的最大长度，0 表示不限制
+	Text int `json:"text" yaml:"text"`
+	// Markdown Markdown 正文的最大长度，按转换为 Dialect 后的长度计算，0 表示不限制
+	Markdown int `json:"markdown" yaml:"markdown"`
+	// Unit Text 和 Markdown 的长度单位：byte（默认）或 char
+	Unit string `json:"unit" yaml:"unit"`
+	// Dialect 渠道的 Markdown 方言，见 markdown.Dialect
+	Dialect string `json:"dialect" yaml:"dialect"`
+	// Param 短信模板每个变量的最大字符数，0 表示不限制
+	Param int `json:"param" yaml:"param"`
+	// Mode 超长时的处理方式：truncate（默认）或 split
+	Mode string `json:"mode" yaml:"mode"`
+	// MaxParts split 模式下最多拆分的条数，超出的部分截断，默认 10
+	MaxParts int `json:"max_parts" yaml:"max_parts"`
+	// MoreURL 截断时“查看更多”链接的默认地址，消息设置了 MoreURL 时使用消息的地址
+	MoreURL string `json:"more_url" yaml:"more_url"`
+	// MoreText “查看更多”链接的文字，默认为“查看更多”
+	MoreText string `json:"more_text" yaml:"more_text"`
+}
+
+// SplitChannelName 拆分渠道名称，"lark:ops" 返回 "lark" 和 "ops"，"lark" 返回 "lark" 和 ""
+func SplitChannelName(name string) (channelType, instance string) {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// ChannelTypeOf 返回渠道名称对应的渠道类型
+func ChannelTypeOf(name string) string {
+	channelType, _ := SplitChannelName(name)
+	return channelType
+}
+
+// ChannelSection 渠道配置段的原始键值，由渠道工厂解码为自己的配置结构体
+type ChannelSection map[string]any
+
+// Decode 按 json 标签将配置段解码到 v
+func (s ChannelSection) Decode(v any) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
 }
 
 // EmailConfig 邮件配置
@@ -39,12 +240,34 @@ type WecomConfig struct {
 	Secret         string `json:"secret" yaml:"secret"`
 	AddrBookSecret string `json:"addr_book_secret" yaml:"addr_book_secret"`
 	Receivers      string `json:"receivers" yaml:"receivers"`
+	// APIBaseURL 接口地址，默认 https://qyapi.weixin.qq.com/cgi-bin
+	APIBaseURL string `json:"api_base_url" yaml:"api_base_url"`
+}
+
+// WecomRobot 企业微信群机器人配置，WebhookUrl 和 Key 配置一个即可，
+// 接收人为被 @ 的成员 userid 或手机号
+type WecomRobot struct {
+	WebhookUrl string `json:"webhook_url" yaml:"webhook_url"`
+	Key        string `json:"key" yaml:"key"`
+	// MsgType 默认的消息类型：text、markdown 或 markdown_v2，默认 text，
+	// 消息带有 Markdown 正文或链接时 text 自动升级为 markdown
+	MsgType string `json:"msg_type" yaml:"msg_type"`
+	// APIBaseURL 接口地址，默认 https://qyapi.weixin.qq.com/cgi-bin
+	APIBaseURL string `json:"api_base_url" yaml:"api_base_url"`
 }
 
 type Lark struct {
 	MsgType    string `json:"msg_type" yaml:"msg_type"`
 	WebhookUrl string `json:"webhook_url" yaml:"webhook_url"`
 	Secret     string `json:"secret" yaml:"secret"`
+	// AppID、AppSecret 应用机器人的凭证，设置后通过开放平台 im/v1/messages 接口发送给个人或群，
+	// 接收人可以是 open_id（ou_）、chat_id（oc_）、union_id（on_）、邮箱或 user_id，也可以写成 "user_id:xxx"
+	AppID     string `json:"app_id" yaml:"app_id"`
+	AppSecret string `json:"app_secret" yaml:"app_secret"`
+	// ReceiveIDType 无法识别类型的接收人使用的 receive_id_type，默认 user_id
+	ReceiveIDType string `json:"receive_id_type" yaml:"receive_id_type"`
+	// APIBaseURL 开放平台地址，默认 https://open.feishu.cn/open-apis，国际版使用 https://open.larksuite.com/open-apis
+	APIBaseURL string `json:"api_base_url" yaml:"api_base_url"`
 }
 
 type DingDing struct {
@@ -53,6 +276,45 @@ type DingDing struct {
 	Secret     string `json:"secret" yaml:"secret"`
 }
 
+// Slack 配置，设置 Token 时通过 chat.postMessage 发送，接收人为频道 ID 或用户 ID，
+// "频道 ID:ts" 表示回复到该消息的话题中；只设置 WebhookUrl 时通过 incoming webhook 发送到绑定的频道
+type Slack struct {
+	WebhookUrl string `json:"webhook_url" yaml:"webhook_url"`
+	// Token 机器人 token（xoxb-），需要 chat:write 权限
+	Token string `json:"token" yaml:"token"`
+	// Channel 未指定接收人时发送到的频道
+	Channel string `json:"channel" yaml:"channel"`
+	// Username 自定义发送者名称，使用 Token 时需要 chat:write.customize 权限
+	Username string `json:"username" yaml:"username"`
+	// APIBaseURL Web API 地址，默认 https://slack.com/api
+	APIBaseURL string        `json:"api_base_url" yaml:"api_base_url"`
+	Timeout    time.Duration `json:"timeout" yaml:"timeout"`
+}
+
+// Telegram 解析模式，默认 MarkdownV2
+const (
+	TelegramMarkdownV2 = "MarkdownV2"
+	TelegramHTML       = "HTML"
+	TelegramText       = "text" // 纯文本，不设置 parse_mode
+)
+
+// Telegram 机器人配置，接收人为 chat_id 或频道的 @username，
+// "chat_id:thread_id" 表示发送到论坛群组的某个话题
+type Telegram struct {
+	Token string `json:"token" yaml:"token"`
+	// APIBaseURL Bot API 地址，默认 https://api.telegram.org，可指向自建的 Bot API 服务
+	APIBaseURL string `json:"api_base_url" yaml:"api_base_url"`
+	// ParseMode 正文的解析模式：MarkdownV2、HTML 或 text
+	ParseMode string `json:"parse_mode" yaml:"parse_mode"`
+	// DisableNotification 静默发送，接收人不会收到提醒；低优先级的消息总是静默发送
+	DisableNotification bool `json:"disable_notification" yaml:"disable_notification"`
+	// MessageThreadID 默认的话题 ID，接收人中指定的话题优先
+	MessageThreadID int `json:"message_thread_id" yaml:"message_thread_id"`
+	// DisableLinkPreview 不显示链接预览
+	DisableLinkPreview bool          `json:"disable_link_preview" yaml:"disable_link_preview"`
+	Timeout            time.Duration `json:"timeout" yaml:"timeout"`
+}
+
 // SmsConfig 短信配置
 type SmsConfig struct {
 	Type            string `json:"type,omitempty" yaml:"type"`
@@ -71,33 +333,55 @@ type Webhook struct {
 }
 
 type NotifyToId struct {
-	Email   string `json:"email"`
-	Phone   string `json:"phone"`
-	Wecom   string `json:"wecom"`
-	Ding    string `json:"ding"`
-	Lark    string `json:"lark"`
-	Webhook string `json:"webhook"`
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+	Wecom string `json:"wecom"`
+	// WecomRobot 企业微信群机器人中被 @ 的 userid 或手机号
+	WecomRobot string `json:"wecom_robot"`
+	Ding       string `json:"ding"`
+	Lark       string `json:"lark"`
+	Webhook    string `json:"webhook"`
+	Slack      string `json:"slack"`
+	Telegram   string `json:"telegram"`
+	// Extra 第三方渠道的接收人标识，key 为渠道类型；
+	// key 为具名实例时（如 "lark:dba"）优先于渠道类型对应的字段
+	Extra map[string]string `json:"extra,omitempty"`
 }
 
 type NotifyToIds []NotifyToId
 
 func (s *NotifyToIds) GetToTagList(sender string) []string {
 	var tags []string
+	channelType, instance := SplitChannelName(sender)
 	for _, n := range *s {
 		tag := ""
-		switch sender {
+		if instance != "" {
+			if tag = n.Extra[sender]; tag != "" {
+				tags = append(tags, tag)
+				continue
+			}
+		}
+		switch channelType {
 		case "email":
 			tag = n.Email
 		case "sms":
 			tag = n.Phone
 		case "wecom":
 			tag = n.Wecom
+		case "wecom_robot":
+			tag = n.WecomRobot
 		case "lark":
 			tag = n.Lark
 		case "dingding":
 			tag = n.Ding
 		case "webhook":
 			tag = n.Webhook
+		case "slack":
+			tag = n.Slack
+		case "telegram":
+			tag = n.Telegram
+		default:
+			tag = n.Extra[channelType]
 		}
 		if tag != "" {
 			tags = append(tags, tag)
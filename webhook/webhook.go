@@ -1,6 +1,7 @@
 package webhook
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/v-mars/notify"
@@ -17,15 +18,34 @@ type Webhook struct {
 
 // Message represents the message structure sent to webhook endpoint
 type Message struct {
-	To      []string `json:"to"`
-	Title   string   `json:"title"`
-	Content string   `json:"content"`
+	To          []string            `json:"to"`
+	Title       string              `json:"title"`
+	Content     string              `json:"content"`
+	Markdown    string              `json:"markdown,omitempty"`
+	HTML        string              `json:"html,omitempty"`
+	Attachments []notify.Attachment `json:"attachments,omitempty"`
+	Links       []notify.Link       `json:"links,omitempty"`
+	Mentions    *notify.Mentions    `json:"mentions,omitempty"`
+	Priority    notify.Priority     `json:"priority,omitempty"`
+	Labels      map[string]string   `json:"labels,omitempty"`
+	Metadata    map[string]any      `json:"metadata,omitempty"`
 }
 
-// Result represents the response from webhook endpoint
+// Result represents the response from webhook endpoint,
+// message_id and recipients are optional and reported back in the SendResult
 type Result struct {
-	Success bool   `json:"success"`
-	Message string `json:"message,omitempty"`
+	Success    bool              `json:"success"`
+	Message    string            `json:"message,omitempty"`
+	MessageID  string            `json:"message_id,omitempty"`
+	Recipients []RecipientResult `json:"recipients,omitempty"`
+}
+
+// RecipientResult is the optional per-recipient status returned by the endpoint
+type RecipientResult struct {
+	To        string `json:"to"`
+	Success   bool   `json:"success"`
+	MessageID string `json:"message_id,omitempty"`
+	Error     string `json:"error,omitempty"`
 }
 
 // NewWebhook creates a new webhook sender
@@ -40,7 +60,18 @@ func NewWebhook(url string, timeout time.Duration, headers map[string]string) *W
 }
 
 // Send sends notification via webhook
-func (w *Webhook) Send(to []string, title string, content string) (sendResult *result.SendResult, err error) {
+func (w *Webhook) Send(to []string, title string, content string) (*result.SendResult, error) {
+	return w.SendContext(context.Background(), to, title, content)
+}
+
+// SendContext sends notification via webhook, the request is bound to ctx
+func (w *Webhook) SendContext(ctx context.Context, to []string, title string, content string) (*result.SendResult, error) {
+	return w.SendMessage(ctx, to, notify.NewMessage(title, content))
+}
+
+// SendMessage sends the whole structured message to the webhook endpoint,
+// content always carries the plain text body for receivers that only read it
+func (w *Webhook) SendMessage(ctx context.Context, to []string, msg *notify.Message) (sendResult *result.SendResult, err error) {
 	sendResult = &result.SendResult{
 		ChannelType:  NotifyTypeWebhook,
 		ChannelMsgID: nil,
@@ -52,17 +83,29 @@ func (w *Webhook) Send(to []string, title string, content string) (sendResult *r
 	}
 	defer func() {
 		sendResult.CostMs = time.Now().Sub(sendResult.SendTime).Milliseconds()
-		sendResult.ChannelMsgID = result.PtrOf(fmt.Sprintf("%d", time.Now().UnixNano()))
+		sendResult.MessageID = fmt.Sprintf("%d", time.Now().UnixNano())
+		if sendResult.ChannelMsgID == nil {
+			sendResult.ChannelMsgID = result.PtrOf(sendResult.MessageID)
+		}
 		sendResult.Success = err == nil
-		sendResult.MessageID = *sendResult.ChannelMsgID
 		if err != nil {
 			sendResult.Error = result.PtrOf(err.Error())
 		}
 	}()
 	message := Message{
-		To:      to,
-		Title:   title,
-		Content: content,
+		To:          to,
+		Title:       msg.Title,
+		Content:     msg.TextBody(),
+		Markdown:    msg.Markdown,
+		HTML:        msg.HTML,
+		Attachments: msg.Attachments,
+		Links:       msg.Links,
+		Priority:    msg.Priority,
+		Labels:      msg.Labels,
+		Metadata:    msg.Metadata,
+	}
+	if msg.Mentions.All || len(msg.Mentions.Users) > 0 {
+		message.Mentions = &msg.Mentions
 	}
 
 	client := &http.Client{
@@ -79,26 +122,52 @@ func (w *Webhook) Send(to []string, title string, content string) (sendResult *r
 		headers["Content-Type"] = "application/json"
 	}
 
-	respData, err := notify.JSONPost(http.MethodPost, w.URL, message, client, headers)
+	respData, err := notify.JSONPostContext(ctx, http.MethodPost, w.URL, message, client, headers)
 	if err != nil {
-		return sendResult, fmt.Errorf("failed to send webhook notification: %w", err)
+		err = fmt.Errorf("failed to send webhook notification: %w", err)
+		sendResult.AddRecipients(to, "", err)
+		return sendResult, err
 	}
 
-	result := Result{}
-	if err = json.Unmarshal(respData, &result); err != nil {
+	res := Result{}
+	if err = json.Unmarshal(respData, &res); err != nil {
 		// If we can't parse the response, we assume success if we got a response
+		sendResult.AddRecipients(to, "", nil)
 		return sendResult, nil
 	}
-
-	if !result.Success {
-		return sendResult, fmt.Errorf("webhook endpoint returned failure: %s", result.Message)
+	if res.MessageID != "" {
+		sendResult.ChannelMsgID = &res.MessageID
 	}
 
-	return sendResult, nil
+	if !res.Success {
+		err = fmt.Errorf("webhook endpoint returned failure: %s", res.Message)
+	}
+	if len(res.Recipients) == 0 {
+		sendResult.AddRecipients(to, res.MessageID, err)
+		return sendResult, err
+	}
+	for _, r := range res.Recipients {
+		var rErr error
+		if !r.Success {
+			rErr = fmt.Errorf("%s", r.Error)
+		}
+		sendResult.AddRecipient(r.To, r.MessageID, rErr)
+	}
+	return sendResult, err
 }
 
 const NotifyTypeWebhook = "webhook"
 
+func init() {
+	notify.Register(NotifyTypeWebhook, func(section notify.Section) (notify.Sender, error) {
+		var conf types.Webhook
+		if err := section.Decode(&conf); err != nil {
+			return nil, err
+		}
+		return NewWebhook(conf.URL, conf.Timeout, conf.Headers), nil
+	})
+}
+
 // ChannelType returns the channel type
 func (w *Webhook) ChannelType() string {
 	return NotifyTypeWebhook
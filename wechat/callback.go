@@ -0,0 +1,339 @@
+package wechat
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxCallbackBody 回调请求体的最大长度
+	maxCallbackBody = 1 << 20
+	// updateCardTimeout 回调中异步更新卡片的超时时间
+	updateCardTimeout = 30 * time.Second
+	// defaultMaxSkew 回调请求的 timestamp 与当前时间的最大差值
+	defaultMaxSkew = time.Hour
+)
+
+// EventTemplateCard 模板卡片按钮点击和投票提交的事件类型
+const EventTemplateCard = "template_card_event"
+
+// CardEvent 用户点击模板卡片按钮或提交投票时的回调事件
+type CardEvent struct {
+	UserID   string
+	CorpID   string
+	AgentID  int
+	EventKey string // 点击的按钮或提交按钮的 key
+	TaskID   string
+	CardType string // button_interaction、vote_interaction 等
+	// ResponseCode 用于更新卡片，72 小时内有效且只能使用一次
+	ResponseCode  string
+	SelectedItems []SelectedItem // 投票卡片提交的选项
+	CreateTime    time.Time
+}
+
+// SelectedItem 投票卡片中一个问题的选项
+type SelectedItem struct {
+	QuestionKey string
+	OptionIDs   []string
+}
+
+// CardUpdate 处理函数返回的卡片更新：Card 不为 nil 时替换整张卡片，否则把按钮替换为 ReplaceName
+type CardUpdate struct {
+	ReplaceName string
+	Card        *TemplateCard
+}
+
+// CardEventFunc 模板卡片事件的处理函数，返回的更新不为 nil 时异步更新点击者看到的卡片
+type CardEventFunc func(ctx context.Context, event *CardEvent) (*CardUpdate, error)
+
+// CallbackHandler 企业微信应用接收消息的 http.Handler：GET 请求用于验证回调 URL，
+// POST 请求校验 msg_signature 和 timestamp 并解密后按 EventKey 分发模板卡片事件，
+// 重复的事件（企业微信的重试或重放的请求）只处理一次
+type CallbackHandler struct {
+	// Token 和 EncodingAESKey 为应用接收消息设置中的 Token 和 EncodingAESKey，为空时拒绝所有请求
+	Token          string
+	EncodingAESKey string
+	// CorpID 校验解密后的 receiveid
+	CorpID string
+	// Client 用于更新卡片的应用，为 nil 时忽略处理函数返回的更新
+	Client *Wecom
+	// MaxSkew timestamp 与当前时间的最大差值，默认 1 小时
+	MaxSkew time.Duration
+
+	mu       sync.RWMutex
+	handlers map[string]CardEventFunc
+	seen     map[string]time.Time // 已处理的事件，两倍 MaxSkew 后重放的请求已无法通过 timestamp 校验，随后清除
+}
+
+// NewCallbackHandler 创建回调处理器，token、encodingAESKey 和 corpID 不能为空
+func NewCallbackHandler(token, encodingAESKey, corpID string, client *Wecom) (*CallbackHandler, error) {
+	if token == "" || encodingAESKey == "" || corpID == "" {
+		return nil, errors.New("企业微信回调的 Token、EncodingAESKey 和 CorpID 不能为空")
+	}
+	return &CallbackHandler{Token: token, EncodingAESKey: encodingAESKey, CorpID: corpID, Client: client}, nil
+}
+
+// Handle 注册按钮 key 的处理函数，eventKey 为空时处理没有匹配的事件
+func (h *CallbackHandler) Handle(eventKey string, fn CardEventFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.handlers == nil {
+		h.handlers = make(map[string]CardEventFunc)
+	}
+	h.handlers[eventKey] = fn
+}
+
+func (h *CallbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Token == "" || h.EncodingAESKey == "" || h.CorpID == "" {
+		http.Error(w, "callback secret is not configured", http.StatusUnauthorized)
+		return
+	}
+	query := r.URL.Query()
+	signature, timestamp, nonce := query.Get("msg_signature"), query.Get("timestamp"), query.Get("nonce")
+	switch r.Method {
+	case http.MethodGet:
+		echo := query.Get("echostr")
+		if !h.verify(signature, timestamp, nonce, echo) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		msg, err := h.decrypt(echo)
+		if err != nil {
+			http.Error(w, "decrypt failed", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write(msg)
+	case http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxCallbackBody))
+		if err != nil {
+			http.Error(w, "read body failed", http.StatusBadRequest)
+			return
+		}
+		var envelope struct {
+			Encrypt string `xml:"Encrypt"`
+		}
+		if err = xml.Unmarshal(body, &envelope); err != nil || envelope.Encrypt == "" {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		if !h.verify(signature, timestamp, nonce, envelope.Encrypt) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		msg, err := h.decrypt(envelope.Encrypt)
+		if err != nil {
+			http.Error(w, "decrypt failed", http.StatusBadRequest)
+			return
+		}
+		event, err := parseCardEvent(msg)
+		if err != nil {
+			http.Error(w, "invalid message", http.StatusBadRequest)
+			return
+		}
+		if event != nil && h.first(event) {
+			h.dispatch(r.Context(), event)
+		}
+		// 回复空串表示不被动回复消息
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// dispatch 调用 EventKey 对应的处理函数，并在后台更新卡片
+func (h *CallbackHandler) dispatch(ctx context.Context, event *CardEvent) {
+	h.mu.RLock()
+	fn, ok := h.handlers[event.EventKey]
+	if !ok {
+		fn = h.handlers[""]
+	}
+	h.mu.RUnlock()
+	if fn == nil {
+		return
+	}
+	update, err := fn(ctx, event)
+	if err != nil {
+		log.Printf("企业微信卡片事件 %s 处理失败: %v", event.EventKey, err)
+		return
+	}
+	if update == nil || h.Client == nil {
+		return
+	}
+	// 企业微信要求 5 秒内响应回调，更新卡片不阻塞响应，也不随请求取消
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, updateCardTimeout)
+		defer cancel()
+		var err error
+		if update.Card != nil {
+			err = h.Client.UpdateTemplateCard(ctx, event.ResponseCode, update.Card, event.UserID)
+		} else {
+			err = h.Client.UpdateButton(ctx, event.ResponseCode, update.ReplaceName, event.UserID)
+		}
+		if err != nil {
+			log.Printf("企业微信卡片 %s 更新失败: %v", event.TaskID, err)
+		}
+	}()
+}
+
+// first 记录事件，事件在 MaxSkew 内已经处理过时返回 false。
+// ResponseCode 每次点击都不同，按 TaskID 和 ResponseCode 去重，没有 ResponseCode 时按点击者和时间去重
+func (h *CallbackHandler) first(event *CardEvent) bool {
+	key := event.TaskID + "\x00" + event.ResponseCode
+	if event.ResponseCode == "" {
+		key += "\x00" + event.UserID + "\x00" + event.EventKey + "\x00" + strconv.FormatInt(event.CreateTime.Unix(), 10)
+	}
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.seen == nil {
+		h.seen = make(map[string]time.Time)
+	}
+	for k, at := range h.seen {
+		if now.Sub(at) > 2*h.maxSkew() {
+			delete(h.seen, k)
+		}
+	}
+	if _, ok := h.seen[key]; ok {
+		return false
+	}
+	h.seen[key] = now
+	return true
+}
+
+func (h *CallbackHandler) maxSkew() time.Duration {
+	if h.MaxSkew <= 0 {
+		return defaultMaxSkew
+	}
+	return h.MaxSkew
+}
+
+// verify 校验 timestamp（秒）与当前时间相差不超过 MaxSkew，
+// 并且 msg_signature 为 sha1(排序后的 Token、timestamp、nonce 和密文拼接)
+func (h *CallbackHandler) verify(signature, timestamp, nonce, encrypted string) bool {
+	if signature == "" {
+		return false
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > h.maxSkew() || skew < -h.maxSkew() {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(Signature(h.Token, timestamp, nonce, encrypted)), []byte(signature)) == 1
+}
+
+// decrypt 解密并校验 receiveid
+func (h *CallbackHandler) decrypt(encrypted string) ([]byte, error) {
+	msg, receiveID, err := Decrypt(h.EncodingAESKey, encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if receiveID != h.CorpID {
+		return nil, fmt.Errorf("receiveid %s 与 CorpID 不一致", receiveID)
+	}
+	return msg, nil
+}
+
+// Signature 回调消息的签名：sha1(排序后的 token、timestamp、nonce 和密文拼接) 的十六进制
+func Signature(token, timestamp, nonce, encrypted string) string {
+	parts := []string{token, timestamp, nonce, encrypted}
+	slices.Sort(parts)
+	sum := sha1.Sum([]byte(strings.Join(parts, "")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Decrypt 解密回调消息，密钥为 base64(EncodingAESKey + "=")，AES-256-CBC，IV 为密钥前 16 字节。
+// 明文为 16 字节随机串 + 4 字节网络字节序的消息长度 + 消息 + receiveid
+func Decrypt(encodingAESKey, encrypted string) (msg []byte, receiveID string, err error) {
+	key, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	if err != nil || len(key) != 32 {
+		return nil, "", errors.New("EncodingAESKey 无效")
+	}
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, "", fmt.Errorf("解析密文失败: %w", err)
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, "", errors.New("密文长度无效")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, "", err
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, key[:aes.BlockSize]).CryptBlocks(plain, data)
+	// PKCS#7 填充，企业微信按 32 字节补位
+	pad := int(plain[len(plain)-1])
+	if pad < 1 || pad > 32 || pad > len(plain) || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, "", errors.New("填充无效")
+	}
+	plain = plain[:len(plain)-pad]
+	if len(plain) < 20 {
+		return nil, "", errors.New("明文长度无效")
+	}
+	n := int(binary.BigEndian.Uint32(plain[16:20]))
+	if n > len(plain)-20 {
+		return nil, "", errors.New("消息长度无效")
+	}
+	return plain[20 : 20+n], string(plain[20+n:]), nil
+}
+
+// parseCardEvent 解析模板卡片事件，其他消息和事件返回 nil
+func parseCardEvent(msg []byte) (*CardEvent, error) {
+	var x struct {
+		ToUserName    string `xml:"ToUserName"`
+		FromUserName  string `xml:"FromUserName"`
+		CreateTime    int64  `xml:"CreateTime"`
+		MsgType       string `xml:"MsgType"`
+		Event         string `xml:"Event"`
+		EventKey      string `xml:"EventKey"`
+		TaskID        string `xml:"TaskId"`
+		CardType      string `xml:"CardType"`
+		ResponseCode  string `xml:"ResponseCode"`
+		AgentID       int    `xml:"AgentID"`
+		SelectedItems []struct {
+			QuestionKey string   `xml:"QuestionKey"`
+			OptionIDs   []string `xml:"OptionIds>OptionId"`
+		} `xml:"SelectedItems>SelectedItem"`
+	}
+	if err := xml.Unmarshal(msg, &x); err != nil {
+		return nil, err
+	}
+	if x.MsgType != "event" || x.Event != EventTemplateCard {
+		return nil, nil
+	}
+	event := &CardEvent{
+		UserID:       x.FromUserName,
+		CorpID:       x.ToUserName,
+		AgentID:      x.AgentID,
+		EventKey:     x.EventKey,
+		TaskID:       x.TaskID,
+		CardType:     x.CardType,
+		ResponseCode: x.ResponseCode,
+		CreateTime:   time.Unix(x.CreateTime, 0),
+	}
+	for _, item := range x.SelectedItems {
+		event.SelectedItems = append(event.SelectedItems, SelectedItem{QuestionKey: item.QuestionKey, OptionIDs: item.OptionIDs})
+	}
+	return event, nil
+}
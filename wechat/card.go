@@ -0,0 +1,319 @@
+package wechat
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// 模板卡片类型，群机器人只支持 text_notice 和 news_notice，
+// button_interaction 和 vote_interaction 只能通过应用发送，点击后回调到 CallbackHandler
+const (
+	CardTextNotice        = "text_notice"
+	CardNewsNotice        = "news_notice"
+	CardButtonInteraction = "button_interaction"
+	CardVoteInteraction   = "vote_interaction"
+)
+
+// 跳转类型
+const (
+	JumpNone    = 0
+	JumpURL     = 1
+	JumpMiniApp = 2
+)
+
+// taskIDRe 交互卡片的 task_id：最长 128 字节，只能包含数字、字母和 _-@
+var taskIDRe = regexp.MustCompile(`^[0-9A-Za-z_\-@]{1,128}$`)
+
+// TemplateCard 模板卡片，使用 NewTextNoticeCard、NewNewsNoticeCard、NewButtonCard 和 NewVoteCard 创建
+type TemplateCard struct {
+	CardType              string              `json:"card_type"`
+	Source                *CardSource         `json:"source,omitempty"`
+	MainTitle             *CardTitle          `json:"main_title,omitempty"`
+	EmphasisContent       *CardTitle          `json:"emphasis_content,omitempty"`
+	QuoteArea             *QuoteArea          `json:"quote_area,omitempty"`
+	SubTitleText          string              `json:"sub_title_text,omitempty"`
+	HorizontalContentList []HorizontalContent `json:"horizontal_content_list,omitempty"`
+	JumpList              []Jump              `json:"jump_list,omitempty"`
+	CardAction            *CardAction         `json:"card_action,omitempty"`
+	CardImage             *CardImage          `json:"card_image,omitempty"`
+	VerticalContentList   []CardTitle         `json:"vertical_content_list,omitempty"`
+	// TaskID 交互卡片的任务 ID，同一个应用内不能重复，回调时原样返回
+	TaskID       string        `json:"task_id,omitempty"`
+	ButtonList   []CardButton  `json:"button_list,omitempty"`
+	Checkbox     *Checkbox     `json:"checkbox,omitempty"`
+	SubmitButton *SubmitButton `json:"submit_button,omitempty"`
+}
+
+// CardSource 卡片来源，显示在卡片顶部
+type CardSource struct {
+	IconURL   string `json:"icon_url,omitempty"`
+	Desc      string `json:"desc,omitempty"`
+	DescColor int    `json:"desc_color,omitempty"` // 0 灰色，1 黑色，2 红色，3 绿色
+}
+
+// CardTitle 标题和辅助信息
+type CardTitle struct {
+	Title string `json:"title,omitempty"`
+	Desc  string `json:"desc,omitempty"`
+}
+
+// QuoteArea 引用文献样式
+type QuoteArea struct {
+	Type      int    `json:"type,omitempty"`
+	URL       string `json:"url,omitempty"`
+	AppID     string `json:"appid,omitempty"`
+	PagePath  string `json:"pagepath,omitempty"`
+	Title     string `json:"title,omitempty"`
+	QuoteText string `json:"quote_text,omitempty"`
+}
+
+// HorizontalContent 二级标题 + 文本，Type 为 1 时 URL 为跳转链接
+type HorizontalContent struct {
+	KeyName string `json:"keyname"`
+	Value   string `json:"value,omitempty"`
+	Type    int    `json:"type,omitempty"`
+	URL     string `json:"url,omitempty"`
+	MediaID string `json:"media_id,omitempty"`
+	UserID  string `json:"userid,omitempty"`
+}
+
+// Jump 跳转指引
+type Jump struct {
+	Type     int    `json:"type,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Title    string `json:"title"`
+	AppID    string `json:"appid,omitempty"`
+	PagePath string `json:"pagepath,omitempty"`
+}
+
+// CardAction 整体卡片的点击跳转
+type CardAction struct {
+	Type     int    `json:"type"`
+	URL      string `json:"url,omitempty"`
+	AppID    string `json:"appid,omitempty"`
+	PagePath string `json:"pagepath,omitempty"`
+}
+
+// CardImage news_notice 卡片的图片
+type CardImage struct {
+	URL         string  `json:"url"`
+	AspectRatio float64 `json:"aspect_ratio,omitempty"`
+}
+
+// CardButton button_interaction 卡片的按钮，Type 为 0 时点击回调 Key，为 1 时跳转 URL；Style 为 1 到 4
+type CardButton struct {
+	Type  int    `json:"type,omitempty"`
+	Text  string `json:"text"`
+	Style int    `json:"style,omitempty"`
+	Key   string `json:"key,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+// Checkbox vote_interaction 卡片的选择题，Mode 为 0 单选，1 多选
+type Checkbox struct {
+	QuestionKey string           `json:"question_key"`
+	OptionList  []CheckboxOption `json:"option_list"`
+	Disable     bool             `json:"disable,omitempty"`
+	Mode        int              `json:"mode,omitempty"`
+}
+
+// CheckboxOption 选择题的选项
+type CheckboxOption struct {
+	ID        string `json:"id"`
+	Text      string `json:"text"`
+	IsChecked bool   `json:"is_checked,omitempty"`
+}
+
+// SubmitButton vote_interaction 卡片的提交按钮，点击后回调 Key
+type SubmitButton struct {
+	Text string `json:"text"`
+	Key  string `json:"key"`
+}
+
+// CallbackButton 点击后回调到 CallbackHandler 的按钮，key 用于分发到注册的处理函数
+func CallbackButton(text, key string, style int) CardButton {
+	return CardButton{Text: text, Key: key, Style: style}
+}
+
+// LinkButton 点击后跳转 url 的按钮
+func LinkButton(text, url string, style int) CardButton {
+	return CardButton{Type: JumpURL, Text: text, URL: url, Style: style}
+}
+
+// Option 选择题的选项
+func Option(id, text string) CheckboxOption {
+	return CheckboxOption{ID: id, Text: text}
+}
+
+// NewTextNoticeCard 创建文本通知卡片，url 为点击卡片跳转的地址
+func NewTextNoticeCard(title, desc, url string) *TemplateCard {
+	return &TemplateCard{
+		CardType:   CardTextNotice,
+		MainTitle:  &CardTitle{Title: title, Desc: desc},
+		CardAction: &CardAction{Type: JumpURL, URL: url},
+	}
+}
+
+// NewNewsNoticeCard 创建图文展示卡片
+func NewNewsNoticeCard(title, desc, imageURL, url string) *TemplateCard {
+	return &TemplateCard{
+		CardType:   CardNewsNotice,
+		MainTitle:  &CardTitle{Title: title, Desc: desc},
+		CardImage:  &CardImage{URL: imageURL},
+		CardAction: &CardAction{Type: JumpURL, URL: url},
+	}
+}
+
+// NewButtonCard 创建按钮交互卡片，taskID 在应用内不能重复
+func NewButtonCard(title, desc, taskID string, buttons ...CardButton) *TemplateCard {
+	return &TemplateCard{
+		CardType:   CardButtonInteraction,
+		MainTitle:  &CardTitle{Title: title, Desc: desc},
+		TaskID:     taskID,
+		ButtonList: buttons,
+	}
+}
+
+// NewVoteCard 创建投票选择卡片，默认单选，提交按钮的 key 为 "submit"
+func NewVoteCard(title, desc, taskID, questionKey string, options ...CheckboxOption) *TemplateCard {
+	return &TemplateCard{
+		CardType:     CardVoteInteraction,
+		MainTitle:    &CardTitle{Title: title, Desc: desc},
+		TaskID:       taskID,
+		Checkbox:     &Checkbox{QuestionKey: questionKey, OptionList: options},
+		SubmitButton: &SubmitButton{Text: "提交", Key: "submit"},
+	}
+}
+
+// Multiple 投票卡片改为多选
+func (c *TemplateCard) Multiple() *TemplateCard {
+	if c.Checkbox != nil {
+		c.Checkbox.Mode = 1
+	}
+	return c
+}
+
+// Submit 设置投票卡片的提交按钮
+func (c *TemplateCard) Submit(text, key string) *TemplateCard {
+	c.SubmitButton = &SubmitButton{Text: text, Key: key}
+	return c
+}
+
+// WithSource 设置卡片来源
+func (c *TemplateCard) WithSource(iconURL, desc string) *TemplateCard {
+	c.Source = &CardSource{IconURL: iconURL, Desc: desc}
+	return c
+}
+
+// Emphasis 设置关键数据，如告警数量，只对 text_notice 生效
+func (c *TemplateCard) Emphasis(title, desc string) *TemplateCard {
+	c.EmphasisContent = &CardTitle{Title: title, Desc: desc}
+	return c
+}
+
+// Quote 设置引用文献
+func (c *TemplateCard) Quote(title, text string) *TemplateCard {
+	c.QuoteArea = &QuoteArea{Title: title, QuoteText: text}
+	return c
+}
+
+// SubTitle 设置二级普通文本
+func (c *TemplateCard) SubTitle(text string) *TemplateCard {
+	c.SubTitleText = text
+	return c
+}
+
+// Field 追加一行二级标题 + 文本
+func (c *TemplateCard) Field(key, value string) *TemplateCard {
+	c.HorizontalContentList = append(c.HorizontalContentList, HorizontalContent{KeyName: key, Value: value})
+	return c
+}
+
+// FieldLink 追加一行点击跳转链接的二级标题 + 文本
+func (c *TemplateCard) FieldLink(key, value, url string) *TemplateCard {
+	c.HorizontalContentList = append(c.HorizontalContentList, HorizontalContent{KeyName: key, Value: value, Type: JumpURL, URL: url})
+	return c
+}
+
+// JumpTo 追加一个跳转指引
+func (c *TemplateCard) JumpTo(title, url string) *TemplateCard {
+	c.JumpList = append(c.JumpList, Jump{Type: JumpURL, Title: title, URL: url})
+	return c
+}
+
+// Validate 按卡片类型校验必填字段和数量限制
+func (c *TemplateCard) Validate() error {
+	if c == nil {
+		return errors.New("模板卡片不能为空")
+	}
+	var errs []error
+	switch c.CardType {
+	case CardTextNotice:
+		if (c.MainTitle == nil || c.MainTitle.Title == "") && c.SubTitleText == "" {
+			errs = append(errs, errors.New("text_notice 卡片的 main_title.title 和 sub_title_text 至少设置一个"))
+		}
+	case CardNewsNotice:
+		if c.MainTitle == nil || c.MainTitle.Title == "" {
+			errs = append(errs, errors.New("news_notice 卡片缺少 main_title.title"))
+		}
+		if c.CardImage == nil || c.CardImage.URL == "" {
+			errs = append(errs, errors.New("news_notice 卡片缺少 card_image"))
+		}
+	case CardButtonInteraction:
+		if c.MainTitle == nil || c.MainTitle.Title == "" {
+			errs = append(errs, errors.New("button_interaction 卡片缺少 main_title.title"))
+		}
+		if n := len(c.ButtonList); n == 0 || n > 6 {
+			errs = append(errs, fmt.Errorf("button_list 需要 1 到 6 个按钮，当前 %d 个", n))
+		}
+		for i, b := range c.ButtonList {
+			switch {
+			case b.Text == "":
+				errs = append(errs, fmt.Errorf("第 %d 个按钮缺少 text", i+1))
+			case b.Type == JumpURL && b.URL == "":
+				errs = append(errs, fmt.Errorf("第 %d 个按钮缺少 url", i+1))
+			case b.Type != JumpURL && b.Key == "":
+				errs = append(errs, fmt.Errorf("第 %d 个按钮缺少 key", i+1))
+			}
+		}
+	case CardVoteInteraction:
+		if c.MainTitle == nil || c.MainTitle.Title == "" {
+			errs = append(errs, errors.New("vote_interaction 卡片缺少 main_title.title"))
+		}
+		if c.Checkbox == nil || c.Checkbox.QuestionKey == "" {
+			errs = append(errs, errors.New("vote_interaction 卡片缺少 checkbox.question_key"))
+		} else if n := len(c.Checkbox.OptionList); n == 0 || n > 20 {
+			errs = append(errs, fmt.Errorf("checkbox 需要 1 到 20 个选项，当前 %d 个", n))
+		}
+		if c.SubmitButton == nil || c.SubmitButton.Text == "" || c.SubmitButton.Key == "" {
+			errs = append(errs, errors.New("vote_interaction 卡片缺少 submit_button"))
+		}
+	default:
+		return fmt.Errorf("不支持的卡片类型 %q", c.CardType)
+	}
+	if c.CardType == CardButtonInteraction || c.CardType == CardVoteInteraction {
+		if !taskIDRe.MatchString(c.TaskID) {
+			errs = append(errs, fmt.Errorf("task_id %q 无效：最长 128 字节，只能包含数字、字母和 _-@", c.TaskID))
+		}
+	}
+	// text_notice 和 news_notice 必须设置整体跳转，交互卡片可选
+	switch {
+	case c.CardAction == nil || c.CardAction.Type == JumpNone:
+		if c.CardType == CardTextNotice || c.CardType == CardNewsNotice {
+			errs = append(errs, errors.New("卡片缺少 card_action"))
+		}
+	case c.CardAction.Type == JumpURL && c.CardAction.URL == "":
+		errs = append(errs, errors.New("card_action.url 不能为空"))
+	}
+	if n := len(c.HorizontalContentList); n > 6 {
+		errs = append(errs, fmt.Errorf("horizontal_content_list 最多 6 项，当前 %d 项", n))
+	}
+	if n := len(c.JumpList); n > 3 {
+		errs = append(errs, fmt.Errorf("jump_list 最多 3 项，当前 %d 项", n))
+	}
+	if n := len(c.VerticalContentList); n > 4 {
+		errs = append(errs, fmt.Errorf("vertical_content_list 最多 4 项，当前 %d 项", n))
+	}
+	return errors.Join(errs...)
+}
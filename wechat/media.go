@@ -0,0 +1,357 @@
+package wechat
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/result"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// mediaLifetime 临时素材 3 天内有效，提前 1 小时视为过期
+	mediaLifetime = 3*24*time.Hour - time.Hour
+	// minMediaSize 临时素材最小 5 字节
+	minMediaSize = 5
+	// maxArticles 图文消息最多 8 条
+	maxArticles = 8
+)
+
+// maxMediaSize 各类型临时素材的大小上限
+var maxMediaSize = map[string]int{
+	MsgTypeImage: 10 << 20,
+	MsgTypeVoice: 2 << 20,
+	MsgTypeVideo: 10 << 20,
+	MsgTypeFile:  20 << 20,
+}
+
+var (
+	mediaCachesMu sync.Mutex
+	mediaCaches   = map[string]*MediaCache{}
+)
+
+// Video 视频消息
+type Video struct {
+	MediaID     string `json:"media_id"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// MPNews 图文消息，与 news 不同，正文保存在企业微信中，最多 8 条
+type MPNews struct {
+	Articles []MPArticle `json:"articles"`
+}
+
+// MPArticle mpnews 中的一条，ThumbMediaID 为上传的图片的 media_id，Content 支持 html
+type MPArticle struct {
+	Title            string `json:"title"`
+	ThumbMediaID     string `json:"thumb_media_id"`
+	Author           string `json:"author,omitempty"`
+	ContentSourceURL string `json:"content_source_url,omitempty"`
+	Content          string `json:"content"`
+	Digest           string `json:"digest,omitempty"`
+}
+
+// NewImageMessage 创建图片消息，mediaID 由 UploadMedia 上传 jpg、png 获得
+func NewImageMessage(mediaID string) *Message {
+	return &Message{MsgType: MsgTypeImage, Image: &Media{MediaID: mediaID}}
+}
+
+// NewVoiceMessage 创建语音消息，mediaID 由 UploadMedia 上传 amr 格式的语音获得
+func NewVoiceMessage(mediaID string) *Message {
+	return &Message{MsgType: MsgTypeVoice, Voice: &Media{MediaID: mediaID}}
+}
+
+// NewVideoMessage 创建视频消息，mediaID 由 UploadMedia 上传 mp4 获得
+func NewVideoMessage(mediaID, title, description string) *Message {
+	return &Message{MsgType: MsgTypeVideo, Video: &Video{MediaID: mediaID, Title: title, Description: description}}
+}
+
+// NewFileMessage 创建文件消息，mediaID 由 UploadMedia 上传文件获得
+func NewFileMessage(mediaID string) *Message {
+	return &Message{MsgType: MsgTypeFile, File: &Media{MediaID: mediaID}}
+}
+
+// NewNewsMessage 创建图文消息，点击后跳转到 Article.URL
+func NewNewsMessage(articles ...Article) *Message {
+	return &Message{MsgType: MsgTypeNews, News: &News{Articles: articles}}
+}
+
+// NewMPNewsMessage 创建 mpnews 图文消息，点击后在企业微信中查看正文
+func NewMPNewsMessage(articles ...MPArticle) *Message {
+	return &Message{MsgType: MsgTypeMPNews, MPNews: &MPNews{Articles: articles}}
+}
+
+// Validate 校验图片、语音、视频、文件、图文和模板卡片消息的必填字段
+func (m *Message) Validate() error {
+	switch m.MsgType {
+	case MsgTypeImage, MsgTypeVoice, MsgTypeFile:
+		media := map[string]*Media{MsgTypeImage: m.Image, MsgTypeVoice: m.Voice, MsgTypeFile: m.File}[m.MsgType]
+		if media == nil || media.MediaID == "" {
+			return fmt.Errorf("%s 消息缺少 media_id", m.MsgType)
+		}
+	case MsgTypeVideo:
+		if m.Video == nil || m.Video.MediaID == "" {
+			return errors.New("video 消息缺少 media_id")
+		}
+	case MsgTypeNews:
+		if m.News == nil || len(m.News.Articles) == 0 || len(m.News.Articles) > maxArticles {
+			return fmt.Errorf("news 消息需要 1 到 %d 条图文", maxArticles)
+		}
+		var errs []error
+		for i, a := range m.News.Articles {
+			if a.Title == "" || a.URL == "" {
+				errs = append(errs, fmt.Errorf("第 %d 条图文缺少 title 或 url", i+1))
+			}
+		}
+		return errors.Join(errs...)
+	case MsgTypeMPNews:
+		if m.MPNews == nil || len(m.MPNews.Articles) == 0 || len(m.MPNews.Articles) > maxArticles {
+			return fmt.Errorf("mpnews 消息需要 1 到 %d 条图文", maxArticles)
+		}
+		var errs []error
+		for i, a := range m.MPNews.Articles {
+			if a.Title == "" || a.ThumbMediaID == "" || a.Content == "" {
+				errs = append(errs, fmt.Errorf("第 %d 条图文缺少 title、thumb_media_id 或 content", i+1))
+			}
+		}
+		return errors.Join(errs...)
+	case MsgTypeTemplateCard:
+		if m.TemplateCard == nil {
+			return errors.New("template_card 消息缺少卡片")
+		}
+		return m.TemplateCard.Validate()
+	case "":
+		return errors.New("缺少 msgtype")
+	}
+	return nil
+}
+
+// MediaCache 临时素材的 media_id 缓存，按类型、文件名和内容缓存，有效期内上传相同的文件时复用 media_id
+type MediaCache struct {
+	mu    sync.Mutex
+	items map[string]mediaItem
+}
+
+type mediaItem struct {
+	mediaID   string
+	expiresAt time.Time
+}
+
+// NewMediaCache 创建临时素材缓存
+func NewMediaCache() *MediaCache {
+	return &MediaCache{items: make(map[string]mediaItem)}
+}
+
+// SharedMediaCache 返回 corpID 和 apiBaseURL 对应的进程内共享的临时素材缓存，
+// 同一企业的应用之间可以共用 media_id
+func SharedMediaCache(corpID, apiBaseURL string) *MediaCache {
+	key := corpID + "\x00" + apiBaseURL
+	mediaCachesMu.Lock()
+	defer mediaCachesMu.Unlock()
+	m, ok := mediaCaches[key]
+	if !ok {
+		m = NewMediaCache()
+		mediaCaches[key] = m
+	}
+	return m
+}
+
+func (m *MediaCache) get(key string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[key]
+	if !ok || time.Now().After(item.expiresAt) {
+		return "", false
+	}
+	return item.mediaID, true
+}
+
+// put 缓存 media_id，同时清理已过期的素材
+func (m *MediaCache) put(key, mediaID string, expiresAt time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for k, item := range m.items {
+		if now.After(item.expiresAt) {
+			delete(m.items, k)
+		}
+	}
+	m.items[key] = mediaItem{mediaID: mediaID, expiresAt: expiresAt}
+}
+
+// UploadFile 上传本地文件为临时素材，见 UploadMedia
+func (c *Wecom) UploadFile(ctx context.Context, mediaType, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", notify.Permanent(err)
+	}
+	defer f.Close()
+	return c.UploadMedia(ctx, mediaType, filepath.Base(path), f)
+}
+
+// UploadMedia 上传临时素材，mediaType 为 image（10MB）、voice（2MB）、video（10MB）或 file（20MB），
+// 返回的 media_id 3 天内有效；有效期内上传相同的文件时直接返回缓存的 media_id
+func (c *Wecom) UploadMedia(ctx context.Context, mediaType, name string, reader io.Reader) (string, error) {
+	limit, ok := maxMediaSize[mediaType]
+	if !ok {
+		return "", notify.Permanent(fmt.Errorf("不支持的素材类型: %s", mediaType))
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, int64(limit)+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) < minMediaSize || len(data) > limit {
+		return "", notify.Permanent(fmt.Errorf("%s 素材 %s 的大小 %d 字节不在 %d 字节到 %dMB 之间", mediaType, name, len(data), minMediaSize, limit>>20))
+	}
+	sum := sha256.Sum256(data)
+	key := mediaType + "\x00" + name + "\x00" + hex.EncodeToString(sum[:])
+	cache := c.mediaCache()
+	if mediaID, ok := cache.get(key); ok {
+		return mediaID, nil
+	}
+
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	part, err := w.CreateFormFile("media", name)
+	if err != nil {
+		return "", err
+	}
+	if _, err = part.Write(data); err != nil {
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+	tokens := c.tokenProvider()
+	token, err := tokens.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+	api := c.apiBaseURL() + "/media/upload"
+	query := url.Values{"access_token": {token}, "type": {mediaType}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, api+"?"+query.Encode(), body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		// 网络错误的 URL 中包含 access_token，不能出现在错误信息中
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = api
+		}
+		return "", fmt.Errorf("上传企业微信临时素材失败: %w", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var res struct {
+		Err
+		MediaID   string `json:"media_id"`
+		CreatedAt string `json:"created_at"`
+	}
+	if err = json.Unmarshal(b, &res); err != nil {
+		if resp.StatusCode >= http.StatusBadRequest {
+			return "", &notify.HTTPError{StatusCode: resp.StatusCode, Body: b}
+		}
+		return "", fmt.Errorf("解析企业微信上传素材结果失败: %w", err)
+	}
+	if res.ErrCode != 0 {
+		return "", fmt.Errorf("上传企业微信临时素材失败: %w", apiError(tokens, token, res.Err))
+	}
+	createdAt := time.Now()
+	if sec, err := strconv.ParseInt(res.CreatedAt, 10, 64); err == nil {
+		createdAt = time.Unix(sec, 0)
+	}
+	cache.put(key, res.MediaID, createdAt.Add(mediaLifetime))
+	return res.MediaID, nil
+}
+
+// sendAttachments 把附件上传后依次以图片或文件消息发送给 msg 的目标，不超过 10MB 的 jpg、png 以图片消息发送。
+// 主消息已经送达，附件失败只记录日志并写入 sendResult.Warnings，不影响发送结果，避免重试时重复发送主消息
+func (c *Wecom) sendAttachments(ctx context.Context, sendResult *result.SendResult, msg Message, attachments []notify.Attachment) {
+	warn := func(name string, err error) {
+		log.Printf("企业微信发送附件 %s 失败: %v", name, err)
+		sendResult.AddWarning(fmt.Errorf("发送附件 %s 失败: %w", name, err))
+	}
+	for _, a := range attachments {
+		data, name, contentType, err := readAttachment(a)
+		if err != nil {
+			if name = a.Name; name == "" {
+				name = filepath.Base(a.Path)
+			}
+			warn(name, err)
+			continue
+		}
+		if data == nil {
+			log.Printf("企业微信不支持 URL 附件，忽略 %s", a.URL)
+			continue
+		}
+		mediaType := MsgTypeFile
+		if (contentType == "image/png" || contentType == "image/jpeg") && len(data) <= maxMediaSize[MsgTypeImage] {
+			mediaType = MsgTypeImage
+		}
+		mediaID, err := c.UploadMedia(ctx, mediaType, name, bytes.NewReader(data))
+		if err != nil {
+			warn(name, err)
+			continue
+		}
+		p := NewFileMessage(mediaID)
+		if mediaType == MsgTypeImage {
+			p = NewImageMessage(mediaID)
+		}
+		p.ToUser, p.ToParty, p.ToTag, p.AgentID = msg.ToUser, msg.ToParty, msg.ToTag, msg.AgentID
+		if _, err = c.send(ctx, *p); err != nil {
+			warn(name, err)
+		}
+	}
+}
+
+// mediaCache 未设置 Media 时使用 CorpID 对应的共享缓存
+func (c *Wecom) mediaCache() *MediaCache {
+	if c.Media != nil {
+		return c.Media
+	}
+	return SharedMediaCache(c.CorpID, c.APIBaseURL)
+}
+
+// readAttachment 读取 Data 或 Path 附件的内容，未设置 ContentType 时按文件名推断；URL 附件返回 nil
+func readAttachment(a notify.Attachment) (data []byte, name, contentType string, err error) {
+	data, name = a.Data, a.Name
+	if len(data) == 0 {
+		if a.Path == "" {
+			return nil, name, "", nil
+		}
+		if data, err = os.ReadFile(a.Path); err != nil {
+			return nil, name, "", err
+		}
+		if name == "" {
+			name = filepath.Base(a.Path)
+		}
+	}
+	contentType = a.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
+	}
+	return data, name, contentType, nil
+}
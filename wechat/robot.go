@@ -0,0 +1,546 @@
+package wechat
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/markdown"
+	"github.com/v-mars/notify/result"
+	"github.com/v-mars/notify/types"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAPIBaseURL = "https://qyapi.weixin.qq.com/cgi-bin"
+	// MetadataRobotMessage notify.Message.Metadata 中的键，值为 *RobotMsg，通过 Manager 发送 news、template_card 等消息
+	MetadataRobotMessage = "wecom_robot_message"
+	// maxRobotImage 群机器人图片消息的图片最大 2MB
+	maxRobotImage = 2 << 20
+	// mentionAll @ 所有人
+	mentionAll = "@all"
+)
+
+// mobileRe 手机号，其他接收人视为 userid
+var mobileRe = regexp.MustCompile(`^\+?\d{11,15}$`)
+
+// Robot 企业微信群机器人
+type Robot struct {
+	types.WecomRobot
+}
+
+// RobotMsg 群机器人消息，使用 NewRobotText、NewRobotMarkdown、NewRobotImage 等创建
+type RobotMsg struct {
+	MsgType      string        `json:"msgtype"`
+	Text         *RobotText    `json:"text,omitempty"`
+	Markdown     *Content      `json:"markdown,omitempty"`
+	MarkdownV2   *Content      `json:"markdown_v2,omitempty"`
+	Image        *RobotImage   `json:"image,omitempty"`
+	News         *News         `json:"news,omitempty"`
+	File         *Media        `json:"file,omitempty"`
+	Voice        *Media        `json:"voice,omitempty"`
+	TemplateCard *TemplateCard `json:"template_card,omitempty"`
+}
+
+// RobotText 文本消息，MentionedList 为 userid，MentionedMobileList 为手机号，"@all" 表示所有人
+type RobotText struct {
+	Content             string   `json:"content"`
+	MentionedList       []string `json:"mentioned_list,omitempty"`
+	MentionedMobileList []string `json:"mentioned_mobile_list,omitempty"`
+}
+
+// RobotImage 图片消息，图片内容的 base64 和 md5
+type RobotImage struct {
+	Base64 string `json:"base64"`
+	MD5    string `json:"md5"`
+}
+
+// News 图文消息，最多 8 条
+type News struct {
+	Articles []Article `json:"articles"`
+}
+
+// Article 图文消息中的一条
+type Article struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url"`
+	PicURL      string `json:"picurl,omitempty"`
+}
+
+// Media 文件和语音消息，MediaID 为上传后返回的 media_id
+type Media struct {
+	MediaID string `json:"media_id"`
+}
+
+// NewRobotText 创建文本消息
+func NewRobotText(content string) *RobotMsg {
+	return &RobotMsg{MsgType: MsgTypeText, Text: &RobotText{Content: content}}
+}
+
+// NewRobotMarkdown 创建 markdown 消息，正文中用 <@userid> @ 成员
+func NewRobotMarkdown(content string) *RobotMsg {
+	return &RobotMsg{MsgType: MsgTypeMarkdown, Markdown: &Content{Content: content}}
+}
+
+// NewRobotMarkdownV2 创建 markdown_v2 消息，支持表格、代码块等完整语法，不支持 @ 成员
+func NewRobotMarkdownV2(content string) *RobotMsg {
+	return &RobotMsg{MsgType: MsgTypeMarkdownV2, MarkdownV2: &Content{Content: content}}
+}
+
+// NewRobotImage 创建图片消息，图片为 jpg 或 png，最大 2MB
+func NewRobotImage(data []byte) *RobotMsg {
+	sum := md5.Sum(data)
+	return &RobotMsg{MsgType: MsgTypeImage, Image: &RobotImage{
+		Base64: base64.StdEncoding.EncodeToString(data),
+		MD5:    hex.EncodeToString(sum[:]),
+	}}
+}
+
+// NewRobotNews 创建图文消息
+func NewRobotNews(articles ...Article) *RobotMsg {
+	return &RobotMsg{MsgType: MsgTypeNews, News: &News{Articles: articles}}
+}
+
+// NewRobotFile 创建文件消息，mediaID 由 UploadMedia 上传文件获得
+func NewRobotFile(mediaID string) *RobotMsg {
+	return &RobotMsg{MsgType: MsgTypeFile, File: &Media{MediaID: mediaID}}
+}
+
+// NewRobotVoice 创建语音消息，mediaID 由 UploadMedia 上传 amr 格式的语音获得
+func NewRobotVoice(mediaID string) *RobotMsg {
+	return &RobotMsg{MsgType: MsgTypeVoice, Voice: &Media{MediaID: mediaID}}
+}
+
+// NewRobotTemplateCard 创建模板卡片消息
+func NewRobotTemplateCard(card *TemplateCard) *RobotMsg {
+	return &RobotMsg{MsgType: MsgTypeTemplateCard, TemplateCard: card}
+}
+
+// Mention 文本消息 @ userid 对应的成员
+func (m *RobotMsg) Mention(userIds ...string) *RobotMsg {
+	if m.Text != nil {
+		m.Text.MentionedList = appendNew(m.Text.MentionedList, userIds...)
+	}
+	return m
+}
+
+// MentionMobiles 文本消息 @ 手机号对应的成员
+func (m *RobotMsg) MentionMobiles(mobiles ...string) *RobotMsg {
+	if m.Text != nil {
+		m.Text.MentionedMobileList = appendNew(m.Text.MentionedMobileList, mobiles...)
+	}
+	return m
+}
+
+// MentionAll 文本消息 @ 所有人
+func (m *RobotMsg) MentionAll() *RobotMsg {
+	return m.Mention(mentionAll)
+}
+
+// Validate 校验消息类型与内容是否匹配，以及各类型的长度和数量限制
+func (m *RobotMsg) Validate() error {
+	if m == nil {
+		return errors.New("消息不能为空")
+	}
+	switch m.MsgType {
+	case MsgTypeText:
+		if m.Text == nil || strings.TrimSpace(m.Text.Content) == "" {
+			return errors.New("text 消息缺少 content")
+		}
+		if n := len(m.Text.Content); n > 2048 {
+			return fmt.Errorf("text 消息最多 2048 字节，当前 %d 字节", n)
+		}
+	case MsgTypeMarkdown:
+		if m.Markdown == nil || strings.TrimSpace(m.Markdown.Content) == "" {
+			return errors.New("markdown 消息缺少 content")
+		}
+		if n := len(m.Markdown.Content); n > 4096 {
+			return fmt.Errorf("markdown 消息最多 4096 字节，当前 %d 字节", n)
+		}
+	case MsgTypeMarkdownV2:
+		if m.MarkdownV2 == nil || strings.TrimSpace(m.MarkdownV2.Content) == "" {
+			return errors.New("markdown_v2 消息缺少 content")
+		}
+		if n := len(m.MarkdownV2.Content); n > 4096 {
+			return fmt.Errorf("markdown_v2 消息最多 4096 字节，当前 %d 字节", n)
+		}
+	case MsgTypeImage:
+		if m.Image == nil || m.Image.Base64 == "" {
+			return errors.New("image 消息缺少图片")
+		}
+		if n := base64.StdEncoding.DecodedLen(len(m.Image.Base64)); n > maxRobotImage {
+			return fmt.Errorf("image 消息的图片最大 2MB，当前 %d 字节", n)
+		}
+	case MsgTypeNews:
+		if m.News == nil || len(m.News.Articles) == 0 || len(m.News.Articles) > 8 {
+			return errors.New("news 消息需要 1 到 8 条图文")
+		}
+		for i, a := range m.News.Articles {
+			if a.Title == "" || a.URL == "" {
+				return fmt.Errorf("news 消息第 %d 条图文缺少 title 或 url", i+1)
+			}
+		}
+	case MsgTypeFile:
+		if m.File == nil || m.File.MediaID == "" {
+			return errors.New("file 消息缺少 media_id")
+		}
+	case MsgTypeVoice:
+		if m.Voice == nil || m.Voice.MediaID == "" {
+			return errors.New("voice 消息缺少 media_id")
+		}
+	case MsgTypeTemplateCard:
+		if m.TemplateCard != nil && m.TemplateCard.CardType != CardTextNotice && m.TemplateCard.CardType != CardNewsNotice {
+			return fmt.Errorf("群机器人不支持 %s 卡片", m.TemplateCard.CardType)
+		}
+		return m.TemplateCard.Validate()
+	default:
+		return fmt.Errorf("不支持的消息类型 %q", m.MsgType)
+	}
+	return nil
+}
+
+// NewRobot 创建群机器人，参数为 webhook 地址或其中的 key
+func NewRobot(webhookOrKey string) *Robot {
+	r := &Robot{WecomRobot: types.WecomRobot{MsgType: MsgTypeText}}
+	if strings.HasPrefix(webhookOrKey, "http://") || strings.HasPrefix(webhookOrKey, "https://") {
+		r.WebhookUrl = webhookOrKey
+	} else {
+		r.Key = webhookOrKey
+	}
+	return r
+}
+
+// Send 发送消息，tos 为被 @ 的成员 userid 或手机号
+func (r *Robot) Send(tos []string, title, content string) (*result.SendResult, error) {
+	return r.SendContext(context.Background(), tos, title, content)
+}
+
+// SendContext 发送消息，ctx 超时或取消时中断请求
+func (r *Robot) SendContext(ctx context.Context, tos []string, title, content string) (*result.SendResult, error) {
+	return r.SendMessage(ctx, tos, notify.NewMessage(title, content))
+}
+
+// SendMessage 发送结构化消息，tos 与 msg.Mentions.Users 都会被 @。
+// 消息带有 Markdown 正文或链接时，text 类型自动升级为 markdown，markdown 只能 @ userid，
+// 有手机号或 @所有人 时另外发送一条 text 消息提醒；附件依次以图片或文件消息发送；
+// Metadata 中带有 MetadataRobotMessage 时发送该消息
+func (r *Robot) SendMessage(ctx context.Context, tos []string, msg *notify.Message) (*result.SendResult, error) {
+	if m, err := notify.MetadataValue[RobotMsg](msg, MetadataRobotMessage); err != nil || m != nil {
+		if err != nil {
+			return nil, err
+		}
+		return r.SendPayload(ctx, tos, m)
+	}
+	userIds, mobiles := splitMentions(append(append([]string{}, tos...), msg.Mentions.Users...))
+	if msg.Mentions.All {
+		userIds = appendNew(userIds, mentionAll)
+	}
+	msgType := r.MsgType
+	if msgType == "" {
+		msgType = MsgTypeText
+	}
+	if msgType == MsgTypeText && (msg.Markdown != "" || len(msg.Links) > 0) {
+		msgType = MsgTypeMarkdown
+	}
+	var payloads []*RobotMsg
+	switch msgType {
+	case MsgTypeMarkdown, MsgTypeMarkdownV2:
+		var body string
+		switch {
+		case msgType == MsgTypeMarkdownV2:
+			// markdown_v2 支持标准语法，不需要转换
+			body = msg.MarkdownBody()
+			if msg.Title != "" {
+				body = "# " + msg.Title + "\n\n" + body
+			}
+		case msg.Markdown != "":
+			body = msg.Title + "\n" + markdown.Convert(msg.Markdown, markdown.WeCom)
+		default:
+			body = msg.Title + "\n" + msg.TextBody()
+		}
+		for _, link := range msg.Links {
+			body += fmt.Sprintf("\n[%s](%s)", link.Text, link.URL)
+		}
+		var ats, remind []string
+		for _, id := range userIds {
+			if msgType == MsgTypeMarkdown && id != mentionAll {
+				ats = append(ats, "<@"+id+">")
+			} else {
+				remind = append(remind, id)
+			}
+		}
+		if len(ats) > 0 {
+			body += "\n" + strings.Join(ats, " ")
+		}
+		if msgType == MsgTypeMarkdown {
+			payloads = append(payloads, NewRobotMarkdown(body))
+		} else {
+			payloads = append(payloads, NewRobotMarkdownV2(body))
+		}
+		if len(remind) > 0 || len(mobiles) > 0 {
+			// markdown 不支持 @ 手机号和所有人，另外发送一条 text 消息提醒
+			title := msg.Title
+			if title == "" {
+				title = "请查看上一条消息"
+			}
+			payloads = append(payloads, NewRobotText(title).Mention(remind...).MentionMobiles(mobiles...))
+		}
+	default:
+		text := strings.TrimPrefix(msg.Title+"\n"+msg.TextBody(), "\n")
+		for _, link := range msg.Links {
+			text += "\n" + link.Text + ": " + link.URL
+		}
+		payloads = append(payloads, NewRobotText(text).Mention(userIds...).MentionMobiles(mobiles...))
+	}
+	return r.send(ctx, tos, payloads, msg.Attachments)
+}
+
+// SendPayload 校验并发送用 NewRobotText、NewRobotNews 等创建的消息，校验失败时不发送，返回永久错误；
+// text 消息额外 @ tos 中的 userid 和手机号
+func (r *Robot) SendPayload(ctx context.Context, tos []string, m *RobotMsg) (*result.SendResult, error) {
+	if m.MsgType == MsgTypeText && m.Text != nil && len(tos) > 0 {
+		c, text := *m, *m.Text
+		c.Text = &text
+		userIds, mobiles := splitMentions(tos)
+		text.MentionedList = appendNew(append([]string{}, text.MentionedList...), userIds...)
+		text.MentionedMobileList = appendNew(append([]string{}, text.MentionedMobileList...), mobiles...)
+		m = &c
+	}
+	return r.send(ctx, tos, []*RobotMsg{m}, nil)
+}
+
+// send 依次发送消息和附件，第一条消息失败时返回错误；第一条消息已经送达，之后的提醒和附件失败时
+// 只记录在 Warnings 中，不影响发送结果，避免重试、降级或重放时重复发送第一条消息。
+// 群机器人只返回整体结果，tos 记录为与整体相同的结果
+func (r *Robot) send(ctx context.Context, tos []string, payloads []*RobotMsg, attachments []notify.Attachment) (sendResult *result.SendResult, err error) {
+	sendResult = &result.SendResult{
+		ChannelType:  NotifyTypeWecomRobot,
+		ChannelMsgID: nil,
+		Success:      false,
+		MessageID:    "",
+		SendTime:     time.Now(),
+		Error:        nil,
+		CostMs:       0,
+	}
+	defer func() {
+		sendResult.AddRecipients(tos, "", err)
+		sendResult.CostMs = time.Now().Sub(sendResult.SendTime).Milliseconds()
+		sendResult.MessageID = fmt.Sprintf("%d", time.Now().UnixNano())
+		if sendResult.ChannelMsgID == nil {
+			sendResult.ChannelMsgID = result.PtrOf(sendResult.MessageID)
+		}
+		sendResult.Success = err == nil
+		if err != nil {
+			sendResult.Error = result.PtrOf(err.Error())
+		}
+	}()
+	for _, m := range payloads {
+		if err = m.Validate(); err != nil {
+			return sendResult, notify.Permanent(fmt.Errorf("企业微信群机器人消息校验失败: %w", err))
+		}
+	}
+	if err = r.post(ctx, payloads[0]); err != nil {
+		return sendResult, err
+	}
+	for _, m := range payloads[1:] {
+		if postErr := r.post(ctx, m); postErr != nil {
+			log.Printf("企业微信群机器人发送 %s 提醒失败: %v", m.MsgType, postErr)
+			sendResult.AddWarning(fmt.Errorf("发送 %s 提醒失败: %w", m.MsgType, postErr))
+		}
+	}
+	for _, a := range attachments {
+		if sendErr := r.sendAttachment(ctx, a); sendErr != nil {
+			log.Printf("企业微信群机器人发送附件 %s 失败: %v", a.Name, sendErr)
+			sendResult.AddWarning(fmt.Errorf("发送附件 %s 失败: %w", a.Name, sendErr))
+		}
+	}
+	return sendResult, nil
+}
+
+// sendAttachment 不超过 2MB 的 jpg、png 以图片消息发送，其他上传后以文件消息发送，只支持 Path 和 Data
+func (r *Robot) sendAttachment(ctx context.Context, a notify.Attachment) error {
+	data, name, contentType, err := readAttachment(a)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		log.Printf("企业微信群机器人不支持 URL 附件，忽略 %s", a.URL)
+		return nil
+	}
+	if (contentType == "image/png" || contentType == "image/jpeg") && len(data) <= maxRobotImage {
+		return r.post(ctx, NewRobotImage(data))
+	}
+	mediaID, err := r.UploadMedia(ctx, "file", name, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	return r.post(ctx, NewRobotFile(mediaID))
+}
+
+// UploadMedia 上传文件，mediaType 为 file 或 voice，返回的 media_id 三天内有效
+func (r *Robot) UploadMedia(ctx context.Context, mediaType, name string, reader io.Reader) (string, error) {
+	key := r.key()
+	if key == "" {
+		return "", notify.Permanent(errors.New("企业微信群机器人缺少 key"))
+	}
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	part, err := w.CreateFormFile("media", name)
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(part, reader); err != nil {
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+	api := r.apiBaseURL() + "/webhook/upload_media?key=" + url.QueryEscape(key) + "&type=" + url.QueryEscape(mediaType)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, api, body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return "", r.redact(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var res struct {
+		Err
+		MediaID string `json:"media_id"`
+	}
+	if err = json.Unmarshal(b, &res); err != nil {
+		if resp.StatusCode >= http.StatusBadRequest {
+			return "", &notify.HTTPError{StatusCode: resp.StatusCode, Body: b}
+		}
+		return "", fmt.Errorf("解析企业微信上传文件结果失败: %w", err)
+	}
+	if res.ErrCode != 0 {
+		return "", notify.NewError(strconv.Itoa(res.ErrCode), retryableErrCodes[res.ErrCode],
+			fmt.Errorf("上传文件失败: errmsg: %s errcode: %d", res.ErrMsg, res.ErrCode))
+	}
+	return res.MediaID, nil
+}
+
+// post 发送一条消息
+func (r *Robot) post(ctx context.Context, m *RobotMsg) error {
+	api := r.WebhookUrl
+	if api == "" {
+		if r.Key == "" {
+			return notify.Permanent(errors.New("企业微信群机器人缺少 webhook_url 或 key"))
+		}
+		api = r.apiBaseURL() + "/webhook/send?key=" + url.QueryEscape(r.Key)
+	}
+	resp, err := notify.JSONPostContext(ctx, http.MethodPost, api, m, apiClient, nil)
+	var res Err
+	if jsonErr := json.Unmarshal(resp, &res); jsonErr != nil {
+		if err == nil {
+			return fmt.Errorf("invalid wecom robot response: %s", resp)
+		}
+		return r.redact(err)
+	}
+	if res.ErrCode != 0 {
+		return notify.NewError(strconv.Itoa(res.ErrCode), retryableErrCodes[res.ErrCode],
+			fmt.Errorf("errmsg: %s errcode: %d", res.ErrMsg, res.ErrCode))
+	}
+	return err
+}
+
+// redact 网络错误的 URL 中包含 key，不能出现在错误信息中
+func (r *Robot) redact(err error) error {
+	var urlErr *url.Error
+	if key := r.key(); key != "" && errors.As(err, &urlErr) {
+		urlErr.URL = strings.ReplaceAll(urlErr.URL, key, "<key>")
+	}
+	return err
+}
+
+// key 配置的 Key，未配置时从 WebhookUrl 中解析
+func (r *Robot) key() string {
+	if r.Key != "" {
+		return r.Key
+	}
+	if u, err := url.Parse(r.WebhookUrl); err == nil {
+		return u.Query().Get("key")
+	}
+	return ""
+}
+
+// apiBaseURL 配置的 APIBaseURL，未配置时从 WebhookUrl 中解析
+func (r *Robot) apiBaseURL() string {
+	if r.APIBaseURL != "" {
+		return strings.TrimRight(r.APIBaseURL, "/")
+	}
+	if base, _, ok := strings.Cut(r.WebhookUrl, "/webhook/send"); ok {
+		return base
+	}
+	return defaultAPIBaseURL
+}
+
+// splitMentions 按格式把接收人分为 userid 和手机号
+func splitMentions(tos []string) (userIds, mobiles []string) {
+	for _, to := range tos {
+		to = strings.TrimSpace(to)
+		switch {
+		case to == "":
+		case mobileRe.MatchString(to):
+			mobiles = appendNew(mobiles, to)
+		default:
+			userIds = appendNew(userIds, to)
+		}
+	}
+	return userIds, mobiles
+}
+
+// appendNew 追加不重复且非空的元素
+func appendNew(list []string, items ...string) []string {
+	for _, item := range items {
+		if item != "" && !slices.Contains(list, item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+const NotifyTypeWecomRobot = "wecom_robot"
+
+func init() {
+	notify.Register(NotifyTypeWecomRobot, func(section notify.Section) (notify.Sender, error) {
+		var conf types.WecomRobot
+		if err := section.Decode(&conf); err != nil {
+			return nil, err
+		}
+		if conf.WebhookUrl == "" && conf.Key == "" {
+			return nil, errors.New("企业微信群机器人需要配置 webhook_url 或 key")
+		}
+		r := &Robot{WecomRobot: conf}
+		if r.MsgType == "" {
+			r.MsgType = MsgTypeText
+		}
+		return r, nil
+	})
+}
+
+func (r *Robot) ChannelType() string {
+	return NotifyTypeWecomRobot
+}
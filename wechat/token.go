@@ -0,0 +1,164 @@
+package wechat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/v-mars/notify"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// tokenRefreshAhead access_token 过期前多久开始在后台刷新
+	tokenRefreshAhead = 10 * time.Minute
+	// tokenExpireAhead access_token 提前视为过期的时间，避免请求途中过期
+	tokenExpireAhead = time.Minute
+)
+
+// apiClient 调用企业微信接口的 http 客户端
+var apiClient = &http.Client{Timeout: 30 * time.Second}
+
+var (
+	providersMu sync.Mutex
+	providers   = map[string]*TokenProvider{}
+)
+
+// TokenProvider 企业微信应用的 access_token 缓存，并发获取时只请求一次，过期前在后台刷新。
+// 同一个企业的同一个应用应共用一个 TokenProvider，见 SharedTokenProvider
+type TokenProvider struct {
+	corpID, secret, apiBaseURL string
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	call      *tokenCall // 正在进行的刷新
+}
+
+// tokenCall 一次刷新，done 关闭后 token 和 err 可读
+type tokenCall struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// NewTokenProvider 创建 access_token 缓存，apiBaseURL 为空时使用 https://qyapi.weixin.qq.com/cgi-bin
+func NewTokenProvider(corpID, secret, apiBaseURL string) *TokenProvider {
+	if apiBaseURL == "" {
+		apiBaseURL = defaultAPIBaseURL
+	}
+	return &TokenProvider{corpID: corpID, secret: secret, apiBaseURL: strings.TrimRight(apiBaseURL, "/")}
+}
+
+// SharedTokenProvider 返回 corpID、secret 和 apiBaseURL 对应的进程内共享的 access_token 缓存，
+// Manager 每次发送都会创建新的 Wecom，共享缓存避免每次发送都重新获取 token
+func SharedTokenProvider(corpID, secret, apiBaseURL string) *TokenProvider {
+	key := corpID + "\x00" + secret + "\x00" + apiBaseURL
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	p, ok := providers[key]
+	if !ok {
+		p = NewTokenProvider(corpID, secret, apiBaseURL)
+		providers[key] = p
+	}
+	return p
+}
+
+// Token 返回有效的 access_token；即将过期时在后台刷新并返回当前的 token，
+// 已过期时等待刷新完成，ctx 结束时不再等待
+func (p *TokenProvider) Token(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	now := time.Now()
+	if p.token != "" && now.Before(p.expiresAt) {
+		token := p.token
+		if now.After(p.expiresAt.Add(-tokenRefreshAhead)) {
+			p.refreshLocked()
+		}
+		p.mu.Unlock()
+		return token, nil
+	}
+	call := p.refreshLocked()
+	p.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Invalidate 接口返回 token 无效或过期时调用，token 仍是当前缓存的 token 时清空，下次获取时重新请求
+func (p *TokenProvider) Invalidate(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token == token {
+		p.token = ""
+	}
+}
+
+// refreshLocked 没有正在进行的刷新时开始刷新，调用时需持有 p.mu。
+// 刷新不绑定调用方的 ctx，避免一个调用方取消导致其他等待的调用方失败
+func (p *TokenProvider) refreshLocked() *tokenCall {
+	if p.call != nil {
+		return p.call
+	}
+	call := &tokenCall{done: make(chan struct{})}
+	p.call = call
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		token, expiresIn, err := p.fetch(ctx)
+		p.mu.Lock()
+		if err == nil {
+			p.token = token
+			p.expiresAt = time.Now().Add(expiresIn - tokenExpireAhead)
+		}
+		p.call = nil
+		p.mu.Unlock()
+		call.token, call.err = token, err
+		close(call.done)
+	}()
+	return call
+}
+
+// fetch 请求 gettoken 接口
+func (p *TokenProvider) fetch(ctx context.Context) (string, time.Duration, error) {
+	query := url.Values{"corpid": {p.corpID}, "corpsecret": {p.secret}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.apiBaseURL+"/gettoken?"+query.Encode(), nil)
+	if err != nil {
+		return "", 0, err
+	}
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		// URL 中包含 secret，不能出现在错误信息中
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = p.apiBaseURL + "/gettoken"
+		}
+		return "", 0, fmt.Errorf("获取企业微信 access_token 失败: %w", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, fmt.Errorf("获取企业微信 access_token 失败: %w", err)
+	}
+	var res accessToken
+	if err = json.Unmarshal(b, &res); err != nil {
+		if resp.StatusCode >= http.StatusBadRequest {
+			return "", 0, fmt.Errorf("获取企业微信 access_token 失败: %w", &notify.HTTPError{StatusCode: resp.StatusCode, Body: b})
+		}
+		return "", 0, fmt.Errorf("解析企业微信 access_token 失败: %w", err)
+	}
+	if res.ErrCode != 0 || res.AccessToken == "" {
+		return "", 0, notify.NewError(strconv.Itoa(res.ErrCode), retryableErrCodes[res.ErrCode],
+			fmt.Errorf("获取企业微信 access_token 失败: errmsg: %s errcode: %d", res.ErrMsg, res.ErrCode))
+	}
+	return res.AccessToken, time.Duration(res.ExpiresIn) * time.Second, nil
+}
@@ -1,24 +1,34 @@
 package wechat
 
 import (
-	"crypto/tls"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/markdown"
 	"github.com/v-mars/notify/result"
 	"github.com/v-mars/notify/types"
-	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
 
 var (
-	defaultMsgType  = "text"
-	MsgTypeText     = "text"
-	MsgTypeTextCard = "textcard"
-	MsgTypeMarkdown = "markdown"
+	defaultMsgType      = "text"
+	MsgTypeText         = "text"
+	MsgTypeTextCard     = "textcard"
+	MsgTypeMarkdown     = "markdown"
+	MsgTypeMarkdownV2   = "markdown_v2"
+	MsgTypeImage        = "image"
+	MsgTypeVoice        = "voice"
+	MsgTypeVideo        = "video"
+	MsgTypeFile         = "file"
+	MsgTypeNews         = "news"
+	MsgTypeMPNews       = "mpnews"
+	MsgTypeTemplateCard = "template_card"
 )
 
 //"textcard": map[string]interface{}{
@@ -28,25 +38,40 @@ var (
 //"btntext":     btntxt,
 //},
 
+// retryableErrCodes 可以重试的错误码：系统繁忙、接口调用频率或并发超限
+var retryableErrCodes = map[int]bool{
+	-1:    true,
+	45009: true,
+	45033: true,
+}
+
+// tokenErrCodes access_token 无效或过期，重新获取 token 后可以重试
+var tokenErrCodes = map[int]bool{
+	40014: true,
+	42001: true,
+}
+
 // Err 微信返回错误
 type Err struct {
 	ErrCode int    `json:"errcode"`
 	ErrMsg  string `json:"errmsg"`
 }
 
-// AccessToken 微信企业号请求Token
+// accessToken gettoken 接口的返回结果
 type accessToken struct {
 	AccessToken string `json:"access_token"`
 	ExpiresIn   int    `json:"expires_in"`
 	Err
-	ExpiresInTime time.Time
 }
 
 // Wecom 微信企业号应用配置信息
 type Wecom struct {
 	types.WecomConfig
-	TextCard       map[string]interface{} `json:"textcard"`
-	Token          accessToken
+	TextCard map[string]interface{} `json:"textcard"`
+	// Tokens access_token 缓存，为空时使用 CorpID 和 Secret 对应的共享缓存
+	Tokens *TokenProvider
+	// Media 临时素材缓存，为空时使用 CorpID 对应的共享缓存
+	Media          *MediaCache
 	toParty, toTag []string
 	MsgType        string
 }
@@ -55,8 +80,46 @@ type Wecom struct {
 type Result struct {
 	Err
 	InvalidUser  string `json:"invaliduser"`
-	InvalidParty string `json:"infvalidparty"`
+	InvalidParty string `json:"invalidparty"`
 	InvalidTag   string `json:"invalidtag"`
+	MsgID        string `json:"msgid"`
+	// ResponseCode 发送按钮和投票卡片时返回，用于更新卡片，72 小时内有效且只能使用一次
+	ResponseCode string `json:"response_code"`
+}
+
+// recipients 按接口返回的无效用户、部门和标签记录每个目标的结果，部门和标签以 party:、tag: 前缀区分
+// 所有目标都无效时返回错误
+func (r Result) recipients(sendResult *result.SendResult, msg Message) error {
+	type target struct {
+		prefix, ids, invalid string
+	}
+	total, failed := 0, 0
+	for _, t := range []target{
+		{"", msg.ToUser, r.InvalidUser},
+		{"party:", msg.ToParty, r.InvalidParty},
+		{"tag:", msg.ToTag, r.InvalidTag},
+	} {
+		invalid := map[string]bool{}
+		for _, id := range strings.Split(t.invalid, "|") {
+			invalid[id] = id != ""
+		}
+		for _, id := range strings.Split(t.ids, "|") {
+			if id == "" {
+				continue
+			}
+			total++
+			if invalid[id] {
+				failed++
+				sendResult.AddRecipient(t.prefix+id, "", fmt.Errorf("无效的接收目标: %s%s", t.prefix, id))
+				continue
+			}
+			sendResult.AddRecipient(t.prefix+id, r.MsgID, nil)
+		}
+	}
+	if total > 0 && failed == total {
+		return notify.Permanent(fmt.Errorf("所有目标都无法送达: %s %s %s", r.InvalidUser, r.InvalidParty, r.InvalidTag))
+	}
+	return nil
 }
 
 // Content 文本消息内容
@@ -74,6 +137,15 @@ type Message struct {
 	Text     Content                `json:"text"`
 	Markdown Content                `json:"markdown"`
 	TextCard map[string]interface{} `json:"textcard"`
+	// TemplateCard 模板卡片，msgtype 为 template_card 时使用
+	TemplateCard *TemplateCard `json:"template_card,omitempty"`
+	// Image、Voice、Video、File、News、MPNews 使用 NewImageMessage 等创建
+	Image  *Media  `json:"image,omitempty"`
+	Voice  *Media  `json:"voice,omitempty"`
+	Video  *Video  `json:"video,omitempty"`
+	File   *Media  `json:"file,omitempty"`
+	News   *News   `json:"news,omitempty"`
+	MPNews *MPNews `json:"mpnews,omitempty"`
 }
 
 // NewWeChat init wechat notidy
@@ -94,7 +166,21 @@ func NewWeChat(cropID string, agentID int, agentSecret string,
 }
 
 // Send format send msg to Message
-func (c *Wecom) Send(tos []string, title, content string) (sendResult *result.SendResult, err error) {
+func (c *Wecom) Send(tos []string, title, content string) (*result.SendResult, error) {
+	return c.SendContext(context.Background(), tos, title, content)
+}
+
+// SendContext 发送消息，获取 token 和发送消息的请求都绑定 ctx
+func (c *Wecom) SendContext(ctx context.Context, tos []string, title, content string) (*result.SendResult, error) {
+	return c.SendMessage(ctx, tos, notify.NewMessage(title, content))
+}
+
+// SendMessage 发送结构化消息
+// 消息带有 Markdown 正文或链接时，text 类型自动升级为 markdown；
+// textcard 类型未配置 TextCard 时使用消息标题、正文和第一个链接生成卡片；
+// 附件在消息发送后依次以图片或文件消息发送；
+// Metadata 中带有 MetadataTemplateCard 或 MetadataMessage 时发送该模板卡片或消息
+func (c *Wecom) SendMessage(ctx context.Context, tos []string, m *notify.Message) (sendResult *result.SendResult, err error) {
 	sendResult = &result.SendResult{
 		ChannelType:  NotifyTypeWecom,
 		ChannelMsgID: nil,
@@ -106,33 +192,153 @@ func (c *Wecom) Send(tos []string, title, content string) (sendResult *result.Se
 	}
 	defer func() {
 		sendResult.CostMs = time.Now().Sub(sendResult.SendTime).Milliseconds()
-		sendResult.ChannelMsgID = result.PtrOf(fmt.Sprintf("%d", time.Now().UnixNano()))
+		sendResult.MessageID = fmt.Sprintf("%d", time.Now().UnixNano())
+		if sendResult.ChannelMsgID == nil {
+			sendResult.ChannelMsgID = result.PtrOf(sendResult.MessageID)
+		}
 		sendResult.Success = err == nil
-		sendResult.MessageID = *sendResult.ChannelMsgID
 		if err != nil {
 			sendResult.Error = result.PtrOf(err.Error())
 		}
 	}()
+	card, err := notify.MetadataValue[TemplateCard](m, MetadataTemplateCard)
+	if err != nil {
+		return sendResult, err
+	}
+	if card != nil {
+		return sendResult, c.deliverPayload(ctx, sendResult, tos, &Message{MsgType: MsgTypeTemplateCard, TemplateCard: card})
+	}
+	p, err := notify.MetadataValue[Message](m, MetadataMessage)
+	if err != nil {
+		return sendResult, err
+	}
+	if p != nil {
+		return sendResult, c.deliverPayload(ctx, sendResult, tos, p)
+	}
+	msgType := c.MsgType
+	if msgType == MsgTypeText && (m.Markdown != "" || len(m.Links) > 0) {
+		msgType = MsgTypeMarkdown
+	}
+	textCard := c.TextCard
+	if msgType == MsgTypeTextCard && textCard == nil {
+		textCard = map[string]interface{}{
+			"title":       m.Title,
+			"description": m.TextBody(),
+		}
+		if len(m.Links) > 0 {
+			textCard["url"] = m.Links[0].URL
+			textCard["btntxt"] = m.Links[0].Text
+		}
+	}
+	markdownBody := m.Title + "\n" + m.TextBody()
+	if m.Markdown != "" {
+		markdownBody = m.Title + "\n" + markdown.Convert(m.Markdown, markdown.WeCom)
+	}
+	textBody := m.Title + "\n" + m.TextBody()
+	for _, link := range m.Links {
+		markdownBody += fmt.Sprintf("\n[%s](%s)", link.Text, link.URL)
+		textBody += "\n" + link.Text + ": " + link.URL
+	}
 	msg := Message{
 		ToUser:  strings.Join(tos, "|"),
 		ToParty: strings.Join(c.toParty, "|"),
 		ToTag:   strings.Join(c.toTag, "|"),
-		MsgType: c.MsgType,
+		MsgType: msgType,
 		Markdown: Content{
-			Content: title + "\n" + content,
+			Content: markdownBody,
 		},
-		TextCard: c.TextCard,
+		TextCard: textCard,
 		Text: Content{
-			Content: title + "\n" + content,
+			Content: textBody,
 		},
 		AgentID: c.AgentID,
 	}
-	if err = c.send(msg); err != nil {
+	if err = c.deliver(ctx, sendResult, msg); err != nil {
 		return sendResult, err
-
 	}
+	c.sendAttachments(ctx, sendResult, msg, m.Attachments)
 	return sendResult, nil
 }
+
+// SendPayload 校验并发送用 NewImageMessage、NewNewsMessage 等创建的消息，校验失败时不发送，返回永久错误。
+// tos 不为空时替换消息的 touser，未设置 toparty、totag 和 agentid 时使用应用的配置
+func (c *Wecom) SendPayload(ctx context.Context, tos []string, msg *Message) (*result.SendResult, error) {
+	return c.SendMessage(ctx, tos, &notify.Message{Metadata: map[string]any{MetadataMessage: msg}})
+}
+
+// SendCard 校验并发送模板卡片，校验失败时不发送，返回永久错误。
+// 按钮和投票卡片的点击事件由 CallbackHandler 接收，事件中的 ResponseCode 用于更新卡片
+func (c *Wecom) SendCard(ctx context.Context, tos []string, card *TemplateCard) (*result.SendResult, error) {
+	return c.SendMessage(ctx, tos, &notify.Message{Metadata: map[string]any{MetadataTemplateCard: card}})
+}
+
+// deliverPayload 校验消息并补全发送目标后发送
+func (c *Wecom) deliverPayload(ctx context.Context, sendResult *result.SendResult, tos []string, p *Message) error {
+	if err := p.Validate(); err != nil {
+		return notify.Permanent(fmt.Errorf("企业微信消息校验失败: %w", err))
+	}
+	msg := *p
+	if len(tos) > 0 {
+		msg.ToUser = strings.Join(tos, "|")
+	}
+	if msg.ToParty == "" {
+		msg.ToParty = strings.Join(c.toParty, "|")
+	}
+	if msg.ToTag == "" {
+		msg.ToTag = strings.Join(c.toTag, "|")
+	}
+	if msg.AgentID == 0 {
+		msg.AgentID = c.AgentID
+	}
+	return c.deliver(ctx, sendResult, msg)
+}
+
+// deliver 发送消息并按接口结果记录每个目标，部分目标无效时仍视为发送成功，失败的目标见 Recipients
+func (c *Wecom) deliver(ctx context.Context, sendResult *result.SendResult, msg Message) error {
+	r, err := c.send(ctx, msg)
+	if err != nil {
+		return err
+	}
+	if r.MsgID != "" {
+		sendResult.ChannelMsgID = &r.MsgID
+	}
+	return r.recipients(sendResult, msg)
+}
+
+// UpdateButton 把按钮卡片的所有按钮替换为不可点击的 replaceName，如"已处理"。
+// responseCode 为回调事件中的 ResponseCode，userIds 为空时更新所有收到卡片的人
+func (c *Wecom) UpdateButton(ctx context.Context, responseCode, replaceName string, userIds ...string) error {
+	return c.updateCard(ctx, responseCode, userIds, "button", map[string]string{"replace_name": replaceName})
+}
+
+// UpdateTemplateCard 用新的卡片替换已发送的卡片，userIds 为空时更新所有收到卡片的人
+func (c *Wecom) UpdateTemplateCard(ctx context.Context, responseCode string, card *TemplateCard, userIds ...string) error {
+	if err := card.Validate(); err != nil {
+		return notify.Permanent(fmt.Errorf("企业微信模板卡片校验失败: %w", err))
+	}
+	return c.updateCard(ctx, responseCode, userIds, "template_card", card)
+}
+
+// updateCard 调用 update_template_card 接口
+func (c *Wecom) updateCard(ctx context.Context, responseCode string, userIds []string, field string, value any) error {
+	if responseCode == "" {
+		return notify.Permanent(errors.New("更新模板卡片需要 response_code"))
+	}
+	body := map[string]any{
+		"agentid":       c.AgentID,
+		"response_code": responseCode,
+		field:           value,
+	}
+	if len(userIds) > 0 {
+		body["userids"] = userIds
+	} else {
+		body["atall"] = 1
+	}
+	if _, err := c.post(ctx, "/message/update_template_card", body); err != nil {
+		return fmt.Errorf("更新企业微信模板卡片失败: %w", err)
+	}
+	return nil
+}
 func (c *Wecom) SendV2(tos, toParty, toTag []string, title, content string, msgTextCard map[string]interface{}) (sendResult *result.SendResult, err error) {
 	sendResult = &result.SendResult{
 		ChannelType:  NotifyTypeWecom,
@@ -145,10 +351,14 @@ func (c *Wecom) SendV2(tos, toParty, toTag []string, title, content string, msgT
 	}
 	defer func() {
 		sendResult.CostMs = time.Now().Sub(sendResult.SendTime).Milliseconds()
-		sendResult.ChannelMsgID = result.PtrOf(fmt.Sprintf("%d", time.Now().UnixNano()))
+		sendResult.MessageID = fmt.Sprintf("%d", time.Now().UnixNano())
+		if sendResult.ChannelMsgID == nil {
+			sendResult.ChannelMsgID = result.PtrOf(sendResult.MessageID)
+		}
 		sendResult.Success = err == nil
-		sendResult.MessageID = *sendResult.ChannelMsgID
-		sendResult.Error = result.PtrOf(err.Error())
+		if err != nil {
+			sendResult.Error = result.PtrOf(err.Error())
+		}
 	}()
 	msg := Message{
 		ToUser:  strings.Join(tos, "|"),
@@ -164,95 +374,101 @@ func (c *Wecom) SendV2(tos, toParty, toTag []string, title, content string, msgT
 		},
 		AgentID: c.AgentID,
 	}
-	if err = c.send(msg); err != nil {
+	r, err := c.send(context.Background(), msg)
+	if err != nil {
 		return sendResult, err
-
 	}
-	return sendResult, nil
+	if r.MsgID != "" {
+		sendResult.ChannelMsgID = &r.MsgID
+	}
+	return sendResult, r.recipients(sendResult, msg)
 }
 
-// Send 发送信息
-func (c *Wecom) send(msg Message) error {
-	c.generateAccessToken()
+// send 发送信息，返回接口结果，由调用方处理部分目标无效的情况
+func (c *Wecom) send(ctx context.Context, msg Message) (r Result, err error) {
+	return c.post(ctx, "/message/send", msg)
+}
 
-	url := "https://qyapi.weixin.qq.com/cgi-bin/message/send?access_token=" + c.Token.AccessToken
-	resultByte, err := notify.JSONPost(http.MethodPost, url, msg, http.DefaultClient, nil)
+// post 带 access_token 调用应用接口，token 失效时清空缓存并返回可重试的错误
+func (c *Wecom) post(ctx context.Context, path string, body any) (r Result, err error) {
+	tokens := c.tokenProvider()
+	token, err := tokens.Token(ctx)
 	if err != nil {
-		err = errors.New("请求微信接口失败: " + err.Error())
-		return err
+		return r, err
+	}
+
+	api := c.apiBaseURL() + path
+	resultByte, err := notify.JSONPostContext(ctx, http.MethodPost, api+"?access_token="+url.QueryEscape(token), body, apiClient, nil)
+	if err != nil {
+		// 网络错误的 URL 中包含 access_token，不能出现在错误信息中
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = api
+		}
+		err = fmt.Errorf("请求微信接口失败: %w", err)
+		return r, err
 	}
-	r := Result{}
 	err = json.Unmarshal(resultByte, &r)
 	if err != nil {
 		err = errors.New("解析微信接口返回数据失败: " + err.Error())
-		return err
+		return r, err
 	}
 
 	if r.ErrCode != 0 {
-		err = errors.New("发送消息失败: " + r.ErrMsg)
-		return err
-
+		return r, apiError(tokens, token, r.Err)
 	}
+	return r, nil
+}
 
-	if r.InvalidUser != "" || r.InvalidTag != "" || r.InvalidParty != "" {
-		err = fmt.Errorf("消息发送成功, 但是有部分目标无法送达: %s %s %s", r.InvalidUser, r.InvalidParty, r.InvalidTag)
-		return err
+// apiError 接口返回的错误码转换为 error，token 失效时清空缓存，下次请求重新获取
+func apiError(tokens *TokenProvider, token string, e Err) error {
+	if tokenErrCodes[e.ErrCode] {
+		tokens.Invalidate(token)
 	}
-	return nil
+	return notify.NewError(strconv.Itoa(e.ErrCode), retryableErrCodes[e.ErrCode] || tokenErrCodes[e.ErrCode],
+		fmt.Errorf("errmsg: %s errcode: %d", e.ErrMsg, e.ErrCode))
 }
 
 func (c *Wecom) SetMsgType(msgType string) {
 	defaultMsgType = msgType
 }
 
-// generateAccessToken 生成会话token
-func (c *Wecom) generateAccessToken() {
-	var err error
-	if c.Token.AccessToken == "" || c.Token.ExpiresInTime.Before(time.Now()) {
-		c.Token, err = getAccessTokenFromWeixin(c.CorpID, c.Secret)
-		if err != nil {
-			return
-		}
-		c.Token.ExpiresInTime = time.Now().Add(time.Duration(c.Token.ExpiresIn-1000) * time.Second)
+// tokenProvider 未设置 Tokens 时使用 CorpID 和 Secret 对应的共享缓存
+func (c *Wecom) tokenProvider() *TokenProvider {
+	if c.Tokens != nil {
+		return c.Tokens
 	}
+	return SharedTokenProvider(c.CorpID, c.Secret, c.APIBaseURL)
 }
 
-const NotifyTypeWecom = "wecom"
-
-func (c *Wecom) ChannelType() string {
-	return NotifyTypeWecom
-}
-
-// 从微信服务器获取token
-func getAccessTokenFromWeixin(cropID, secret string) (TokenSession accessToken, err error) {
-	WxAccessTokenURL := "https://qyapi.weixin.qq.com/cgi-bin/gettoken?corpid=" + cropID + "&corpsecret=" + secret
-
-	tr := &http.Transport{
-		TLSClientConfig:    &tls.Config{InsecureSkipVerify: true},
-		DisableCompression: true,
-	}
-	client := &http.Client{Transport: tr}
-	result, err := client.Get(WxAccessTokenURL)
-	if err != nil {
-		return
-	}
-
-	res, err := ioutil.ReadAll(result.Body)
-	if err != nil {
-		return
+func (c *Wecom) apiBaseURL() string {
+	if c.APIBaseURL == "" {
+		return defaultAPIBaseURL
 	}
+	return strings.TrimRight(c.APIBaseURL, "/")
+}
 
-	defer result.Body.Close()
+const (
+	// MetadataTemplateCard notify.Message.Metadata 中的 *TemplateCard，SendMessage 发送该模板卡片
+	MetadataTemplateCard = "wecom_template_card"
+	// MetadataMessage notify.Message.Metadata 中的 *Message，通过 Manager 发送图片、文件、图文等消息
+	MetadataMessage = "wecom_message"
+)
 
-	err = json.Unmarshal(res, &TokenSession)
-	if err != nil {
-		return
-	}
+const NotifyTypeWecom = "wecom"
 
-	if TokenSession.ExpiresIn == 0 || TokenSession.AccessToken == "" {
-		err = fmt.Errorf("获取微信错误代码: %v, 错误信息: %v", TokenSession.ErrCode, TokenSession.ErrMsg)
-		return
-	}
+func init() {
+	notify.Register(NotifyTypeWecom, func(section notify.Section) (notify.Sender, error) {
+		var conf types.WecomConfig
+		if err := section.Decode(&conf); err != nil {
+			return nil, err
+		}
+		c := NewWeChat(conf.CorpID, conf.AgentID, conf.Secret, nil, nil, nil)
+		c.WecomConfig = conf
+		return c, nil
+	})
+}
 
-	return
+func (c *Wecom) ChannelType() string {
+	return NotifyTypeWecom
 }
@@ -1,6 +1,28 @@
 package wechat
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/v-mars/notify"
+	"github.com/v-mars/notify/result"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
 
 func TestNewWeChat(t *testing.T) {
 	api := NewWeChat("xx", 100, "xx",
@@ -11,3 +33,462 @@ func TestNewWeChat(t *testing.T) {
 		return
 	}
 }
+
+func TestResultRecipients(t *testing.T) {
+	msg := Message{ToUser: "u1|u2|u3", ToParty: "10"}
+	r := Result{InvalidUser: "u2", MsgID: "msg-1"}
+	sr := &result.SendResult{}
+	if err := r.recipients(sr, msg); err != nil {
+		t.Fatalf("partial invalid users err = %v, want nil", err)
+	}
+	if len(sr.Recipients) != 4 {
+		t.Fatalf("got %d recipients, want 4", len(sr.Recipients))
+	}
+	if failed := sr.FailedRecipients(); len(failed) != 1 || failed[0] != "u2" {
+		t.Errorf("failed recipients = %v, want [u2]", failed)
+	}
+	if got := sr.Recipients[3]; got.To != "party:10" || !got.Success || got.ChannelMsgID == nil || *got.ChannelMsgID != "msg-1" {
+		t.Errorf("party recipient = %+v", got)
+	}
+
+	sr = &result.SendResult{}
+	if err := (Result{InvalidUser: "u1"}).recipients(sr, Message{ToUser: "u1"}); err == nil {
+		t.Error("all targets invalid should return an error")
+	}
+}
+
+func TestRobot(t *testing.T) {
+	var got []RobotMsg
+	var uploaded string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") != "k1" {
+			t.Errorf("key = %q", r.URL.Query().Get("key"))
+		}
+		if r.URL.Path == "/cgi-bin/webhook/upload_media" {
+			f, h, err := r.FormFile("media")
+			if err != nil || r.URL.Query().Get("type") != "file" {
+				t.Fatalf("upload %v %s", err, r.URL.RawQuery)
+			}
+			b, _ := io.ReadAll(f)
+			if h.Filename == "bad.log" {
+				_, _ = w.Write([]byte(`{"errcode":40004,"errmsg":"invalid media type"}`))
+				return
+			}
+			uploaded = h.Filename + ":" + string(b)
+			_, _ = w.Write([]byte(`{"errcode":0,"errmsg":"ok","type":"file","media_id":"m1"}`))
+			return
+		}
+		var m RobotMsg
+		_ = json.NewDecoder(r.Body).Decode(&m)
+		got = append(got, m)
+		if m.MsgType == MsgTypeNews {
+			_, _ = w.Write([]byte(`{"errcode":93000,"errmsg":"invalid webhook url"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	}))
+	defer srv.Close()
+
+	r := NewRobot(srv.URL + "/cgi-bin/webhook/send?key=k1")
+	msg := &notify.Message{
+		Title:       "告警",
+		Markdown:    "**cpu** high",
+		Mentions:    notify.Mentions{All: true},
+		Attachments: []notify.Attachment{{Name: "panel.png", Data: []byte("png")}, {Name: "app.log", Data: []byte("log")}},
+	}
+	res, err := r.SendMessage(t.Context(), []string{"zhangsan", "13800001111"}, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("requests = %+v", got)
+	}
+	if got[0].MsgType != MsgTypeMarkdown || got[0].Markdown.Content != "告警\n**cpu** high\n<@zhangsan>" {
+		t.Errorf("markdown = %+v", got[0].Markdown)
+	}
+	if remind := got[1].Text; remind == nil || remind.MentionedList[0] != "@all" || remind.MentionedMobileList[0] != "13800001111" {
+		t.Errorf("remind = %+v", got[1])
+	}
+	if got[2].Image == nil || got[2].Image.MD5 != "bff139fa05ac583f685a523ab3d110a0" || got[2].Image.Base64 != "cG5n" {
+		t.Errorf("image = %+v", got[2].Image)
+	}
+	if got[3].File == nil || got[3].File.MediaID != "m1" || uploaded != "app.log:log" {
+		t.Errorf("file = %+v uploaded = %q", got[3].File, uploaded)
+	}
+	if len(res.Recipients) != 2 || res.FailedRecipients() != nil {
+		t.Errorf("recipients = %+v", res.Recipients)
+	}
+
+	// 主消息已送达时附件失败不影响发送结果，记录在 Warnings 中
+	msg = &notify.Message{Title: "告警", Text: "cpu high", Attachments: []notify.Attachment{{Name: "bad.log", Data: []byte("log")}}}
+	res, err = r.SendMessage(t.Context(), nil, msg)
+	if err != nil || !res.Success || len(res.Warnings) != 1 || !strings.Contains(res.Warnings[0], "bad.log") {
+		t.Errorf("attachment failure = %+v, %v", res, err)
+	}
+
+	got = nil
+	if _, err = r.SendPayload(t.Context(), []string{"lisi"}, NewRobotText("hi").MentionMobiles("13900002222")); err != nil {
+		t.Fatal(err)
+	}
+	if text := got[0].Text; text.MentionedList[0] != "lisi" || text.MentionedMobileList[0] != "13900002222" {
+		t.Errorf("text = %+v", text)
+	}
+
+	_, err = r.SendPayload(t.Context(), nil, NewRobotNews(Article{Title: "a", URL: "https://x.io"}))
+	if err == nil || notify.IsRetryable(err) || !strings.Contains(err.Error(), "93000") {
+		t.Errorf("news err = %v", err)
+	}
+	_, err = r.SendPayload(t.Context(), nil, NewRobotTemplateCard(NewTextNoticeCard("t", "d", "")))
+	if err == nil || !strings.Contains(err.Error(), "card_action.url") {
+		t.Errorf("card err = %v", err)
+	}
+}
+
+func TestTemplateCard(t *testing.T) {
+	card := NewTextNoticeCard("CPU 告警", "prod-api-01", "https://grafana.io/d/1").
+		Emphasis("95%", "CPU 使用率").
+		Field("集群", "prod").
+		FieldLink("详情", "查看", "https://x.io").
+		JumpTo("处理手册", "https://wiki.io")
+	if err := card.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(NewRobotTemplateCard(card))
+	want := `{"msgtype":"template_card","template_card":{"card_type":"text_notice","main_title":{"title":"CPU 告警","desc":"prod-api-01"},` +
+		`"emphasis_content":{"title":"95%","desc":"CPU 使用率"},"horizontal_content_list":[{"keyname":"集群","value":"prod"},` +
+		`{"keyname":"详情","value":"查看","type":1,"url":"https://x.io"}],"jump_list":[{"type":1,"url":"https://wiki.io","title":"处理手册"}],` +
+		`"card_action":{"type":1,"url":"https://grafana.io/d/1"}}}`
+	if string(b) != want {
+		t.Errorf("card = %s", b)
+	}
+	if err := NewNewsNoticeCard("t", "", "", "https://x.io").Validate(); err == nil || !strings.Contains(err.Error(), "card_image") {
+		t.Errorf("news_notice err = %v", err)
+	}
+}
+
+func TestTokenProvider(t *testing.T) {
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := fetches.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		_ = json.NewEncoder(w).Encode(map[string]any{"errcode": 0, "access_token": "tk" + strconv.Itoa(int(n)), "expires_in": 7200})
+	}))
+	defer srv.Close()
+
+	p := NewTokenProvider("corp", "secret", srv.URL)
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if token, err := p.Token(t.Context()); err != nil || token != "tk1" {
+				t.Errorf("token = %q, %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("fetches = %d, want 1", n)
+	}
+
+	// 即将过期时返回当前 token，并在后台刷新
+	p.mu.Lock()
+	p.expiresAt = time.Now().Add(time.Minute)
+	p.mu.Unlock()
+	if token, _ := p.Token(t.Context()); token != "tk1" {
+		t.Errorf("token before refresh = %q", token)
+	}
+	deadline := time.Now().Add(time.Second)
+	for token, _ := p.Token(t.Context()); token != "tk2"; token, _ = p.Token(t.Context()) {
+		if time.Now().After(deadline) {
+			t.Fatal("token was not refreshed in background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	p.Invalidate("tk1") // 旧 token 不影响当前 token
+	p.Invalidate("tk2")
+	if token, _ := p.Token(t.Context()); token != "tk3" {
+		t.Errorf("token after invalidate = %q", token)
+	}
+	if SharedTokenProvider("corp", "secret", srv.URL) != SharedTokenProvider("corp", "secret", srv.URL) {
+		t.Error("shared providers differ")
+	}
+}
+
+func TestWecomToken(t *testing.T) {
+	var fetches, sends int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gettoken" {
+			fetches++
+			if r.URL.Query().Get("corpsecret") == "bad" {
+				_, _ = w.Write([]byte(`{"errcode":40001,"errmsg":"invalid credential"}`))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"errcode": 0, "access_token": "tk" + strconv.Itoa(fetches), "expires_in": 7200})
+			return
+		}
+		sends++
+		if r.URL.Query().Get("access_token") == "tk1" {
+			_, _ = w.Write([]byte(`{"errcode":42001,"errmsg":"access_token expired"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"errcode":0,"errmsg":"ok","msgid":"m1"}`))
+	}))
+	defer srv.Close()
+
+	c := NewWeChat("corp", 1, "secret", nil, nil, nil)
+	c.APIBaseURL = srv.URL
+	_, err := c.Send([]string{"u1"}, "t", "c")
+	if err == nil || !notify.IsRetryable(err) {
+		t.Fatalf("expired token err = %v", err)
+	}
+	// 每次发送都创建新的 Wecom 时共用 token
+	c = NewWeChat("corp", 1, "secret", nil, nil, nil)
+	c.APIBaseURL = srv.URL
+	for range 2 {
+		if _, err = c.Send([]string{"u1"}, "t", "c"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fetches != 2 || sends != 3 {
+		t.Errorf("fetches = %d sends = %d", fetches, sends)
+	}
+
+	c = NewWeChat("corp", 1, "bad", nil, nil, nil)
+	c.APIBaseURL = srv.URL
+	res, err := c.Send([]string{"u1"}, "t", "c")
+	if err == nil || res.Error == nil || !strings.Contains(*res.Error, "40001") {
+		t.Errorf("token error = %v, result = %+v", err, res)
+	}
+}
+
+func TestInteractiveCard(t *testing.T) {
+	card := NewButtonCard("告警 #1024", "CPU 95%", "task-1024",
+		CallbackButton("认领", "ack", 1), LinkButton("看板", "https://grafana.io/d/1", 2))
+	if err := card.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(card)
+	if !strings.Contains(string(b), `"task_id":"task-1024"`) || !strings.Contains(string(b), `"button_list":[{"text":"认领","style":1,"key":"ack"}`) {
+		t.Errorf("button card = %s", b)
+	}
+	vote := NewVoteCard("值班", "", "task-2", "who", Option("a", "张三"), Option("b", "李四")).Multiple()
+	if err := vote.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewButtonCard("t", "", "bad id!", CallbackButton("x", "k", 1)).Validate(); err == nil {
+		t.Error("invalid task_id passed")
+	}
+	if err := NewVoteCard("t", "", "task-3", "q").Validate(); err == nil {
+		t.Error("vote card without options passed")
+	}
+}
+
+// encryptCallback 按企业微信的格式加密回调消息
+func encryptCallback(t *testing.T, encodingAESKey, msg, receiveID string) string {
+	key, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plain bytes.Buffer
+	plain.WriteString("0123456789abcdef")
+	_ = binary.Write(&plain, binary.BigEndian, uint32(len(msg)))
+	plain.WriteString(msg + receiveID)
+	pad := 32 - plain.Len()%32
+	plain.Write(bytes.Repeat([]byte{byte(pad)}, pad))
+	block, _ := aes.NewCipher(key)
+	data := make([]byte, plain.Len())
+	cipher.NewCBCEncrypter(block, key[:aes.BlockSize]).CryptBlocks(data, plain.Bytes())
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func TestCallbackHandler(t *testing.T) {
+	const token, aesKey = "tk", "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFG"
+	updates := make(chan map[string]any, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gettoken" {
+			_, _ = w.Write([]byte(`{"errcode":0,"access_token":"at","expires_in":7200}`))
+			return
+		}
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path == "/message/update_template_card" {
+			updates <- body
+		} else if body["msgtype"] != "template_card" || body["template_card"] == nil {
+			t.Errorf("send body = %v", body)
+		}
+		_, _ = w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	}))
+	defer srv.Close()
+
+	client := NewWeChat("corp", 7, "callback-secret", nil, nil, nil)
+	client.APIBaseURL = srv.URL
+	if _, err := NewCallbackHandler("", aesKey, "corp", client); err == nil {
+		t.Error("NewCallbackHandler without token should fail")
+	}
+	h, err := NewCallbackHandler(token, aesKey, "corp", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got *CardEvent
+	var calls int
+	h.Handle("ack", func(ctx context.Context, event *CardEvent) (*CardUpdate, error) {
+		got = event
+		calls++
+		return &CardUpdate{ReplaceName: "已认领"}, nil
+	})
+
+	res, err := client.SendCard(t.Context(), []string{"zhangsan"}, NewButtonCard("告警", "", "task-1024", CallbackButton("认领", "ack", 1)))
+	if err != nil || !res.Success {
+		t.Fatalf("send card = %v", err)
+	}
+	if _, err = client.SendCard(t.Context(), nil, NewButtonCard("告警", "", "task-1024")); err == nil || notify.IsRetryable(err) {
+		t.Errorf("invalid card err = %v", err)
+	}
+
+	// 验证回调 URL
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	echo := encryptCallback(t, aesKey, "echo-123", "corp")
+	q := url.Values{"msg_signature": {Signature(token, now, "n", echo)}, "timestamp": {now}, "nonce": {"n"}, "echostr": {echo}}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "echo-123" {
+		t.Fatalf("verify url = %d %q", rec.Code, rec.Body.String())
+	}
+
+	event := `<xml><ToUserName>corp</ToUserName><FromUserName>zhangsan</FromUserName><CreateTime>1700000000</CreateTime>` +
+		`<MsgType>event</MsgType><Event>template_card_event</Event><EventKey>ack</EventKey><TaskId>task-1024</TaskId>` +
+		`<CardType>button_interaction</CardType><ResponseCode>rc-1</ResponseCode><AgentID>7</AgentID></xml>`
+	encrypted := encryptCallback(t, aesKey, event, "corp")
+	post := func(timestamp, signature string) *httptest.ResponseRecorder {
+		q := url.Values{"msg_signature": {signature}, "timestamp": {timestamp}, "nonce": {"m"}}
+		body := fmt.Sprintf("<xml><ToUserName>corp</ToUserName><Encrypt>%s</Encrypt></xml>", encrypted)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/?"+q.Encode(), strings.NewReader(body)))
+		return rec
+	}
+	if rec = post(now, "bad"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("bad signature = %d", rec.Code)
+	}
+	if rec = post(now, Signature(token, now, "m", encrypted)); rec.Code != http.StatusOK {
+		t.Fatalf("event = %d %s", rec.Code, rec.Body.String())
+	}
+	// 重放的请求不再调用处理函数，超出 MaxSkew 的请求直接拒绝
+	if rec = post(now, Signature(token, now, "m", encrypted)); rec.Code != http.StatusOK || calls != 1 {
+		t.Errorf("replayed event = %d, handler called %d times", rec.Code, calls)
+	}
+	stale := strconv.FormatInt(time.Now().Add(-2*time.Hour).Unix(), 10)
+	if rec = post(stale, Signature(token, stale, "m", encrypted)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("stale timestamp = %d", rec.Code)
+	}
+	if got == nil || got.UserID != "zhangsan" || got.TaskID != "task-1024" || got.ResponseCode != "rc-1" {
+		t.Fatalf("event = %+v", got)
+	}
+	select {
+	case body := <-updates:
+		button, _ := body["button"].(map[string]any)
+		if body["response_code"] != "rc-1" || button["replace_name"] != "已认领" || fmt.Sprint(body["userids"]) != "[zhangsan]" {
+			t.Errorf("update = %v", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("card not updated")
+	}
+
+	if _, _, err := Decrypt(aesKey, encryptCallback(t, aesKey, "x", "other")); err != nil {
+		t.Fatal(err)
+	}
+	h2, _ := NewCallbackHandler(token, aesKey, "corp", nil)
+	other := encryptCallback(t, aesKey, "x", "other")
+	q = url.Values{"msg_signature": {Signature(token, now, "n", other)}, "timestamp": {now}, "nonce": {"n"}, "echostr": {other}}
+	rec = httptest.NewRecorder()
+	h2.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("wrong receiveid = %d", rec.Code)
+	}
+}
+
+func TestWecomMedia(t *testing.T) {
+	var mu sync.Mutex
+	var uploads []string
+	var sent []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/gettoken":
+			_, _ = w.Write([]byte(`{"errcode":0,"access_token":"at","expires_in":7200}`))
+		case "/media/upload":
+			f, h, err := r.FormFile("media")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			b, _ := io.ReadAll(f)
+			uploads = append(uploads, r.URL.Query().Get("type")+":"+h.Filename+":"+string(b))
+			_ = json.NewEncoder(w).Encode(map[string]any{"errcode": 0, "type": r.URL.Query().Get("type"),
+				"media_id": "mid" + strconv.Itoa(len(uploads)), "created_at": strconv.FormatInt(time.Now().Unix(), 10)})
+		default:
+			var body map[string]any
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			sent = append(sent, body)
+			_, _ = w.Write([]byte(`{"errcode":0,"errmsg":"ok","msgid":"m1"}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := NewWeChat("corp", 1, "media-secret", nil, nil, nil)
+	c.APIBaseURL = srv.URL
+	c.Media = NewMediaCache()
+	for range 2 {
+		id, err := c.UploadMedia(t.Context(), "image", "panel.png", strings.NewReader("png-data"))
+		if err != nil || id != "mid1" {
+			t.Fatalf("upload = %s, %v", id, err)
+		}
+	}
+	if len(uploads) != 1 {
+		t.Errorf("uploads = %v", uploads)
+	}
+	if _, err := c.UploadMedia(t.Context(), "file", "a.log", strings.NewReader("x")); err == nil || notify.IsRetryable(err) {
+		t.Errorf("small media err = %v", err)
+	}
+
+	path := t.TempDir() + "/app.log"
+	if err := os.WriteFile(path, []byte("log bundle"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	msg := notify.NewMessage("告警", "CPU 95%")
+	msg.Attachments = []notify.Attachment{{Name: "panel.png", Data: []byte("png-data")}, {Path: path}}
+	if _, err := c.SendMessage(t.Context(), []string{"u1"}, msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 2 || uploads[1] != "file:app.log:log bundle" {
+		t.Errorf("uploads = %v", uploads)
+	}
+	if len(sent) != 3 || sent[1]["msgtype"] != "image" || sent[2]["msgtype"] != "file" || sent[2]["touser"] != "u1" {
+		t.Fatalf("sent = %v", sent)
+	}
+	if file, _ := sent[2]["file"].(map[string]any); file["media_id"] != "mid2" {
+		t.Errorf("file = %v", sent[2])
+	}
+	// 主消息已送达时附件失败不影响发送结果，记录在 Warnings 中
+	msg.Attachments = []notify.Attachment{{Path: path + ".missing"}}
+	res, err := c.SendMessage(t.Context(), []string{"u1"}, msg)
+	if err != nil || !res.Success || len(res.Warnings) != 1 || !strings.Contains(res.Warnings[0], "app.log.missing") {
+		t.Fatalf("missing attachment = %+v, %v", res, err)
+	}
+	sent = sent[:3]
+
+	_, err = c.SendPayload(t.Context(), []string{"u2"}, NewNewsMessage(Article{Title: "看板", URL: "https://grafana.io/d/1"}))
+	if err != nil || sent[3]["msgtype"] != "news" || sent[3]["touser"] != "u2" || sent[3]["agentid"] != float64(1) {
+		t.Errorf("news = %v, %v", sent[len(sent)-1], err)
+	}
+	_, err = c.SendPayload(t.Context(), nil, NewMPNewsMessage(MPArticle{Title: "日志"}))
+	if err == nil || notify.IsRetryable(err) || !strings.Contains(err.Error(), "thumb_media_id") {
+		t.Errorf("mpnews err = %v", err)
+	}
+	b, _ := json.Marshal(NewVideoMessage("v1", "录屏", ""))
+	if !strings.Contains(string(b), `"video":{"media_id":"v1","title":"录屏"}`) {
+		t.Errorf("video = %s", b)
+	}
+}